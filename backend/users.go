@@ -45,23 +45,44 @@ type AuthResponse struct {
 	IsAdmin   bool
 }
 
-type User struct {
-	Id        int
-	Email     string
-	Creation  time.Time
-	LastLogin time.Time
-	FirstName string
-	LastName  string
-}
+type StatusType int
 
+const (
+	Active StatusType = iota
+	Suspended
+)
+
+type UnitSystem int
+
+type User struct {
+	Id              int
+	Email           string
+	Status          StatusType
+	LastLogin       time.Time
+	FirstName       string
+	LastName        string
+	PrimaryFamilyId int
+	Units           UnitSystem
+	Creation        time.Time
+}
+
+// The layout is shared with src/login.go, which owns the migration that
+// brought records written by the old version 1 layout here into it.
 func PackUser(self *User, buf *vpack.Buffer) {
-	vpack.Version(1, buf)
+	version := vpack.Version(3, buf)
 	vpack.Int(&self.Id, buf)
 	vpack.String(&self.Email, buf)
-	vpack.Time(&self.Creation, buf)
+	vpack.IntEnum(&self.Status, buf)
 	vpack.Time(&self.LastLogin, buf)
 	vpack.String(&self.FirstName, buf)
 	vpack.String(&self.LastName, buf)
+	vpack.Int(&self.PrimaryFamilyId, buf)
+	if version >= 2 {
+		vpack.IntEnum(&self.Units, buf)
+	}
+	if version >= 3 {
+		vpack.Time(&self.Creation, buf)
+	}
 }
 
 // Buckets
@@ -70,7 +91,7 @@ func PackUser(self *User, buf *vpack.Buffer) {
 var UsersBkt = vbolt.Bucket(&db.Info, "users", vpack.FInt, PackUser)
 
 // user id => hashed passwd
-var PasswdBkt = vbolt.Bucket(&db.Info, "passwd", vpack.FInt, vpack.ByteSlice)
+var PasswdBkt = vbolt.Bucket(&db.Info, "password", vpack.FInt, vpack.ByteSlice)
 
 // username => userid
 var EmailBkt = vbolt.Bucket(&db.Info, "email", vpack.StringZ, vpack.Int)
@@ -118,6 +139,7 @@ func AddUserTx(tx *vbolt.Tx, req AddUserRequest, hash []byte) User {
 	var user User
 	user.Id = vbolt.NextIntId(tx, UsersBkt)
 	user.Email = req.Email
+	user.Status = Active
 	user.FirstName = req.FirstName
 	user.LastName = req.LastName
 	user.Creation = time.Now()
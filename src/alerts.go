@@ -0,0 +1,321 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+type GrowthAlertType int
+
+const (
+	PercentileCrossing GrowthAlertType = iota
+	NewbornWeightLoss
+)
+
+const (
+	// crossing this many of bandPercentiles within crossingWindowDays is flagged,
+	// whether at once or a line at a time
+	majorCrossingLines = 2
+	crossingWindowDays = 365
+	// newborns normally lose up to 10% of their birth weight and regain it by two weeks
+	newbornMaxWeightLoss  = 0.10
+	birthWeightRegainDays = 14
+	newbornWatchDays      = 28
+)
+
+type GrowthAlert struct {
+	Id        int
+	PersonId  int
+	Type      GrowthAlertType
+	Kind      MeasurementKind
+	Key       string // what triggered it, so analysing again does not duplicate it
+	Message   string
+	Date      time.Time
+	CreatedAt time.Time
+	Dismissed bool
+}
+
+func PackGrowthAlert(self *GrowthAlert, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.IntEnum(&self.Type, buf)
+	vpack.IntEnum(&self.Kind, buf)
+	vpack.String(&self.Key, buf)
+	vpack.String(&self.Message, buf)
+	vpack.Time(&self.Date, buf)
+	vpack.Time(&self.CreatedAt, buf)
+	vpack.Bool(&self.Dismissed, buf)
+}
+
+var GrowthAlertBucket = vbolt.Bucket(&Info, "growth_alerts", vpack.FInt, PackGrowthAlert)
+
+// GrowthAlertIndex term: person id, priority: measurement date, target: alert id
+var GrowthAlertIndex = vbolt.IndexExt(&Info, "growth_alerts_by", vpack.FInt, vpack.UnixTimeKey, vpack.FInt)
+
+// GrowthVelocity is the rate of change between two consecutive measurements
+type GrowthVelocity struct {
+	Label       string
+	ToId        int
+	From        time.Time
+	To          time.Time
+	PerYear     float64 // metric units per year
+	DisplayRate float64
+	DisplayUnit string
+}
+
+// growthVelocities works on measurements of one kind in date order
+func growthVelocities(measurements []Measurement, system UnitSystem) (velocities []GrowthVelocity) {
+	for i := 1; i < len(measurements); i++ {
+		prev, cur := measurements[i-1], measurements[i]
+		// dates rather than ages, which jump when age correction ends
+		years := cur.Date.Sub(prev.Date).Hours() / (365.25 * 24)
+		if years <= 0 {
+			continue
+		}
+		unit := cur.Kind.Info().Unit(system)
+		perYear := (cur.Value - prev.Value) / years
+		velocities = append(velocities, GrowthVelocity{
+			Label:       cur.Kind.Info().Label,
+			ToId:        cur.Id,
+			From:        prev.Date,
+			To:          cur.Date,
+			PerYear:     perYear,
+			DisplayRate: perYear / unit.Scale,
+			DisplayUnit: unit.Symbol + "/yr",
+		})
+	}
+	return
+}
+
+// percentileLinesCrossed counts the band percentiles between two percentiles
+func percentileLinesCrossed(from float64, to float64) (lines int) {
+	for _, line := range bandPercentiles {
+		if (from < line) != (to < line) {
+			lines++
+		}
+	}
+	return
+}
+
+// percentileBaseline finds the measurement before series[i], within the
+// crossing window, that it has crossed the most percentile lines from
+func percentileBaseline(series []Measurement, i int) (baseline Measurement, lines int) {
+	cur := series[i]
+	for j := i - 1; j >= 0; j-- {
+		prev := series[j]
+		if cur.Date.Sub(prev.Date).Hours()/24 > crossingWindowDays {
+			break
+		}
+		if !prev.HasPercentile {
+			continue
+		}
+		if crossed := percentileLinesCrossed(prev.Percentile, cur.Percentile); crossed > lines {
+			baseline, lines = prev, crossed
+		}
+	}
+	return
+}
+
+// detectGrowthAlerts looks for major percentile crossings in any measurement
+// with a growth reference, and for newborn weight loss beyond the norm. A
+// measurement is flagged when it has crossed a line since the one before and
+// is now majorCrossingLines or more from some measurement in the window, so a
+// gradual drift is caught as well as a sudden jump.
+func detectGrowthAlerts(person Person, measurements map[MeasurementKind][]Measurement) (alerts []GrowthAlert) {
+	for _, info := range measurementKinds {
+		series := measurements[info.Kind]
+		for i := 1; i < len(series); i++ {
+			prev, cur := series[i-1], series[i]
+			if !prev.HasPercentile || !cur.HasPercentile || percentileLinesCrossed(prev.Percentile, cur.Percentile) == 0 {
+				continue
+			}
+			baseline, lines := percentileBaseline(series, i)
+			if lines < majorCrossingLines {
+				continue
+			}
+			direction := "rose"
+			if cur.Percentile < baseline.Percentile {
+				direction = "dropped"
+			}
+			alerts = append(alerts, GrowthAlert{
+				PersonId: person.Id,
+				Type:     PercentileCrossing,
+				Kind:     info.Kind,
+				Key:      fmt.Sprintf("crossing:%s:%s", info.Slug, cur.Date.Format("2006-01-02")),
+				Message: fmt.Sprintf("%s's %s %s from the %s percentile on %s to the %s percentile, crossing %d percentile lines",
+					person.Name, strings.ToLower(info.Label), direction,
+					formatPercentile(baseline.Percentile), baseline.Date.Format("Jan 2, 2006"),
+					formatPercentile(cur.Percentile), lines),
+				Date: cur.Date,
+			})
+		}
+	}
+
+	weights := measurements[WeightKind]
+	if len(weights) == 0 || ageInDays(person, weights[0].Date) > 3 {
+		return
+	}
+	birthWeight := weights[0].Value
+	for _, weight := range weights[1:] {
+		days := ageInDays(person, weight.Date)
+		if days > newbornWatchDays {
+			break
+		}
+		loss := (birthWeight - weight.Value) / birthWeight
+		var message string
+		if loss > newbornMaxWeightLoss {
+			message = fmt.Sprintf("%s had lost %.0f%% of their birth weight by day %d", person.Name, loss*100, days)
+		} else if loss > 0 && days > birthWeightRegainDays {
+			message = fmt.Sprintf("%s was still below their birth weight on day %d", person.Name, days)
+		} else {
+			continue
+		}
+		alerts = append(alerts, GrowthAlert{
+			PersonId: person.Id,
+			Type:     NewbornWeightLoss,
+			Kind:     WeightKind,
+			Key:      "newborn:" + weight.Date.Format("2006-01-02"),
+			Message:  message,
+			Date:     weight.Date,
+		})
+	}
+	return
+}
+
+func ageInDays(person Person, date time.Time) int {
+	return int(math.Round(date.Sub(person.Birthday).Hours() / 24))
+}
+
+func getGrowthAlertsTx(tx *vbolt.Tx, personId int) (alerts []GrowthAlert) {
+	var alertIds []int
+	vbolt.ReadTermTargets(tx, GrowthAlertIndex, personId, &alertIds, vbolt.Window{})
+	vbolt.ReadSlice(tx, GrowthAlertBucket, alertIds, &alerts)
+	return
+}
+
+func getActiveGrowthAlertsTx(tx *vbolt.Tx, personId int) (alerts []GrowthAlert) {
+	for _, alert := range getGrowthAlertsTx(tx, personId) {
+		if !alert.Dismissed {
+			alerts = append(alerts, alert)
+		}
+	}
+	return
+}
+
+func deleteGrowthAlertTx(tx *vbolt.Tx, alertId int) {
+	vbolt.Delete(tx, GrowthAlertBucket, alertId)
+	vbolt.SetTargetTermsPlain(tx, GrowthAlertIndex, alertId, nil)
+}
+
+func deleteGrowthAlertsTx(tx *vbolt.Tx, personId int) {
+	for _, alert := range getGrowthAlertsTx(tx, personId) {
+		deleteGrowthAlertTx(tx, alert.Id)
+	}
+}
+
+// refreshGrowthAlertsTx analyses a person's measurements again. Alerts that
+// still apply are kept as they are (dismissed or not), ones that no longer
+// apply are removed, and the new ones are saved and returned.
+func refreshGrowthAlertsTx(tx *vbolt.Tx, personId int) (created []GrowthAlert) {
+	person := getPerson(tx, personId)
+	if person.Id == 0 {
+		deleteGrowthAlertsTx(tx, personId)
+		return
+	}
+	measurements := make(map[MeasurementKind][]Measurement)
+	for kind := range growthReferences {
+		measurements[kind] = queryMeasurementsTx(tx, personId, kind)
+	}
+
+	existing := make(map[string]GrowthAlert)
+	for _, alert := range getGrowthAlertsTx(tx, personId) {
+		existing[alert.Key] = alert
+	}
+	for _, alert := range detectGrowthAlerts(person, measurements) {
+		if _, found := existing[alert.Key]; found {
+			delete(existing, alert.Key)
+			continue
+		}
+		alert.Id = vbolt.NextIntId(tx, GrowthAlertBucket)
+		alert.CreatedAt = time.Now()
+		vbolt.Write(tx, GrowthAlertBucket, alert.Id, &alert)
+		vbolt.SetTargetSingleTermExt(tx, GrowthAlertIndex, alert.Id, alert.Date, alert.PersonId)
+		created = append(created, alert)
+	}
+	for _, stale := range existing {
+		deleteGrowthAlertTx(tx, stale.Id)
+	}
+	return
+}
+
+// emailGrowthAlerts tells the owners of a family about new alerts when the
+// family has opted in.
+func emailGrowthAlerts(familyId int, alerts []GrowthAlert) {
+	if len(alerts) == 0 {
+		return
+	}
+	var recipients []string
+	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
+		family := getFamily(tx, familyId)
+		if !family.GrowthAlertEmails {
+			return
+		}
+		for _, userId := range family.OwningUsers {
+			if email := GetUser(tx, userId).Email; email != "" {
+				recipients = append(recipients, email)
+			}
+		}
+	})
+	if len(recipients) == 0 {
+		return
+	}
+
+	var body strings.Builder
+	body.WriteString("Hello,\r\n\r\nNew growth alerts on the family site:\r\n\r\n")
+	for _, alert := range alerts {
+		body.WriteString("- " + alert.Message + " (" + alert.Date.Format("Jan 2, 2006") + ")\r\n")
+		body.WriteString("  " + os.Getenv("SITE_ROOT") + "/person/" + strconv.Itoa(alert.PersonId) + "\r\n")
+	}
+	if err := sendEmail(recipients, "Growth alert", body.String()); err != nil {
+		log.Printf("Failed to send growth alert email: %v", err)
+	}
+}
+
+func RegisterGrowthAlertPages(mux *http.ServeMux) {
+	mux.Handle("POST /alerts/dismiss/{id}", AuthHandler(ContextFunc(dismissGrowthAlert)))
+}
+
+func dismissGrowthAlert(context ResponseContext) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+
+	var alert GrowthAlert
+	var err error
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		vbolt.Read(tx, GrowthAlertBucket, id, &alert)
+		if alert.Id == 0 || !isFamilyOwner(tx, getPerson(tx, alert.PersonId).FamilyId, context.user.Id) {
+			err = errors.New("not a family owner")
+			return
+		}
+		alert.Dismissed = true
+		vbolt.Write(tx, GrowthAlertBucket, alert.Id, &alert)
+		vbolt.TxCommit(tx)
+	})
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(context.w, context.r, "/person/"+strconv.Itoa(alert.PersonId), http.StatusFound)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func percentileSeries(start time.Time, monthsApart int, percentiles ...float64) (series []Measurement) {
+	for i, percentile := range percentiles {
+		series = append(series, Measurement{
+			Kind:          WeightKind,
+			Date:          start.AddDate(0, i*monthsApart, 0),
+			Percentile:    percentile,
+			HasPercentile: true,
+		})
+	}
+	return
+}
+
+func TestDetectGradualCrossing(t *testing.T) {
+	person := Person{Id: 3, Name: "Maia", Birthday: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	start := person.Birthday.AddDate(0, 6, 0)
+
+	// a line at a time: below the 50th, then below the 25th
+	alerts := detectGrowthAlerts(person, map[MeasurementKind][]Measurement{
+		WeightKind: percentileSeries(start, 2, 50, 30, 20, 20),
+	})
+	if len(alerts) != 1 || alerts[0].Date != start.AddDate(0, 4, 0) {
+		t.Fatalf("expected one alert at the third measurement, got %+v", alerts)
+	}
+	if !strings.Contains(alerts[0].Message, "dropped from the 50th") {
+		t.Errorf("expected the message to name the starting percentile: %s", alerts[0].Message)
+	}
+
+	// the same drift spread over more than the window is normal catch-down
+	if alerts := detectGrowthAlerts(person, map[MeasurementKind][]Measurement{
+		WeightKind: percentileSeries(start, 8, 50, 30, 20),
+	}); len(alerts) != 0 {
+		t.Errorf("expected no alerts for a slow drift, got %+v", alerts)
+	}
+}
+
+// TestCorrectionCutoffAlert checks that the step in age when correction for
+// prematurity stops is not reported as a growth problem
+func TestCorrectionCutoffAlert(t *testing.T) {
+	// smooth test curve shaped like an infant weight reference
+	curve := []LMSPoint{{At: 12, L: 0, M: 9.6, S: 0.115}, {At: 24, L: 0, M: 12.2, S: 0.115}, {At: 36, L: 0, M: 14.3, S: 0.115}}
+	withReferencePoints(t, growthReferences[WeightKind], Male, curve)
+
+	// eleven weeks early, growing along the median for the corrected age
+	person := Person{Id: 3, Name: "Theo", Gender: Male, Birthday: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+	person.DueDate = person.Birthday.AddDate(0, 0, 77)
+	cutoff := person.Birthday.AddDate(0, defaultCorrectUntilMonths, 0)
+	var weights []Measurement
+	for _, date := range []time.Time{cutoff.AddDate(0, -6, 0), cutoff.AddDate(0, -3, 0), cutoff.AddDate(0, 0, -14), cutoff.AddDate(0, 0, 14), cutoff.AddDate(0, 3, 0)} {
+		correctedMonths := date.Sub(person.DueDate).Hours() / (365.25 * 24) * 12
+		lms, _ := ageLMS(growthReferences[WeightKind], Male, correctedMonths)
+		weight := Measurement{Kind: WeightKind, Date: date, Value: lms.M, Age: person.AgeAt(date)}
+		weight.ZScore, weight.Percentile, weight.HasPercentile = growthPercentile(WeightKind, Male, weight.Age, weight.Value)
+		weights = append(weights, weight)
+	}
+	if before, after := weights[2].Percentile, weights[3].Percentile; math.Abs(before-50) > 0.1 || after > 45 {
+		t.Fatalf("expected the percentile to step down at the cutoff, got %.1f then %.1f", before, after)
+	}
+
+	if alerts := detectGrowthAlerts(person, map[MeasurementKind][]Measurement{WeightKind: weights}); len(alerts) != 0 {
+		t.Errorf("expected no alerts across the cutoff, got %+v", alerts)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+)
+
+// CDC 2000 BMI-for-age, 2 to 20 years, At is age in months; the points are
+// bmiagerev.csv from growthdata/
+var bmiReferences = []GrowthReference{{Source: "CDC", Gender: Male}, {Source: "CDC", Gender: Female}}
+
+// WHO weight-for-length, for children under 2, At is length in cm; the
+// points are the 0.5 cm tables from growthdata/
+var weightForLengthReferences = []GrowthReference{{Source: "WHO", Gender: Male}, {Source: "WHO", Gender: Female}}
+
+// a weight is only paired with a height outside the recorded heights when
+// the nearest one is this close, in years
+const maxPairingGap = 60.0 / 365.25
+
+var UnitBMI = Unit{Symbol: "kg/m²", Scale: 1}
+
+// BodyMassPoint is a BMI derived from a weight and the height at the same age.
+// Under 2 the percentile is weight-for-length, from 2 on it is BMI-for-age.
+type BodyMassPoint struct {
+	Date       time.Time
+	DateString string
+	Age        float64
+	PersonName string
+	HeightCm   float64
+	WeightKg   float64
+	Value      float64 // BMI
+
+	DisplayValue  float64
+	DisplayHeight float64
+	DisplayWeight float64
+	Basis         string
+	ZScore        float64
+	Percentile    float64
+	HasPercentile bool
+}
+
+// heightAtAge interpolates between the heights either side of an age, or uses
+// the nearest height when it is within maxPairingGap. heights are in date order.
+func heightAtAge(heights []Measurement, age float64) (float64, bool) {
+	for i, height := range heights {
+		if height.Age < age {
+			continue
+		}
+		if height.Age == age {
+			return height.Value, true
+		}
+		if i == 0 {
+			return height.Value, height.Age-age <= maxPairingGap
+		}
+		prev := heights[i-1]
+		return interpolate(prev.Age, prev.Value, height.Age, height.Value, age), true
+	}
+	if len(heights) > 0 {
+		last := heights[len(heights)-1]
+		return last.Value, age-last.Age <= maxPairingGap
+	}
+	return 0, false
+}
+
+// bodyMassSeries pairs every weight with a height; weights too far from any
+// height are skipped.
+func bodyMassSeries(heights []Measurement, weights []Measurement, gender GenderType) (points []BodyMassPoint) {
+	for _, weight := range weights {
+		heightCm, found := heightAtAge(heights, weight.Age)
+		if !found || heightCm <= 0 {
+			continue
+		}
+		meters := heightCm / 100
+		point := BodyMassPoint{
+			Date:       weight.Date,
+			DateString: weight.DateString,
+			Age:        weight.Age,
+			PersonName: weight.PersonName,
+			HeightCm:   heightCm,
+			WeightKg:   weight.Value,
+			Value:      weight.Value / (meters * meters),
+		}
+
+		var lms LMSPoint
+		if weight.Age < 2 {
+			point.Basis = "weight-for-length"
+			reference, _ := findGrowthReference(weightForLengthReferences, "WHO", gender)
+			if lms, found = reference.LMS(heightCm); found {
+				point.ZScore = lms.ZScore(weight.Value)
+			}
+		} else {
+			point.Basis = "BMI-for-age"
+			if lms, found = ageLMS(bmiReferences, gender, weight.Age*12); found {
+				point.ZScore = lms.ZScore(point.Value)
+			}
+		}
+		if found {
+			point.Percentile = zScoreToPercentile(point.ZScore)
+			point.HasPercentile = true
+		}
+		points = append(points, point)
+	}
+	return
+}
+
+func queryBodyMassTx(tx *vbolt.Tx, personId int, system UnitSystem) []BodyMassPoint {
+	person := getPerson(tx, personId)
+	points := bodyMassSeries(
+		queryMeasurementsTx(tx, personId, HeightKind),
+		queryMeasurementsTx(tx, personId, WeightKind),
+		person.Gender,
+	)
+	height, weight := HeightKind.Info().Unit(system), WeightKind.Info().Unit(system)
+	for i := range points {
+		points[i].DisplayValue = points[i].Value
+		points[i].DisplayHeight = height.FromCanonical(points[i].HeightCm)
+		points[i].DisplayWeight = weight.FromCanonical(points[i].WeightKg)
+	}
+	return points
+}
+
+type BodyMassSeries struct {
+	PersonName   string
+	Unit         string
+	Measurements []BodyMassPoint
+	Bands        []PercentileBand
+}
+
+func RegisterBodyMassPages(mux *http.ServeMux) {
+	mux.Handle("GET /bmi", PublicHandler(ContextFunc(bodyMassPage)))
+	mux.Handle("GET /bmi/table/{id}", PublicHandler(ContextFunc(bodyMassTablePage)))
+	mux.Handle("GET /api/bmi/{id}", PublicHandler(ContextFunc(bodyMassApi)))
+}
+
+func bodyMassPage(context ResponseContext) {
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		RenderTemplateWithData(context, "bmi", map[string]any{
+			"People": getPeopleInFamily(tx, context.user.PrimaryFamilyId),
+		})
+	})
+}
+
+func bodyMassTablePage(context ResponseContext) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	system := requestUnits(context)
+	var points []BodyMassPoint
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		points = queryBodyMassTx(tx, personId, system)
+	})
+	RenderTemplateWithData(context, "bmi-table", map[string]any{
+		"Points":     points,
+		"HeightUnit": HeightKind.Info().Unit(system),
+		"WeightUnit": WeightKind.Info().Unit(system),
+	})
+}
+
+func bodyMassApi(context ResponseContext) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	system := requestUnits(context)
+
+	var series BodyMassSeries
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person := getPerson(tx, personId)
+		points := queryBodyMassTx(tx, personId, system)
+
+		maxAge := 0.0
+		for _, point := range points {
+			maxAge = max(maxAge, point.Age)
+		}
+		series = BodyMassSeries{
+			PersonName:   person.Name,
+			Unit:         UnitBMI.Symbol,
+			Measurements: points,
+			Bands:        percentileBands(bmiReferences, person.Gender, maxAge+1, UnitBMI),
+		}
+	})
+	context.w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(context.w).Encode(series)
+}
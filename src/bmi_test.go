@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// withReferencePoints puts test points into a reference for the length of a test
+func withReferencePoints(t *testing.T, references []GrowthReference, gender GenderType, points []LMSPoint) {
+	for i := range references {
+		if references[i].Gender == gender {
+			saved := references[i].Points
+			references[i].Points = points
+			t.Cleanup(func() { references[i].Points = saved })
+		}
+	}
+}
+
+func TestHeightAtAge(t *testing.T) {
+	heights := []Measurement{{Age: 1, Value: 75}, {Age: 2, Value: 87}}
+	cases := []struct {
+		age    float64
+		height float64
+		found  bool
+	}{
+		{1, 75, true},
+		{1.5, 81, true},
+		{0.9, 75, true},  // before the first height, inside maxPairingGap
+		{0.7, 75, false}, // too long before it
+		{2.1, 87, true},
+		{2.5, 87, false},
+	}
+	for _, c := range cases {
+		height, found := heightAtAge(heights, c.age)
+		if found != c.found || (found && math.Abs(height-c.height) > 1e-9) {
+			t.Errorf("age %g: expected %g %v, got %g %v", c.age, c.height, c.found, height, found)
+		}
+	}
+	if _, found := heightAtAge(nil, 1); found {
+		t.Error("expected no height without any heights")
+	}
+}
+
+func TestBodyMassSeries(t *testing.T) {
+	withReferencePoints(t, weightForLengthReferences, Male, []LMSPoint{{At: 80, L: 1, M: 10, S: 0.1}, {At: 82, L: 1, M: 10.4, S: 0.1}})
+	withReferencePoints(t, bmiReferences, Male, []LMSPoint{{At: 24, L: 1, M: 16, S: 0.1}, {At: 36, L: 1, M: 15.6, S: 0.1}})
+
+	heights := []Measurement{{Age: 1, Value: 75}, {Age: 2, Value: 87}}
+	weights := []Measurement{{Age: 1.5, Value: 10}, {Age: 2, Value: 12}, {Age: 3, Value: 14}}
+	points := bodyMassSeries(heights, weights, Male)
+	if len(points) != 2 {
+		t.Fatalf("expected the weight at 3, a year after the last height, to be skipped: %+v", points)
+	}
+
+	// under 2: weight against the 10.2 kg median for 81 cm
+	if point := points[0]; point.Basis != "weight-for-length" || !point.HasPercentile || math.Abs(point.ZScore-(10/10.2-1)/0.1) > 1e-9 {
+		t.Errorf("unexpected point under 2: %+v", point)
+	}
+	// from 2: BMI against the 16 median at 24 months
+	bmi := 12 / (0.87 * 0.87)
+	if point := points[1]; point.Basis != "BMI-for-age" || math.Abs(point.Value-bmi) > 1e-9 || math.Abs(point.ZScore-(bmi/16-1)/0.1) > 1e-9 {
+		t.Errorf("unexpected point at 2: %+v", point)
+	}
+
+	// no percentile without a reference for the sex
+	if points := bodyMassSeries(heights, weights, Female); len(points) != 2 || points[0].HasPercentile || points[1].HasPercentile {
+		t.Errorf("expected no percentiles without references: %+v", points)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+)
+
+// Developmental checklists after the CDC's "Learn the Signs. Act Early."
+// milestones: what most children (75% or more) do by each age. Checking an
+// item records a Milestone; an item not checked by its age is overdue.
+
+// ChecklistItem is one line of a checklist. Type links it to the catalog type
+// it records, when there is one; items without one record a MilestoneChecklistItem.
+type ChecklistItem struct {
+	Id       string
+	Months   int
+	Category MilestoneCategory
+	Label    string
+	Type     MilestoneType
+}
+
+var checklistItems = []ChecklistItem{
+	{Id: "2m-calms", Months: 2, Category: CategorySocial, Label: "Calms down when spoken to or picked up"},
+	{Id: "2m-face", Months: 2, Category: CategorySocial, Label: "Looks at your face"},
+	{Id: "2m-smiles", Months: 2, Category: CategorySocial, Label: "Smiles when you talk to or smile at them", Type: MilestoneFirstSmile},
+	{Id: "2m-sounds", Months: 2, Category: CategoryLanguage, Label: "Makes sounds other than crying"},
+	{Id: "2m-watches", Months: 2, Category: CategoryCognitive, Label: "Watches you as you move"},
+	{Id: "2m-head-up", Months: 2, Category: CategoryMotor, Label: "Holds head up when on tummy"},
+	{Id: "2m-hands", Months: 2, Category: CategoryMotor, Label: "Opens hands briefly"},
+
+	{Id: "4m-chuckles", Months: 4, Category: CategorySocial, Label: "Chuckles when you try to make them laugh"},
+	{Id: "4m-coos", Months: 4, Category: CategoryLanguage, Label: `Makes sounds like "oooo" and "aahh" (cooing)`},
+	{Id: "4m-turns", Months: 4, Category: CategoryLanguage, Label: "Turns head towards the sound of your voice"},
+	{Id: "4m-hands", Months: 4, Category: CategoryCognitive, Label: "Looks at their hands with interest"},
+	{Id: "4m-head-steady", Months: 4, Category: CategoryMotor, Label: "Holds head steady without support when held"},
+	{Id: "4m-elbows", Months: 4, Category: CategoryMotor, Label: "Pushes up onto elbows when on tummy"},
+	{Id: "4m-mouth", Months: 4, Category: CategoryMotor, Label: "Brings hands to mouth"},
+
+	{Id: "6m-familiar", Months: 6, Category: CategorySocial, Label: "Knows familiar people"},
+	{Id: "6m-laughs", Months: 6, Category: CategorySocial, Label: "Laughs"},
+	{Id: "6m-turns", Months: 6, Category: CategoryLanguage, Label: "Takes turns making sounds with you"},
+	{Id: "6m-squeals", Months: 6, Category: CategoryLanguage, Label: "Squeals"},
+	{Id: "6m-reaches", Months: 6, Category: CategoryCognitive, Label: "Reaches to grab a toy they want"},
+	{Id: "6m-rolls", Months: 6, Category: CategoryMotor, Label: "Rolls from tummy to back", Type: MilestoneRollingOver},
+	{Id: "6m-leans", Months: 6, Category: CategoryMotor, Label: "Leans on hands to support themselves when sitting"},
+
+	{Id: "9m-strangers", Months: 9, Category: CategorySocial, Label: "Is shy, clingy or fearful around strangers"},
+	{Id: "9m-reacts", Months: 9, Category: CategorySocial, Label: "Reacts when you leave"},
+	{Id: "9m-babbles", Months: 9, Category: CategoryLanguage, Label: `Makes sounds like "mamamama" and "babababa"`, Type: MilestoneBabbling},
+	{Id: "9m-arms", Months: 9, Category: CategoryLanguage, Label: "Lifts arms up to be picked up"},
+	{Id: "9m-looks", Months: 9, Category: CategoryCognitive, Label: "Looks for objects when dropped out of sight"},
+	{Id: "9m-bangs", Months: 9, Category: CategoryCognitive, Label: "Bangs two things together"},
+	{Id: "9m-sits", Months: 9, Category: CategoryMotor, Label: "Sits without support", Type: MilestoneSitting},
+	{Id: "9m-hands", Months: 9, Category: CategoryMotor, Label: "Moves things from one hand to the other"},
+
+	{Id: "12m-games", Months: 12, Category: CategorySocial, Label: "Plays games with you, like pat-a-cake"},
+	{Id: "12m-waves", Months: 12, Category: CategoryLanguage, Label: "Waves bye-bye", Type: MilestoneWaving},
+	{Id: "12m-mama", Months: 12, Category: CategoryLanguage, Label: `Calls a parent "mama", "dada" or another special name`, Type: MilestoneFirstWord},
+	{Id: "12m-hide", Months: 12, Category: CategoryCognitive, Label: "Looks for things they see you hide"},
+	{Id: "12m-pulls-up", Months: 12, Category: CategoryMotor, Label: "Pulls up to stand"},
+	{Id: "12m-cruises", Months: 12, Category: CategoryMotor, Label: "Walks holding on to furniture"},
+	{Id: "12m-pincer", Months: 12, Category: CategoryMotor, Label: "Picks things up between thumb and pointer finger", Type: MilestonePincerGrasp},
+
+	{Id: "15m-shows", Months: 15, Category: CategorySocial, Label: "Shows you an object they like"},
+	{Id: "15m-claps", Months: 15, Category: CategorySocial, Label: "Claps when excited"},
+	{Id: "15m-words", Months: 15, Category: CategoryLanguage, Label: `Tries to say one or two words besides "mama" or "dada"`},
+	{Id: "15m-points", Months: 15, Category: CategoryLanguage, Label: "Points to ask for something or to get help"},
+	{Id: "15m-uses", Months: 15, Category: CategoryCognitive, Label: "Tries to use things the right way, like a phone or a book"},
+	{Id: "15m-steps", Months: 15, Category: CategoryMotor, Label: "Takes a few steps on their own", Type: MilestoneWalking},
+	{Id: "15m-feeds", Months: 15, Category: CategoryMotor, Label: "Uses fingers to feed themselves"},
+
+	{Id: "18m-points", Months: 18, Category: CategorySocial, Label: "Points to show you something interesting"},
+	{Id: "18m-dress", Months: 18, Category: CategorySocial, Label: "Helps you dress them by pushing an arm through a sleeve"},
+	{Id: "18m-words", Months: 18, Category: CategoryLanguage, Label: `Tries to say three or more words besides "mama" or "dada"`},
+	{Id: "18m-directions", Months: 18, Category: CategoryLanguage, Label: "Follows one-step directions without gestures"},
+	{Id: "18m-plays", Months: 18, Category: CategoryCognitive, Label: "Plays with toys in a simple way, like pushing a toy car"},
+	{Id: "18m-walks", Months: 18, Category: CategoryMotor, Label: "Walks without holding on to anyone or anything"},
+	{Id: "18m-scribbles", Months: 18, Category: CategoryMotor, Label: "Scribbles"},
+	{Id: "18m-cup", Months: 18, Category: CategoryMotor, Label: "Drinks from a cup without a lid"},
+
+	{Id: "24m-notices", Months: 24, Category: CategorySocial, Label: "Notices when others are hurt or upset"},
+	{Id: "24m-book", Months: 24, Category: CategoryLanguage, Label: "Points to things in a book when asked"},
+	{Id: "24m-phrases", Months: 24, Category: CategoryLanguage, Label: `Says at least two words together, like "more milk"`, Type: MilestonePhrases},
+	{Id: "24m-two-hands", Months: 24, Category: CategoryCognitive, Label: "Holds something in one hand while using the other"},
+	{Id: "24m-kicks", Months: 24, Category: CategoryMotor, Label: "Kicks a ball"},
+	{Id: "24m-runs", Months: 24, Category: CategoryMotor, Label: "Runs"},
+	{Id: "24m-stairs", Months: 24, Category: CategoryMotor, Label: "Walks up a few stairs with or without help"},
+
+	{Id: "30m-plays", Months: 30, Category: CategorySocial, Label: "Plays next to and sometimes with other children"},
+	{Id: "30m-words", Months: 30, Category: CategoryLanguage, Label: "Says about 50 words"},
+	{Id: "30m-names", Months: 30, Category: CategoryLanguage, Label: "Names things in a book when you point and ask"},
+	{Id: "30m-pretend", Months: 30, Category: CategoryCognitive, Label: "Uses things to pretend, like feeding a doll"},
+	{Id: "30m-twists", Months: 30, Category: CategoryMotor, Label: "Uses hands to twist things, like doorknobs"},
+	{Id: "30m-jumps", Months: 30, Category: CategoryMotor, Label: "Jumps off the ground with both feet"},
+
+	{Id: "36m-joins", Months: 36, Category: CategorySocial, Label: "Notices other children and joins them to play"},
+	{Id: "36m-conversation", Months: 36, Category: CategoryLanguage, Label: "Talks with you in conversation of at least two back-and-forth exchanges"},
+	{Id: "36m-name", Months: 36, Category: CategoryLanguage, Label: "Says first name when asked"},
+	{Id: "36m-circle", Months: 36, Category: CategoryCognitive, Label: "Draws a circle when you show them how"},
+	{Id: "36m-strings", Months: 36, Category: CategoryMotor, Label: "Strings items together, like large beads"},
+	{Id: "36m-dresses", Months: 36, Category: CategoryMotor, Label: "Puts on some clothes by themselves"},
+
+	{Id: "48m-comforts", Months: 48, Category: CategorySocial, Label: "Comforts others who are hurt or sad"},
+	{Id: "48m-sentences", Months: 48, Category: CategoryLanguage, Label: "Says sentences with four or more words"},
+	{Id: "48m-story", Months: 48, Category: CategoryLanguage, Label: "Tells what comes next in a well-known story"},
+	{Id: "48m-colors", Months: 48, Category: CategoryCognitive, Label: "Names a few colors of items"},
+	{Id: "48m-catches", Months: 48, Category: CategoryMotor, Label: "Catches a large ball most of the time"},
+	{Id: "48m-unbuttons", Months: 48, Category: CategoryMotor, Label: "Unbuttons some buttons"},
+
+	{Id: "60m-rules", Months: 60, Category: CategorySocial, Label: "Follows rules or takes turns when playing games"},
+	{Id: "60m-story", Months: 60, Category: CategoryLanguage, Label: "Tells a story they heard or made up with at least two events"},
+	{Id: "60m-conversation", Months: 60, Category: CategoryLanguage, Label: "Keeps a conversation going with more than three back-and-forth exchanges"},
+	{Id: "60m-counts", Months: 60, Category: CategoryCognitive, Label: "Counts to 10"},
+	{Id: "60m-letters", Months: 60, Category: CategoryCognitive, Label: "Writes some letters in their name"},
+	{Id: "60m-buttons", Months: 60, Category: CategoryMotor, Label: "Buttons some buttons"},
+	{Id: "60m-hops", Months: 60, Category: CategoryMotor, Label: "Hops on one foot"},
+}
+
+const (
+	// items this close to their age are shown as coming up
+	checklistUpcomingMonths = 3
+	// the checklists stop at five; past six a child's page leaves them out
+	checklistMaxMonths = 72
+)
+
+func findChecklistItem(id string) (ChecklistItem, bool) {
+	for _, item := range checklistItems {
+		if item.Id == id {
+			return item, true
+		}
+	}
+	return ChecklistItem{}, false
+}
+
+func checklistBandLabel(months int) string {
+	if months >= 24 && months%12 == 0 {
+		return pluralize(float64(months/12), "year")
+	}
+	return pluralize(float64(months), "month")
+}
+
+type ChecklistStatus int
+
+const (
+	ChecklistLater ChecklistStatus = iota
+	ChecklistUpcoming
+	ChecklistOverdue
+	ChecklistAchieved
+)
+
+type ChecklistEntry struct {
+	Item      ChecklistItem
+	Status    ChecklistStatus
+	Milestone Milestone // set when achieved
+}
+
+func (entry ChecklistEntry) Achieved() bool { return entry.Status == ChecklistAchieved }
+func (entry ChecklistEntry) Overdue() bool  { return entry.Status == ChecklistOverdue }
+func (entry ChecklistEntry) Upcoming() bool { return entry.Status == ChecklistUpcoming }
+
+// FromChecklist is whether the milestone was checked from this item; one of
+// the item's type recorded elsewhere is only shown, never changed from here
+func (entry ChecklistEntry) FromChecklist() bool {
+	return entry.Achieved() && entry.Milestone.Checklist == entry.Item.Id
+}
+
+type ChecklistBand struct {
+	Months   int
+	Label    string
+	Entries  []ChecklistEntry
+	Achieved int
+}
+
+// buildChecklist sets the status of every item for a child of ageMonths
+// (corrected) with the given milestones. An item counts as achieved by a
+// milestone checked from it, or by any milestone of its catalog type.
+func buildChecklist(milestones []Milestone, ageMonths float64) (bands []ChecklistBand) {
+	byItem := make(map[string]Milestone)
+	byType := make(map[MilestoneType]Milestone)
+	for _, milestone := range milestones {
+		if milestone.Checklist != "" {
+			byItem[milestone.Checklist] = milestone
+		} else if _, found := byType[milestone.Type]; !found {
+			byType[milestone.Type] = milestone
+		}
+	}
+
+	for _, item := range checklistItems {
+		if len(bands) == 0 || bands[len(bands)-1].Months != item.Months {
+			bands = append(bands, ChecklistBand{Months: item.Months, Label: checklistBandLabel(item.Months)})
+		}
+		band := &bands[len(bands)-1]
+		entry := ChecklistEntry{Item: item}
+		milestone, found := byItem[item.Id]
+		if !found && item.Type > MilestoneWeight {
+			milestone, found = byType[item.Type]
+		}
+		switch {
+		case found:
+			entry.Status = ChecklistAchieved
+			entry.Milestone = milestone
+			band.Achieved++
+		case ageMonths >= float64(item.Months):
+			entry.Status = ChecklistOverdue
+		case ageMonths >= float64(item.Months-checklistUpcomingMonths):
+			entry.Status = ChecklistUpcoming
+		}
+		band.Entries = append(band.Entries, entry)
+	}
+	return
+}
+
+// checklistDue picks out the upcoming and overdue items
+func checklistDue(bands []ChecklistBand) (upcoming []ChecklistEntry, overdue []ChecklistEntry) {
+	for _, band := range bands {
+		for _, entry := range band.Entries {
+			switch entry.Status {
+			case ChecklistUpcoming:
+				upcoming = append(upcoming, entry)
+			case ChecklistOverdue:
+				overdue = append(overdue, entry)
+			}
+		}
+	}
+	return
+}
+
+// personChecklistTx builds a child's checklist as of today
+func personChecklistTx(tx *vbolt.Tx, person Person) []ChecklistBand {
+	return buildChecklist(queryMilestonesTx(tx, person.Id), person.AgeAt(time.Now())*12)
+}
+
+func showChecklist(person Person) bool {
+	return person.Type == Child && person.AgeAt(time.Now())*12 < checklistMaxMonths
+}
+
+func RegisterChecklistPages(mux *http.ServeMux) {
+	mux.Handle("GET /checklists/{id}", AuthHandler(ContextFunc(checklistPage)))
+	mux.Handle("GET /checklists/{id}/print", AuthHandler(ContextFunc(checklistPrintPage)))
+	mux.Handle("POST /checklists/{id}", AuthHandler(ContextFunc(saveChecklistItem)))
+}
+
+func renderChecklist(context ResponseContext, templateName string) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person := getPerson(tx, personId)
+		if person.Id == 0 || !isFamilyMemberTx(tx, context, person.FamilyId) {
+			http.Error(context.w, "not a family member", http.StatusUnauthorized)
+			return
+		}
+		context.familyId = person.FamilyId
+		bands := personChecklistTx(tx, person)
+		upcoming, overdue := checklistDue(bands)
+		RenderTemplateWithData(context, templateName, map[string]any{
+			"Person":   person,
+			"Bands":    bands,
+			"Upcoming": upcoming,
+			"Overdue":  overdue,
+			"Today":    time.Now(),
+		})
+	})
+}
+
+func checklistPage(context ResponseContext) {
+	renderChecklist(context, "checklist")
+}
+
+func checklistPrintPage(context ResponseContext) {
+	renderChecklist(context, "checklist-print")
+}
+
+// saveChecklistItem checks an item, recording a milestone on the given date,
+// or unchecks it, moving that milestone to the trash. Items achieved by a
+// milestone recorded elsewhere are left alone.
+func saveChecklistItem(context ResponseContext) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	form := bindForm(context.r)
+	item, found := findChecklistItem(form.Value("item"))
+	if !found {
+		http.Error(context.w, "unknown checklist item", http.StatusBadRequest)
+		return
+	}
+	uncheck := form.Value("action") == "uncheck"
+	date := time.Now().Truncate(24 * time.Hour)
+	if !uncheck && form.Value("date") != "" {
+		date = form.Date("date", "Date", false)
+	}
+
+	var person Person
+	var isOwner bool
+	var conflict string
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		person = getPerson(tx, personId)
+		isOwner = isFamilyOwner(tx, person.FamilyId, context.user.Id)
+		if !isOwner {
+			return
+		}
+		form.DuringLife("date", "Date", date, person)
+		if !form.Valid() {
+			return
+		}
+
+		var existing Milestone
+		for _, band := range buildChecklist(queryMilestonesTx(tx, person.Id), 0) {
+			for _, checked := range band.Entries {
+				if checked.Item.Id != item.Id || !checked.Achieved() {
+					continue
+				}
+				if !checked.FromChecklist() {
+					conflict = fmt.Sprintf("%q is recorded as a milestone on %s; change it on the milestones page", item.Label, checked.Milestone.Date.Format("Jan 2, 2006"))
+					return
+				}
+				existing = checked.Milestone
+			}
+		}
+		if uncheck {
+			if existing.Id > 0 {
+				trashMilestoneTx(tx, existing, person.FamilyId, context.user.Id)
+			}
+			vbolt.TxCommit(tx)
+			return
+		}
+
+		milestone := existing
+		if milestone.Id == 0 {
+			milestone = Milestone{PersonId: person.Id, Type: item.Type, TextValue: item.Label, Checklist: item.Id}
+			if item.Type <= MilestoneWeight {
+				milestone.Type = MilestoneChecklistItem
+			}
+		}
+		milestone.Date = date
+		milestone.Age = person.AgeAt(date)
+		saveMilestoneTx(tx, &milestone)
+		vbolt.TxCommit(tx)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+	if conflict != "" {
+		http.Error(context.w, conflict, http.StatusBadRequest)
+		return
+	}
+	if !form.Valid() {
+		http.Error(context.w, form.Errors["date"], http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(context.w, context.r, fmt.Sprintf("/checklists/%d#months-%d", person.Id, item.Months), http.StatusFound)
+}
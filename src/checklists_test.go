@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildChecklist(t *testing.T) {
+	walked := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	milestones := []Milestone{
+		{Id: 1, Type: MilestoneChecklistItem, Checklist: "9m-arms", Date: walked},
+		{Id: 2, Type: MilestoneWalking, Date: walked},
+	}
+	bands := buildChecklist(milestones, 13)
+
+	status := make(map[string]ChecklistStatus)
+	fromChecklist := make(map[string]bool)
+	for _, band := range bands {
+		for _, entry := range band.Entries {
+			status[entry.Item.Id] = entry.Status
+			fromChecklist[entry.Item.Id] = entry.FromChecklist()
+		}
+	}
+	// only the item's own milestone can be unchecked or redated from the list
+	if !fromChecklist["9m-arms"] || fromChecklist["15m-steps"] {
+		t.Errorf("expected only 9m-arms to be editable from the list: %v", fromChecklist)
+	}
+	expected := map[string]ChecklistStatus{
+		"9m-arms":    ChecklistAchieved, // checked from the list
+		"15m-steps":  ChecklistAchieved, // a walking milestone recorded elsewhere
+		"12m-waves":  ChecklistOverdue,
+		"15m-claps":  ChecklistUpcoming,
+		"18m-walks":  ChecklistLater,
+		"2m-sounds":  ChecklistOverdue,
+		"60m-counts": ChecklistLater,
+	}
+	for id, want := range expected {
+		if status[id] != want {
+			t.Errorf("%s: expected status %d, got %d", id, want, status[id])
+		}
+	}
+
+	upcoming, overdue := checklistDue(bands)
+	if len(upcoming) == 0 || len(overdue) == 0 {
+		t.Errorf("expected upcoming and overdue items, got %d and %d", len(upcoming), len(overdue))
+	}
+	if bands[0].Label != "2 months" || bands[len(bands)-1].Label != "5 years" {
+		t.Errorf("unexpected band labels %q and %q", bands[0].Label, bands[len(bands)-1].Label)
+	}
+	seen := make(map[string]bool)
+	for _, item := range checklistItems {
+		if seen[item.Id] {
+			t.Errorf("duplicate checklist item %s", item.Id)
+		}
+		seen[item.Id] = true
+	}
+}
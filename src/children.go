@@ -2,8 +2,11 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -25,8 +28,15 @@ type PersonType int
 const (
 	Parent PersonType = iota
 	Child
+	Grandparent
+	Guardian
+	ParentSibling
+	Pet
+	OtherRelation
 )
 
+var allPersonTypes = []PersonType{Parent, Child, Grandparent, Guardian, ParentSibling, Pet, OtherRelation}
+
 type VisibilityType int
 
 const (
@@ -57,23 +67,74 @@ func parseVisibilityType(s string) (VisibilityType, error) {
 }
 
 type Family struct {
-	Id          int
-	Name        string
-	Description string
-	ImageId     int
-	OwningUsers []int
-	Visibility  VisibilityType
+	Id                 int
+	Name               string
+	Description        string
+	ImageId            int
+	OwningUsers        []int
+	Visibility         VisibilityType
+	RelationshipLabels []RelationshipLabel
+	Units              UnitSystem
+	GrowthAlertEmails  bool
+	VaccineSchedule    string
+	VaccineReminders   bool
+	CousinFamilies     []int // families this one agrees to compare growth with
+
+	// versions 5 and 6 only; consent is now given per family in CousinFamilies
+	ShareComparisons bool
+}
+
+// RelationshipLabel lets a family rename a person type, e.g. "Oma" for
+// grandparents. An empty label falls back to the gendered default.
+type RelationshipLabel struct {
+	Type  PersonType
+	Label string
 }
 
 type Person struct {
-	Id       int
-	FamilyId int
-	Type     PersonType
-	Gender   GenderType
-	Name     string
-	Birthday time.Time
-	Age      string
-	ImageId  int
+	Id           int
+	FamilyId     int
+	Type         PersonType
+	Gender       GenderType
+	Pronouns     string
+	Name         string
+	Birthday     time.Time
+	Age          string
+	Relationship string
+	ImageId      int
+
+	// DueDate is set for children born early; until CorrectUntilMonths
+	// (defaultCorrectUntilMonths when 0) their age is counted from it.
+	DueDate            time.Time
+	CorrectUntilMonths int
+	CorrectedAge       string
+}
+
+// full term is 40 weeks; ages of premature children are corrected until 2 by default
+const (
+	fullTermWeeks             = 40
+	defaultCorrectUntilMonths = 24
+)
+
+func parsePersonTypeLabel(t PersonType) string {
+	switch t {
+	case Parent:
+		return "parent"
+	case Child:
+		return "child"
+	case Grandparent:
+		return "grandparent"
+	case Guardian:
+		return "guardian"
+	case ParentSibling:
+		return "parent_sibling"
+	case Pet:
+		return "pet"
+	case OtherRelation:
+		return "other"
+	default:
+		return ""
+	}
 }
 
 func parsePersonType(s string) (PersonType, error) {
@@ -82,6 +143,16 @@ func parsePersonType(s string) (PersonType, error) {
 		return Parent, nil
 	case "child":
 		return Child, nil
+	case "grandparent":
+		return Grandparent, nil
+	case "guardian":
+		return Guardian, nil
+	case "parent_sibling":
+		return ParentSibling, nil
+	case "pet":
+		return Pet, nil
+	case "other":
+		return OtherRelation, nil
 	default:
 		return 0, fmt.Errorf("unknown type: %s", s)
 	}
@@ -93,19 +164,94 @@ func parseGenderType(s string) (GenderType, error) {
 		return Male, nil
 	case "female":
 		return Female, nil
+	case "undisclosed":
+		return Undisclosed, nil
 	default:
 		return 0, fmt.Errorf("unknown type: %s", s)
 	}
 }
 
+// default labels per type, indexed male, female, undisclosed
+var defaultRelationshipLabels = map[PersonType][3]string{
+	Parent:        {"Father", "Mother", "Parent"},
+	Child:         {"Son", "Daughter", "Child"},
+	Grandparent:   {"Grandfather", "Grandmother", "Grandparent"},
+	Guardian:      {"Guardian", "Guardian", "Guardian"},
+	ParentSibling: {"Uncle", "Aunt", "Parent's Sibling"},
+	Pet:           {"Pet", "Pet", "Pet"},
+	OtherRelation: {"Family Member", "Family Member", "Family Member"},
+}
+
+func defaultRelationshipLabel(person Person) string {
+	labels, ok := defaultRelationshipLabels[person.Type]
+	if !ok {
+		return ""
+	}
+	switch person.Gender {
+	case Male:
+		return labels[0]
+	case Female:
+		return labels[1]
+	default:
+		return labels[2]
+	}
+}
+
+func relationshipLabel(person Person, family Family) string {
+	for _, label := range family.RelationshipLabels {
+		if label.Type == person.Type && label.Label != "" {
+			return label.Label
+		}
+	}
+	return defaultRelationshipLabel(person)
+}
+
+// familyLabelRows returns one label per person type for the family edit form
+func familyLabelRows(family Family) (rows []RelationshipLabel) {
+	for _, personType := range allPersonTypes {
+		row := RelationshipLabel{Type: personType}
+		for _, label := range family.RelationshipLabels {
+			if label.Type == personType {
+				row.Label = label.Label
+			}
+		}
+		rows = append(rows, row)
+	}
+	return
+}
+
+func PackRelationshipLabel(self *RelationshipLabel, buf *vpack.Buffer) {
+	vpack.IntEnum(&self.Type, buf)
+	vpack.String(&self.Label, buf)
+}
+
 func PackFamily(self *Family, buf *vpack.Buffer) {
-	vpack.Version(1, buf)
+	version := vpack.Version(7, buf)
 	vpack.Int(&self.Id, buf)
 	vpack.String(&self.Name, buf)
 	vpack.String(&self.Description, buf)
 	vpack.Slice(&self.OwningUsers, vpack.Int, buf)
 	vpack.Int(&self.ImageId, buf)
 	vpack.IntEnum(&self.Visibility, buf)
+	if version >= 2 {
+		vpack.Slice(&self.RelationshipLabels, PackRelationshipLabel, buf)
+	}
+	if version >= 3 {
+		vpack.IntEnum(&self.Units, buf)
+	}
+	if version >= 4 {
+		vpack.Bool(&self.GrowthAlertEmails, buf)
+	}
+	if version >= 5 && version < 7 {
+		vpack.Bool(&self.ShareComparisons, buf)
+	}
+	if version >= 6 {
+		vpack.String(&self.VaccineSchedule, buf)
+		vpack.Bool(&self.VaccineReminders, buf)
+	}
+	if version >= 7 {
+		vpack.Slice(&self.CousinFamilies, vpack.Int, buf)
+	}
 }
 
 var FamilyBucket = vbolt.Bucket(&Info, "family", vpack.FInt, PackFamily)
@@ -115,6 +261,14 @@ func getFamily(tx *vbolt.Tx, id int) (family Family) {
 	return
 }
 
+func isFamilyOwner(tx *vbolt.Tx, familyId int, userId int) bool {
+	if familyId == 0 || userId == 0 {
+		return false
+	}
+	family := getFamily(tx, familyId)
+	return slices.Contains(family.OwningUsers, userId)
+}
+
 func GetAllFamilies(tx *vbolt.Tx) (families []Family) {
 	vbolt.IterateAll(tx, FamilyBucket, func(key int, value Family) bool {
 		generic.Append(&families, value)
@@ -153,7 +307,7 @@ func updateFamilyIndex(tx *vbolt.Tx, entry Family) {
 }
 
 func PackPerson(self *Person, buf *vpack.Buffer) {
-	vpack.Version(1, buf)
+	version := vpack.Version(3, buf)
 	vpack.Int(&self.Id, buf)
 	vpack.String(&self.Name, buf)
 	vpack.Time(&self.Birthday, buf)
@@ -161,13 +315,20 @@ func PackPerson(self *Person, buf *vpack.Buffer) {
 	vpack.IntEnum(&self.Type, buf)
 	vpack.IntEnum(&self.Gender, buf)
 	vpack.Int(&self.ImageId, buf)
+	if version >= 2 {
+		vpack.String(&self.Pronouns, buf)
+	}
+	if version >= 3 {
+		vpack.Time(&self.DueDate, buf)
+		vpack.Int(&self.CorrectUntilMonths, buf)
+	}
 }
 
 var PersonBucket = vbolt.Bucket(&Info, "people", vpack.FInt, PackPerson)
 
 func GetAllPeople(tx *vbolt.Tx) (people []Person) {
 	vbolt.IterateAll(tx, PersonBucket, func(key int, value Person) bool {
-		prepPerson(&value)
+		prepPerson(tx, &value)
 		generic.Append(&people, value)
 		return true
 	})
@@ -179,7 +340,7 @@ func getPeopleInFamily(tx *vbolt.Tx, familyId int) (people []Person) {
 	vbolt.ReadTermTargets(tx, PersonIndex, familyId, &personIds, vbolt.Window{})
 	vbolt.ReadSlice(tx, PersonBucket, personIds, &people)
 	for i := range people {
-		prepPerson(&people[i])
+		prepPerson(tx, &people[i])
 	}
 	return
 }
@@ -211,12 +372,16 @@ func getAllPeopleMap(tx *vbolt.Tx) (peopleMap map[int]Person) {
 
 func getPerson(tx *vbolt.Tx, id int) (person Person) {
 	vbolt.Read(tx, PersonBucket, id, &person)
-	prepPerson(&person)
+	prepPerson(tx, &person)
 	return person
 }
 
-func prepPerson(person *Person) {
+func prepPerson(tx *vbolt.Tx, person *Person) {
 	person.Age = CalculateAge(person.Birthday, true)
+	if person.isAgeCorrected(time.Now()) {
+		person.CorrectedAge = CalculateAge(person.DueDate, true)
+	}
+	person.Relationship = relationshipLabel(*person, getFamily(tx, person.FamilyId))
 }
 
 func CalculateAge(birthday time.Time, includeMonths bool) string {
@@ -244,10 +409,42 @@ func CalculateAge(birthday time.Time, includeMonths bool) string {
 	return fmt.Sprintf("%d years", years)
 }
 
+func (person Person) Premature() bool {
+	return person.DueDate.After(person.Birthday)
+}
+
+// GestationalWeeks is the gestational age at birth, counted back from the due date
+func (person Person) GestationalWeeks() int {
+	if !person.Premature() {
+		return fullTermWeeks
+	}
+	return fullTermWeeks - int(math.Round(person.DueDate.Sub(person.Birthday).Hours()/(24*7)))
+}
+
+func (person Person) correctUntilMonths() int {
+	if person.CorrectUntilMonths > 0 {
+		return person.CorrectUntilMonths
+	}
+	return defaultCorrectUntilMonths
+}
+
+// isAgeCorrected reports whether ages on a date are counted from the due date
+func (person Person) isAgeCorrected(date time.Time) bool {
+	return person.Premature() && date.Before(person.Birthday.AddDate(0, person.correctUntilMonths(), 0))
+}
+
+// AgeAt is the age in years on a date, corrected for prematurity while that applies
+func (person Person) AgeAt(date time.Time) float64 {
+	from := person.Birthday
+	if person.isAgeCorrected(date) {
+		from = person.DueDate
+	}
+	return date.Sub(from).Hours() / (365.25 * 24)
+}
+
 func RegisterChildrenPage(mux *http.ServeMux) {
 	mux.Handle("GET /children/add", AuthHandler(ContextFunc(addPersonPage)))
 	mux.Handle("GET /children/add/{id}", OwnerHandler(ContextFunc(editPersonPage)))
-	mux.Handle("GET /children/delete/{id}", AuthHandler(ContextFunc(deletePerson)))
 	mux.Handle("POST /children/add", AuthHandler(ContextFunc(savePerson)))
 
 	mux.Handle("GET /family/create", AuthHandler(ContextFunc(createFamilyPage)))
@@ -259,7 +456,10 @@ func RegisterChildrenPage(mux *http.ServeMux) {
 }
 
 func addPersonPage(context ResponseContext) {
-	RenderTemplate(context, "children-add")
+	RenderTemplateWithData(context, "children-add", map[string]any{
+		"Person":      Person{Type: Child},
+		"PersonTypes": allPersonTypes,
+	})
 }
 func editPersonPage(context ResponseContext) {
 	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
@@ -268,37 +468,62 @@ func editPersonPage(context ResponseContext) {
 		person := getPerson(tx, idVal)
 		context.familyId = person.FamilyId
 		RenderTemplateWithData(context, "children-add", map[string]any{
-			"Person": person,
+			"Person":      person,
+			"PersonTypes": allPersonTypes,
 		})
 	})
 }
-func deletePerson(context ResponseContext) {
-	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
-		id := context.r.PathValue("id")
-		idVal, _ := strconv.Atoi(id)
-		vbolt.Delete(tx, PersonBucket, idVal)
-		vbolt.TxCommit(tx)
-	})
-
-	http.Redirect(context.w, context.r, "/", http.StatusFound)
-}
 func savePerson(context ResponseContext) {
-	context.r.ParseForm()
-	birthdate := context.r.FormValue("birthdate")
-	name := context.r.FormValue("name")
-	id, _ := strconv.Atoi(context.r.FormValue("id"))
-	personType, _ := parsePersonType(context.r.FormValue("personType"))
-	gender, _ := parseGenderType(context.r.FormValue("gender"))
-
-	birthDateTime, _ := time.Parse("2006-01-02", birthdate)
+	form := bindForm(context.r)
+	id := form.Int("id", "Id", false)
+	name := form.String("name", "Name", true)
+	pronouns := form.Value("pronouns")
+	personType, err := parsePersonType(form.Value("personType"))
+	if err != nil {
+		form.Fail("personType", "%s", err.Error())
+	}
+	gender, err := parseGenderType(form.Value("gender"))
+	if err != nil {
+		form.Fail("gender", "%s", err.Error())
+	}
+	birthDateTime := form.Date("birthdate", "Birthday", true)
+	form.NotFuture("birthdate", "Birthday", birthDateTime)
+	correctUntilMonths := form.Int("correctUntilMonths", "Correct age until", false)
+
+	// a due date wins over a gestational age; either only matters when early
+	dueDate := form.Date("dueDate", "Due date", false)
+	weeks := form.Int("gestationalWeeks", "Weeks at birth", false)
+	if weeks != 0 && (weeks < 20 || weeks > fullTermWeeks) {
+		form.Fail("gestationalWeeks", "Weeks at birth must be between 20 and %d", fullTermWeeks)
+	}
+	if dueDate.IsZero() && weeks > 0 && weeks < fullTermWeeks {
+		dueDate = birthDateTime.AddDate(0, 0, (fullTermWeeks-weeks)*7)
+	}
+	if dueDate.After(birthDateTime.AddDate(0, 0, 20*7)) {
+		form.Fail("dueDate", "Due date can't be more than 20 weeks after the birthday")
+	}
+	if !dueDate.After(birthDateTime) {
+		dueDate = time.Time{}
+	}
 
 	entry := Person{
-		Birthday: birthDateTime,
-		Name:     name,
-		Id:       id,
-		FamilyId: context.user.PrimaryFamilyId,
-		Gender:   gender,
-		Type:     personType,
+		Birthday:           birthDateTime,
+		Name:               name,
+		Id:                 id,
+		FamilyId:           context.user.PrimaryFamilyId,
+		Gender:             gender,
+		Pronouns:           pronouns,
+		Type:               personType,
+		DueDate:            dueDate,
+		CorrectUntilMonths: correctUntilMonths,
+	}
+	if !form.Valid() {
+		RenderTemplateWithData(context, "children-add", map[string]any{
+			"Person":      entry,
+			"PersonTypes": allPersonTypes,
+			"Errors":      form.Errors,
+		})
+		return
 	}
 	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
 		if entry.Id == 0 {
@@ -313,15 +538,26 @@ func savePerson(context ResponseContext) {
 }
 
 func createFamilyPage(context ResponseContext) {
-	RenderTemplate(context, "family-create")
+	RenderTemplateWithData(context, "family-create", map[string]any{
+		"Family":      Family{Units: Imperial},
+		"UnitSystems": unitSystems,
+		"Schedules":   vaccineSchedules,
+	})
 }
 func editFamilyPage(context ResponseContext) {
 	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
 		id := context.r.PathValue("id")
 		idVal, _ := strconv.Atoi(id)
 		context.familyId = idVal
+		family := getFamily(tx, idVal)
+		cousins, requests := cousinLinksTx(tx, family)
 		RenderTemplateWithData(context, "family-create", map[string]any{
-			"Family": getFamily(tx, idVal),
+			"Family":             family,
+			"RelationshipLabels": familyLabelRows(family),
+			"UnitSystems":        unitSystems,
+			"Schedules":          vaccineSchedules,
+			"Cousins":            cousins,
+			"CousinRequests":     requests,
 		})
 	})
 }
@@ -339,13 +575,31 @@ func saveFamily(context ResponseContext) {
 		http.Error(context.w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	units, err := parseUnitSystem(context.r.FormValue("units"))
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var labels []RelationshipLabel
+	for _, personType := range allPersonTypes {
+		label := strings.TrimSpace(context.r.FormValue(fmt.Sprintf("label-%d", personType)))
+		if label != "" {
+			labels = append(labels, RelationshipLabel{Type: personType, Label: label})
+		}
+	}
 
 	entry := Family{
-		Name:        name,
-		Id:          id,
-		Description: description,
-		OwningUsers: []int{context.user.Id},
-		Visibility:  visibility,
+		Name:               name,
+		Id:                 id,
+		Description:        description,
+		OwningUsers:        []int{context.user.Id},
+		Visibility:         visibility,
+		RelationshipLabels: labels,
+		Units:              units,
+		GrowthAlertEmails:  context.r.FormValue("growthAlertEmails") == "on",
+		VaccineSchedule:    findVaccineSchedule(context.r.FormValue("vaccineSchedule")).Slug,
+		VaccineReminders:   context.r.FormValue("vaccineReminders") == "on",
 	}
 
 	var user User
@@ -356,6 +610,9 @@ func saveFamily(context ResponseContext) {
 	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
 		if entry.Id == 0 {
 			entry.Id = vbolt.NextIntId(tx, FamilyBucket)
+		} else {
+			// cousins are managed on their own form
+			entry.CousinFamilies = getFamily(tx, entry.Id).CousinFamilies
 		}
 		vbolt.Write(tx, FamilyBucket, entry.Id, &entry)
 		updateFamilyIndex(tx, entry)
@@ -374,15 +631,46 @@ func personPage(context ResponseContext) {
 		id := context.r.PathValue("id")
 		idVal, _ := strconv.Atoi(id)
 		person := getPerson(tx, idVal)
-		prepPerson(&person)
 		var image Image
 		if person.ImageId > 0 {
 			vbolt.Read(tx, ImageBucket, person.ImageId, &image)
 		}
 		context.familyId = person.FamilyId
+
+		system := preferredUnits(tx, context.user)
+		var velocities []GrowthVelocity
+		for _, info := range measurementKinds {
+			if _, tracked := growthReferences[info.Kind]; !tracked {
+				continue
+			}
+			if found := growthVelocities(queryMeasurementsTx(tx, person.Id, info.Kind), system); len(found) > 0 {
+				velocities = append(velocities, found[len(found)-1])
+			}
+		}
+		var upcoming, overdue []ChecklistEntry
+		if showChecklist(person) {
+			upcoming, overdue = checklistDue(personChecklistTx(tx, person))
+		}
+		// visits hold medical notes, so only the family sees them
+		isMember := isFamilyMemberTx(tx, context, person.FamilyId)
+		var visits []Visit
+		if isMember {
+			visits = getPersonVisitsTx(tx, person.Id, system)
+			if len(visits) > personPageVisits {
+				visits = visits[:personPageVisits]
+			}
+		}
 		RenderTemplateWithData(context, "person", map[string]any{
-			"Person": person,
-			"Image":  image,
+			"Person":     person,
+			"Image":      image,
+			"Kinds":      measurementKinds,
+			"Alerts":     getActiveGrowthAlertsTx(tx, person.Id),
+			"Velocities": velocities,
+			"CanExport":  isMember,
+			"Checklist":  showChecklist(person),
+			"Upcoming":   upcoming,
+			"Overdue":    overdue,
+			"Visits":     visits,
 		})
 	})
 }
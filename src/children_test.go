@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAgeAt(t *testing.T) {
+	birthday := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	dueDate := birthday.AddDate(0, 0, 70) // ten weeks early
+	cases := []struct {
+		name      string
+		person    Person
+		date      time.Time
+		corrected bool
+		from      time.Time
+	}{
+		{"no due date", Person{Birthday: birthday}, birthday.AddDate(1, 0, 0), false, birthday},
+		{"born after the due date", Person{Birthday: birthday, DueDate: birthday.AddDate(0, 0, -7)}, birthday.AddDate(1, 0, 0), false, birthday},
+		{"inside the default cutoff", Person{Birthday: birthday, DueDate: dueDate}, birthday.AddDate(1, 0, 0), true, dueDate},
+		{"the day before the cutoff", Person{Birthday: birthday, DueDate: dueDate}, birthday.AddDate(0, defaultCorrectUntilMonths, -1), true, dueDate},
+		{"at the cutoff", Person{Birthday: birthday, DueDate: dueDate}, birthday.AddDate(0, defaultCorrectUntilMonths, 0), false, birthday},
+		{"past the cutoff", Person{Birthday: birthday, DueDate: dueDate}, birthday.AddDate(3, 0, 0), false, birthday},
+		{"inside a family's cutoff", Person{Birthday: birthday, DueDate: dueDate, CorrectUntilMonths: 36}, birthday.AddDate(2, 6, 0), true, dueDate},
+		{"past a family's cutoff", Person{Birthday: birthday, DueDate: dueDate, CorrectUntilMonths: 12}, birthday.AddDate(1, 1, 0), false, birthday},
+	}
+	for _, c := range cases {
+		if corrected := c.person.isAgeCorrected(c.date); corrected != c.corrected {
+			t.Errorf("%s: expected corrected %v, got %v", c.name, c.corrected, corrected)
+		}
+		expected := c.date.Sub(c.from).Hours() / (365.25 * 24)
+		if age := c.person.AgeAt(c.date); math.Abs(age-expected) > 1e-9 {
+			t.Errorf("%s: expected age %.3f, got %.3f", c.name, expected, age)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+)
+
+type Interpolation int
+
+const (
+	LinearInterpolation Interpolation = iota
+	SplineInterpolation
+)
+
+func parseInterpolationLabel(interpolation Interpolation) string {
+	switch interpolation {
+	case LinearInterpolation:
+		return "linear"
+	case SplineInterpolation:
+		return "spline"
+	default:
+		return ""
+	}
+}
+
+func parseInterpolation(s string) (Interpolation, error) {
+	switch s {
+	case "", "linear":
+		return LinearInterpolation, nil
+	case "spline":
+		return SplineInterpolation, nil
+	default:
+		return 0, fmt.Errorf("unknown interpolation: %s", s)
+	}
+}
+
+// GridAge is one row of the comparison table
+type GridAge struct {
+	Years float64
+	Label string
+}
+
+func pluralize(n float64, unit string) string {
+	label := strconv.FormatFloat(n, 'f', -1, 64) + " " + unit
+	if n != 1 {
+		label += "s"
+	}
+	return label
+}
+
+func gridAgeLabel(years float64) string {
+	switch {
+	case years == 0:
+		return "birth"
+	case years < 1:
+		return pluralize(math.Round(years*12), "month")
+	default:
+		return pluralize(years, "year")
+	}
+}
+
+var comparisonMilestones = []float64{0, 1.0 / 12, 2.0 / 12, 3.0 / 12,
+	4.0 / 12, 5.0 / 12, 6.0 / 12, 7.0 / 12, 8.0 / 12,
+	9.0 / 12, 10.0 / 12, 11.0 / 12, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13}
+
+var comparisonGrids = []string{"default", "weeks", "months", "custom"}
+
+// parseGridAge reads an age like "6w", "9m", "2y" or "2.5" (years)
+func parseGridAge(s string) (GridAge, error) {
+	s = strings.TrimSpace(s)
+	unit, perYear := "year", 1.0
+	switch {
+	case strings.HasSuffix(s, "w"):
+		unit, perYear = "week", 365.25/7
+	case strings.HasSuffix(s, "m"):
+		unit, perYear = "month", 12
+	}
+	n, err := strconv.ParseFloat(strings.TrimRight(s, "wmy"), 64)
+	if err != nil || n < 0 {
+		return GridAge{}, fmt.Errorf("invalid age: %q", s)
+	}
+	age := GridAge{Years: n / perYear, Label: pluralize(n, unit)}
+	if n == 0 {
+		age.Label = "birth"
+	}
+	return age, nil
+}
+
+// comparisonGrid builds the ages to compare at: "weeks" is weekly for half a
+// year, "months" is monthly for three years, "custom" takes a comma separated
+// list of ages and anything else is the original monthly-then-yearly grid.
+func comparisonGrid(grid string, customAges string) (ages []GridAge, err error) {
+	switch grid {
+	case "weeks":
+		for week := 0; week <= 26; week++ {
+			age, _ := parseGridAge(strconv.Itoa(week) + "w")
+			ages = append(ages, age)
+		}
+	case "months":
+		for month := 0; month <= 36; month++ {
+			age, _ := parseGridAge(strconv.Itoa(month) + "m")
+			ages = append(ages, age)
+		}
+	case "custom":
+		for _, field := range strings.Split(customAges, ",") {
+			if strings.TrimSpace(field) == "" {
+				continue
+			}
+			age, err := parseGridAge(field)
+			if err != nil {
+				return nil, err
+			}
+			ages = append(ages, age)
+		}
+		if len(ages) == 0 {
+			return nil, fmt.Errorf("no ages given for a custom grid")
+		}
+		sort.Slice(ages, func(i, j int) bool { return ages[i].Years < ages[j].Years })
+	default:
+		for _, years := range comparisonMilestones {
+			ages = append(ages, GridAge{Years: years, Label: gridAgeLabel(years)})
+		}
+	}
+	return
+}
+
+// monotoneSpline is a Fritsch-Carlson monotone cubic through the points, so it
+// never overshoots between two measurements the way a plain cubic can.
+// xs must be increasing and x within them.
+func monotoneSpline(xs []float64, ys []float64, x float64) float64 {
+	n := len(xs)
+	if n == 2 {
+		return interpolate(xs[0], ys[0], xs[1], ys[1], x)
+	}
+	secants := make([]float64, n-1)
+	for i := range secants {
+		secants[i] = (ys[i+1] - ys[i]) / (xs[i+1] - xs[i])
+	}
+	tangents := make([]float64, n)
+	tangents[0], tangents[n-1] = secants[0], secants[n-2]
+	for i := 1; i < n-1; i++ {
+		if secants[i-1]*secants[i] > 0 {
+			tangents[i] = (secants[i-1] + secants[i]) / 2
+		}
+	}
+	for i, secant := range secants {
+		if secant == 0 {
+			tangents[i], tangents[i+1] = 0, 0
+			continue
+		}
+		a, b := tangents[i]/secant, tangents[i+1]/secant
+		if h := a*a + b*b; h > 9 {
+			t := 3 / math.Sqrt(h)
+			tangents[i], tangents[i+1] = t*a*secant, t*b*secant
+		}
+	}
+
+	i := sort.SearchFloat64s(xs, x)
+	if i == 0 {
+		return ys[0]
+	}
+	i--
+	h := xs[i+1] - xs[i]
+	t := (x - xs[i]) / h
+	t2, t3 := t*t, t*t*t
+	return (2*t3-3*t2+1)*ys[i] + (t3-2*t2+t)*h*tangents[i] + (-2*t3+3*t2)*ys[i+1] + (t3-t2)*h*tangents[i+1]
+}
+
+// measurementsAtAges estimates a value at each age from measurements in date
+// order. Ages before the first or after the last measurement are nil rather
+// than extrapolated.
+func measurementsAtAges(measurements []Measurement, ages []GridAge, interpolation Interpolation) []*float64 {
+	// measurements on the same day keep the last one
+	var xs, ys []float64
+	for _, measurement := range measurements {
+		if n := len(xs); n > 0 && xs[n-1] >= measurement.Age {
+			ys[n-1] = measurement.Value
+			continue
+		}
+		xs = append(xs, measurement.Age)
+		ys = append(ys, measurement.Value)
+	}
+
+	values := make([]*float64, len(ages))
+	for i, age := range ages {
+		if len(xs) == 0 || age.Years < xs[0] || age.Years > xs[len(xs)-1] {
+			continue
+		}
+		var value float64
+		j := sort.SearchFloat64s(xs, age.Years)
+		switch {
+		case xs[j] == age.Years:
+			value = ys[j]
+		case interpolation == SplineInterpolation:
+			value = monotoneSpline(xs, ys, age.Years)
+		default:
+			value = interpolate(xs[j-1], ys[j-1], xs[j], ys[j], age.Years)
+		}
+		values[i] = &value
+	}
+	return values
+}
+
+// cousinsLinked reports whether two families have each added the other as a
+// cousin family, which is the consent both need to compare growth
+func cousinsLinked(family Family, other Family) bool {
+	return family.Id != 0 && other.Id != 0 && family.Id != other.Id &&
+		slices.Contains(family.CousinFamilies, other.Id) && slices.Contains(other.CousinFamilies, family.Id)
+}
+
+// canCompareTx lets a viewer compare people in their own family, and people
+// in linked cousin families.
+func canCompareTx(tx *vbolt.Tx, viewerFamilyId int, person Person) bool {
+	if viewerFamilyId == 0 || person.FamilyId == 0 {
+		return false
+	}
+	if viewerFamilyId == person.FamilyId {
+		return true
+	}
+	return cousinsLinked(getFamily(tx, viewerFamilyId), getFamily(tx, person.FamilyId))
+}
+
+// comparableCousinsTx lists the children of the viewer's linked cousin families
+func comparableCousinsTx(tx *vbolt.Tx, viewerFamilyId int) (cousins []Person) {
+	viewer := getFamily(tx, viewerFamilyId)
+	for _, familyId := range viewer.CousinFamilies {
+		if !cousinsLinked(viewer, getFamily(tx, familyId)) {
+			continue
+		}
+		for _, person := range getPeopleInFamily(tx, familyId) {
+			if person.Type == Child {
+				cousins = append(cousins, person)
+			}
+		}
+	}
+	return
+}
+
+// CousinLink is a family this one has added, and whether they added it back
+type CousinLink struct {
+	Family Family
+	Mutual bool
+}
+
+// cousinLinksTx lists the cousin families a family has added, and the
+// families that have added it without being added back
+func cousinLinksTx(tx *vbolt.Tx, family Family) (links []CousinLink, requests []Family) {
+	for _, familyId := range family.CousinFamilies {
+		other := getFamily(tx, familyId)
+		if other.Id != 0 {
+			links = append(links, CousinLink{Family: other, Mutual: cousinsLinked(family, other)})
+		}
+	}
+	for _, other := range GetAllFamilies(tx) {
+		if other.Id != family.Id && slices.Contains(other.CousinFamilies, family.Id) && !slices.Contains(family.CousinFamilies, other.Id) {
+			requests = append(requests, other)
+		}
+	}
+	return
+}
+
+func RegisterComparisonPages(mux *http.ServeMux) {
+	mux.Handle("POST /family/cousins/{id}", AuthHandler(ContextFunc(addCousinFamily)))
+	mux.Handle("POST /family/cousins/{id}/remove/{cousin}", AuthHandler(ContextFunc(removeCousinFamily)))
+}
+
+// addCousinFamily gives consent to compare with the families owned by the
+// user with the given email, or accepts a family that already asked
+func addCousinFamily(context ResponseContext) {
+	context.r.ParseForm()
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	requestId, _ := strconv.Atoi(context.r.FormValue("familyId"))
+	email := strings.TrimSpace(context.r.FormValue("email"))
+
+	var isOwner bool
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		family := getFamily(tx, id)
+		isOwner = family.Id != 0 && isFamilyOwner(tx, family.Id, context.user.Id)
+		if !isOwner {
+			return
+		}
+		var candidates []Family
+		if email != "" {
+			if userId := GetUserId(tx, email); userId != 0 {
+				candidates = GetFamiliesForUser(tx, userId)
+			}
+		} else if requester := getFamily(tx, requestId); slices.Contains(requester.CousinFamilies, family.Id) {
+			candidates = append(candidates, requester)
+		}
+		for _, candidate := range candidates {
+			if candidate.Id != family.Id && !slices.Contains(family.CousinFamilies, candidate.Id) {
+				family.CousinFamilies = append(family.CousinFamilies, candidate.Id)
+			}
+		}
+		vbolt.Write(tx, FamilyBucket, family.Id, &family)
+		vbolt.TxCommit(tx)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+
+	http.Redirect(context.w, context.r, fmt.Sprintf("/family/edit/%d", id), http.StatusFound)
+}
+
+// removeCousinFamily withdraws consent; the other family keeps its side
+// but can no longer compare
+func removeCousinFamily(context ResponseContext) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	cousinId, _ := strconv.Atoi(context.r.PathValue("cousin"))
+
+	var isOwner bool
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		family := getFamily(tx, id)
+		isOwner = family.Id != 0 && isFamilyOwner(tx, family.Id, context.user.Id)
+		if !isOwner {
+			return
+		}
+		family.CousinFamilies = slices.DeleteFunc(family.CousinFamilies, func(familyId int) bool { return familyId == cousinId })
+		vbolt.Write(tx, FamilyBucket, family.Id, &family)
+		vbolt.TxCommit(tx)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+
+	http.Redirect(context.w, context.r, fmt.Sprintf("/family/edit/%d", id), http.StatusFound)
+}
+
+type MilestoneResponse struct {
+	Unit          string
+	Grid          string
+	Interpolation string
+	People        []Person
+	Milestones    []MilestoneAges
+}
+
+// MilestoneAges holds everyone's value at one age; a nil value means there is
+// no measurement on both sides of the age.
+type MilestoneAges struct {
+	MilestoneAge float64
+	Label        string
+	Average      *float64
+	Values       []*float64
+}
+
+func measurementTableApi(context ResponseContext) {
+	info, found := measurementKindFromPath(context)
+	if !found {
+		return
+	}
+	query := context.r.URL.Query()
+	ages, err := comparisonGrid(query.Get("grid"), query.Get("ages"))
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	interpolation, err := parseInterpolation(query.Get("interp"))
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	unit := info.Unit(requestUnits(context))
+	response := MilestoneResponse{
+		Unit:          unit.Symbol,
+		Grid:          query.Get("grid"),
+		Interpolation: parseInterpolationLabel(interpolation),
+	}
+
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		people := getAllPeopleMap(tx)
+		for _, personId := range query["ids"] {
+			id, _ := strconv.Atoi(personId)
+			person, found := people[id]
+			if !found || !(context.isAdmin || canCompareTx(tx, context.user.PrimaryFamilyId, person)) {
+				err = fmt.Errorf("cannot compare with person %s", personId)
+				return
+			}
+			response.People = append(response.People, person)
+		}
+	})
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	response.Milestones = make([]MilestoneAges, 0, len(ages))
+	for _, age := range ages {
+		response.Milestones = append(response.Milestones, MilestoneAges{
+			MilestoneAge: age.Years,
+			Label:        age.Label,
+			Values:       make([]*float64, len(response.People)),
+		})
+	}
+	for i, person := range response.People {
+		values := measurementsAtAges(QueryMeasurements(person.Id, info.Kind), ages, interpolation)
+		for j, value := range values {
+			if value != nil {
+				*value = unit.FromCanonical(*value)
+			}
+			response.Milestones[j].Values[i] = value
+		}
+	}
+
+	for i := range response.Milestones {
+		total, count := 0.0, 0
+		for _, value := range response.Milestones[i].Values {
+			if value != nil {
+				total += *value
+				count++
+			}
+		}
+		if count > 0 {
+			average := total / float64(count)
+			response.Milestones[i].Average = &average
+		}
+	}
+
+	context.w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(context.w).Encode(response)
+	if err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(context.w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
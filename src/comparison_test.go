@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestCousinsLinked(t *testing.T) {
+	ours := Family{Id: 1, CousinFamilies: []int{2, 3}}
+	mutual := Family{Id: 2, CousinFamilies: []int{1}}
+	oneSided := Family{Id: 3}
+	asked := Family{Id: 4, CousinFamilies: []int{1}}
+
+	if !cousinsLinked(ours, mutual) || !cousinsLinked(mutual, ours) {
+		t.Errorf("expected families that added each other to be linked")
+	}
+	if cousinsLinked(ours, oneSided) || cousinsLinked(ours, asked) {
+		t.Errorf("expected consent from only one side not to link families")
+	}
+	if cousinsLinked(ours, ours) {
+		t.Errorf("a family is not its own cousin")
+	}
+}
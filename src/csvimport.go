@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+)
+
+// CSV import of measurements of one kind. The upload is parsed and checked
+// into a plan that is shown as a preview; committing parses it again and saves
+// the new rows in one transaction.
+
+// CSVMapping is the column index of each field; -1 when the file has none
+type CSVMapping struct {
+	Person int
+	Date   int
+	Value  int
+	Unit   int
+}
+
+// CSVColumnChoice is one column select on the preview page
+type CSVColumnChoice struct {
+	Label    string
+	Name     string
+	Selected int
+}
+
+// guessCSVMapping picks columns by their header names
+func guessCSVMapping(header []string, info MeasurementKindInfo) CSVMapping {
+	mapping := CSVMapping{Person: -1, Date: -1, Value: -1, Unit: -1}
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "name", "person", "child":
+			mapping.Person = i
+		case "date", "measured", "measurement date":
+			mapping.Date = i
+		case "value", info.Slug, strings.ToLower(info.Label):
+			mapping.Value = i
+		case "unit", "units":
+			mapping.Unit = i
+		}
+	}
+	return mapping
+}
+
+var csvDateLayouts = []string{"2006-01-02", "1/2/2006", "1/2/06", "Jan 2, 2006", "January 2, 2006", "2 Jan 2006"}
+
+func parseCSVDate(s string) (time.Time, error) {
+	for _, layout := range csvDateLayouts {
+		if date, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised date %q", s)
+}
+
+var csvUnitAliases = map[string]string{
+	"inch": "in", "inches": "in", `"`: "in",
+	"centimeters": "cm", "centimetres": "cm",
+	"lbs": "lb", "pound": "lb", "pounds": "lb",
+	"kgs": "kg", "kilograms": "kg",
+	"f": "°F", "fahrenheit": "°F",
+	"c": "°C", "celsius": "°C",
+}
+
+func parseCSVUnit(info MeasurementKindInfo, s string) (Unit, bool) {
+	symbol := strings.ToLower(strings.TrimSpace(s))
+	if alias, found := csvUnitAliases[symbol]; found {
+		symbol = alias
+	}
+	return info.findUnit(symbol)
+}
+
+type CSVRowStatus int
+
+const (
+	CSVRowNew CSVRowStatus = iota
+	CSVRowDuplicate
+	CSVRowInvalid
+)
+
+type CSVImportRow struct {
+	Line       int
+	PersonName string
+	Person     Person
+	Date       time.Time
+	Entered    string
+	Unit       Unit
+	Value      float64 // metric
+	Status     CSVRowStatus
+	Problem    string
+}
+
+type CSVImportPlan struct {
+	Header []string
+	Rows   []CSVImportRow
+	New    int
+}
+
+// planCSVImport checks every row: the person must be in the family, the date
+// within their life, and the value in range. Rows matching a stored
+// measurement, or an earlier row, on the same day are duplicates.
+func planCSVImport(records [][]string, mapping CSVMapping, info MeasurementKindInfo, defaultUnit Unit, people []Person, existing map[int][]Measurement) (plan CSVImportPlan) {
+	if len(records) == 0 {
+		return
+	}
+	plan.Header = records[0]
+
+	peopleByName := make(map[string]Person)
+	for _, person := range people {
+		peopleByName[strings.ToLower(strings.TrimSpace(person.Name))] = person
+	}
+	seen := make(map[string]bool)
+	for _, measurements := range existing {
+		for _, measurement := range measurements {
+			seen[fmt.Sprintf("%d:%s", measurement.PersonId, measurement.Date.Format("2006-01-02"))] = true
+		}
+	}
+
+	cell := func(record []string, column int) string {
+		if column < 0 || column >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[column])
+	}
+	for i, record := range records[1:] {
+		row := CSVImportRow{
+			Line:       i + 2,
+			PersonName: cell(record, mapping.Person),
+			Entered:    cell(record, mapping.Value),
+			Unit:       defaultUnit,
+		}
+		plan.Rows = append(plan.Rows, row)
+		current := &plan.Rows[len(plan.Rows)-1]
+		fail := func(format string, args ...any) {
+			current.Status = CSVRowInvalid
+			current.Problem = fmt.Sprintf(format, args...)
+		}
+
+		person, found := peopleByName[strings.ToLower(row.PersonName)]
+		if !found {
+			fail("no one named %q in this family", row.PersonName)
+			continue
+		}
+		current.Person = person
+
+		date, err := parseCSVDate(cell(record, mapping.Date))
+		if err != nil {
+			fail("%s", err.Error())
+			continue
+		}
+		current.Date = date
+		if date.Before(person.Birthday) || date.After(time.Now()) {
+			fail("date is before %s's birthday or in the future", person.Name)
+			continue
+		}
+
+		if symbol := cell(record, mapping.Unit); symbol != "" {
+			if current.Unit, found = parseCSVUnit(info, symbol); !found {
+				fail("unknown unit %q for %s", symbol, info.Label)
+				continue
+			}
+		}
+		entered, err := strconv.ParseFloat(row.Entered, 64)
+		if err != nil || math.IsNaN(entered) {
+			fail("%q is not a number", row.Entered)
+			continue
+		}
+		current.Value = current.Unit.ToCanonical(entered)
+		if err := info.Validate(current.Value, current.Unit); err != nil {
+			fail("%s", err.Error())
+			continue
+		}
+
+		key := fmt.Sprintf("%d:%s", person.Id, date.Format("2006-01-02"))
+		if seen[key] {
+			current.Status = CSVRowDuplicate
+			current.Problem = "already recorded on this day"
+			continue
+		}
+		seen[key] = true
+		plan.New++
+	}
+	return
+}
+
+func RegisterCSVImportPages(mux *http.ServeMux) {
+	mux.Handle("GET /family/import/{id}", OwnerHandler(ContextFunc(csvImportPage)))
+	mux.Handle("POST /family/import/{id}", AuthHandler(ContextFunc(importCSV)))
+}
+
+func csvImportPage(context ResponseContext) {
+	familyId, _ := strconv.Atoi(context.r.PathValue("id"))
+	context.familyId = familyId
+	var units []Unit
+	for _, info := range measurementKinds {
+		for _, unit := range info.Units() {
+			if !slices.Contains(units, unit) {
+				units = append(units, unit)
+			}
+		}
+	}
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		RenderTemplateWithData(context, "measurement-import", map[string]any{
+			"Family": getFamily(tx, familyId),
+			"Kinds":  measurementKinds,
+			"Units":  units,
+		})
+	})
+}
+
+func readCSVUpload(context ResponseContext) (string, error) {
+	if err := context.r.ParseMultipartForm(10 << 20); err != nil {
+		return "", err
+	}
+	if text := context.r.FormValue("csv"); text != "" {
+		return text, nil
+	}
+	file, _, err := context.r.FormFile("csvFile")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	return string(content), err
+}
+
+func importCSV(context ResponseContext) {
+	familyId, _ := strconv.Atoi(context.r.PathValue("id"))
+
+	text, err := readCSVUpload(context)
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	info, found := getMeasurementKind(context.r.FormValue("kind"))
+	if !found {
+		http.Error(context.w, "unknown measurement kind", http.StatusBadRequest)
+		return
+	}
+	defaultUnit, found := info.findUnit(context.r.FormValue("unit"))
+	if !found {
+		defaultUnit = info.Unit(requestUnits(context))
+	}
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil || len(records) < 2 {
+		http.Error(context.w, "could not read a header and rows from the CSV file", http.StatusBadRequest)
+		return
+	}
+
+	// the preview form sends the mapping back; the first upload guesses it
+	mapping := guessCSVMapping(records[0], info)
+	if context.r.FormValue("mapped") != "" {
+		column := func(field string) int {
+			n, err := strconv.Atoi(context.r.FormValue(field))
+			if err != nil {
+				return -1
+			}
+			return n
+		}
+		mapping = CSVMapping{Person: column("personColumn"), Date: column("dateColumn"), Value: column("valueColumn"), Unit: column("unitColumn")}
+	}
+
+	var family Family
+	var plan CSVImportPlan
+	var isOwner bool
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		family = getFamily(tx, familyId)
+		isOwner = isFamilyOwner(tx, familyId, context.user.Id)
+		if !isOwner {
+			return
+		}
+		people := getPeopleInFamily(tx, familyId)
+		existing := make(map[int][]Measurement)
+		for _, person := range people {
+			existing[person.Id] = queryMeasurementsTx(tx, person.Id, info.Kind)
+		}
+		plan = planCSVImport(records, mapping, info, defaultUnit, people, existing)
+		if context.r.FormValue("mode") != "commit" {
+			return
+		}
+
+		imported := make(map[int]bool)
+		for _, row := range plan.Rows {
+			if row.Status != CSVRowNew {
+				continue
+			}
+			entry := Measurement{PersonId: row.Person.Id, Kind: info.Kind, Value: row.Value, EntryUnit: row.Unit.Symbol, Date: row.Date, DateOnly: info.TimeOfDay}
+			saveMeasurementTx(tx, &entry)
+			imported[entry.PersonId] = true
+		}
+		for personId := range imported {
+			refreshGrowthAlertsTx(tx, personId)
+		}
+		if info.Kind == HeightKind {
+			recordHeightPredictionsTx(tx, familyId)
+		}
+		vbolt.TxCommit(tx)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+
+	if context.r.FormValue("mode") != "commit" {
+		context.familyId = familyId
+		RenderTemplateWithData(context, "measurement-import-preview", map[string]any{
+			"Family":      family,
+			"Kind":        info,
+			"DefaultUnit": defaultUnit,
+			"Columns": []CSVColumnChoice{
+				{Label: "Person", Name: "personColumn", Selected: mapping.Person},
+				{Label: "Date", Name: "dateColumn", Selected: mapping.Date},
+				{Label: "Value", Name: "valueColumn", Selected: mapping.Value},
+				{Label: "Unit", Name: "unitColumn", Selected: mapping.Unit},
+			},
+			"Plan": plan,
+			"CSV":  text,
+		})
+		return
+	}
+
+	http.Redirect(context.w, context.r, "/measurements/"+info.Slug, http.StatusFound)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCSVImportPlan(t *testing.T) {
+	maia := Person{Id: 3, Name: "Maia Smith", Birthday: time.Date(2019, 3, 12, 0, 0, 0, 0, time.UTC)}
+	existing := map[int][]Measurement{
+		3: {{Id: 1, PersonId: 3, Kind: HeightKind, Value: 50, Date: maia.Birthday}},
+	}
+	records := [][]string{
+		{"Name", "Date", "Height", "Unit"},
+		{"maia smith", "2019-03-12", "19.7", "in"},
+		{"Maia Smith", "6/12/2019", "24", "inches"},
+		{"Maia Smith", "2019-06-12", "62", "cm"},
+		{"Noah", "2019-06-12", "60", "cm"},
+		{"Maia Smith", "2019-01-01", "45", "cm"},
+		{"Maia Smith", "2020-03-12", "abc", ""},
+	}
+
+	info := HeightKind.Info()
+	mapping := guessCSVMapping(records[0], info)
+	if mapping != (CSVMapping{Person: 0, Date: 1, Value: 2, Unit: 3}) {
+		t.Fatalf("unexpected mapping: %+v", mapping)
+	}
+	plan := planCSVImport(records, mapping, info, UnitCentimeters, []Person{maia}, existing)
+
+	expected := []CSVRowStatus{CSVRowDuplicate, CSVRowNew, CSVRowDuplicate, CSVRowInvalid, CSVRowInvalid, CSVRowInvalid}
+	for i, row := range plan.Rows {
+		if row.Status != expected[i] {
+			t.Fatalf("line %d: expected status %d, got %d (%s)", row.Line, expected[i], row.Status, row.Problem)
+		}
+	}
+	if plan.New != 1 || plan.Rows[1].Value != UnitInches.ToCanonical(24) {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+}
@@ -25,9 +25,14 @@ func rootPage(context ResponseContext) {
 		vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
 			family := getFamily(tx, context.user.PrimaryFamilyId)
 			people := getPeopleInFamily(tx, family.Id)
+			var alerts []GrowthAlert
+			for _, person := range people {
+				alerts = append(alerts, getActiveGrowthAlertsTx(tx, person.Id)...)
+			}
 			RenderTemplateWithData(context, "dashboard", map[string]any{
 				"Family": family,
 				"People": people,
+				"Alerts": alerts,
 			})
 		})
 	} else {
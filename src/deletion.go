@@ -0,0 +1,306 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+)
+
+// PersonDependents is everything that points at a person and has to go (or be
+// reassigned) when the person is deleted.
+type PersonDependents struct {
+	Person       Person
+	Measurements []Measurement
+	Milestones   []Milestone
+	Posts        []Post
+	Vaccinations []Vaccination
+	Visits       []Visit
+	Attachments  []Image
+	Image        Image
+
+	MedicationDoses []MedicationDose
+	IllnessEpisodes []IllnessEpisode
+}
+
+func collectPersonDependents(tx *vbolt.Tx, personId int) (deps PersonDependents) {
+	deps.Person = getPerson(tx, personId)
+
+	deps.Measurements = getPersonMeasurementsTx(tx, personId)
+
+	var milestoneIds []int
+	vbolt.ReadTermTargets(tx, MilestoneIndex, personId, &milestoneIds, vbolt.Window{})
+	vbolt.ReadSlice(tx, MilestoneBucket, milestoneIds, &deps.Milestones)
+
+	deps.Vaccinations = getPersonVaccinationsTx(tx, personId)
+
+	var visitIds []int
+	vbolt.ReadTermTargets(tx, VisitIndex, personId, &visitIds, vbolt.Window{})
+	vbolt.ReadSlice(tx, VisitBucket, visitIds, &deps.Visits)
+	for _, visit := range deps.Visits {
+		deps.Attachments = append(deps.Attachments, visitAttachmentsTx(tx, visit)...)
+	}
+	deps.MedicationDoses = getPersonMedicationDosesTx(tx, personId)
+	deps.IllnessEpisodes = getPersonIllnessEpisodesTx(tx, personId)
+
+	vbolt.IterateAll(tx, PostBucket, func(key int, value Post) bool {
+		if value.PersonId == personId {
+			deps.Posts = append(deps.Posts, value)
+		}
+		return true
+	})
+	if deps.Person.ImageId > 0 {
+		vbolt.Read(tx, ImageBucket, deps.Person.ImageId, &deps.Image)
+	}
+	return
+}
+
+func deleteMilestoneTx(tx *vbolt.Tx, milestoneId int) {
+	vbolt.Delete(tx, MilestoneBucket, milestoneId)
+	vbolt.SetTargetTermsPlain(tx, MilestoneIndex, milestoneId, nil)
+}
+
+// trashPersonTx moves a person and everything that depends on them into a
+// single trash entry. Posts are moved to reassignPostsTo when it is set,
+// otherwise they go to the trash with the person.
+func trashPersonTx(tx *vbolt.Tx, deps PersonDependents, reassignPostsTo int, userId int) {
+	entry := TrashEntry{
+		FamilyId:     deps.Person.FamilyId,
+		Kind:         TrashPerson,
+		Label:        deps.Person.Name,
+		DeletedBy:    userId,
+		Person:       deps.Person,
+		Measurements: deps.Measurements,
+		Milestones:   deps.Milestones,
+		Vaccinations: deps.Vaccinations,
+		Visits:       deps.Visits,
+		Image:        deps.Image,
+		Attachments:  deps.Attachments,
+
+		MedicationDoses: deps.MedicationDoses,
+		IllnessEpisodes: deps.IllnessEpisodes,
+
+		HeightPredictions: getHeightPredictionsTx(tx, deps.Person.Id),
+	}
+
+	for _, measurement := range deps.Measurements {
+		deleteMeasurementTx(tx, measurement.Id)
+	}
+	for _, milestone := range deps.Milestones {
+		deleteMilestoneTx(tx, milestone.Id)
+	}
+	for _, vaccination := range deps.Vaccinations {
+		deleteVaccinationTx(tx, vaccination.Id)
+	}
+	for _, visit := range deps.Visits {
+		deleteVisitTx(tx, visit.Id)
+	}
+	for _, attachment := range deps.Attachments {
+		vbolt.Delete(tx, ImageBucket, attachment.Id)
+	}
+	for _, dose := range deps.MedicationDoses {
+		deleteMedicationDoseTx(tx, dose.Id)
+	}
+	for _, episode := range deps.IllnessEpisodes {
+		deleteIllnessEpisodeTx(tx, episode.Id)
+	}
+	for _, post := range deps.Posts {
+		if reassignPostsTo > 0 {
+			post.PersonId = reassignPostsTo
+			SavePost(tx, &post)
+		} else {
+			vbolt.Delete(tx, PostBucket, post.Id)
+			entry.Posts = append(entry.Posts, post)
+		}
+	}
+	if deps.Image.Id > 0 {
+		vbolt.Delete(tx, ImageBucket, deps.Image.Id)
+	}
+
+	deleteGrowthAlertsTx(tx, deps.Person.Id)
+	deleteHeightPredictionsTx(tx, deps.Person.Id)
+	vbolt.Delete(tx, PersonBucket, deps.Person.Id)
+	vbolt.SetTargetTermsPlain(tx, PersonIndex, deps.Person.Id, nil)
+	saveTrashEntry(tx, &entry)
+
+	// the children's predicted heights no longer include this parent
+	if deps.Person.Type == Parent {
+		recordHeightPredictionsTx(tx, deps.Person.FamilyId)
+	}
+}
+
+// OrphanReport lists records whose person no longer exists
+type OrphanReport struct {
+	Measurements []Measurement
+	Milestones   []Milestone
+	Posts        []Post
+	Vaccinations []Vaccination
+	Visits       []Visit
+	Attachments  []Image // documents attached to the visits
+
+	MedicationDoses []MedicationDose
+	IllnessEpisodes []IllnessEpisode
+}
+
+func (report OrphanReport) Count() int {
+	return len(report.Measurements) + len(report.Milestones) + len(report.Posts) + len(report.Vaccinations) + len(report.Visits) +
+		len(report.Attachments) + len(report.MedicationDoses) + len(report.IllnessEpisodes)
+}
+
+func findOrphans(tx *vbolt.Tx) (report OrphanReport) {
+	people := getAllPeopleMap(tx)
+	exists := func(personId int) bool {
+		_, ok := people[personId]
+		return ok
+	}
+
+	vbolt.IterateAll(tx, MeasurementBucket, func(key int, value Measurement) bool {
+		if !exists(value.PersonId) {
+			report.Measurements = append(report.Measurements, value)
+		}
+		return true
+	})
+	vbolt.IterateAll(tx, MilestoneBucket, func(key int, value Milestone) bool {
+		if !exists(value.PersonId) {
+			report.Milestones = append(report.Milestones, value)
+		}
+		return true
+	})
+	vbolt.IterateAll(tx, PostBucket, func(key int, value Post) bool {
+		if !exists(value.PersonId) {
+			report.Posts = append(report.Posts, value)
+		}
+		return true
+	})
+	vbolt.IterateAll(tx, VaccinationBucket, func(key int, value Vaccination) bool {
+		if !exists(value.PersonId) {
+			report.Vaccinations = append(report.Vaccinations, value)
+		}
+		return true
+	})
+	vbolt.IterateAll(tx, VisitBucket, func(key int, value Visit) bool {
+		if !exists(value.PersonId) {
+			report.Visits = append(report.Visits, value)
+			report.Attachments = append(report.Attachments, visitAttachmentsTx(tx, value)...)
+		}
+		return true
+	})
+	vbolt.IterateAll(tx, MedicationDoseBucket, func(key int, value MedicationDose) bool {
+		if !exists(value.PersonId) {
+			report.MedicationDoses = append(report.MedicationDoses, value)
+		}
+		return true
+	})
+	vbolt.IterateAll(tx, IllnessEpisodeBucket, func(key int, value IllnessEpisode) bool {
+		if !exists(value.PersonId) {
+			report.IllnessEpisodes = append(report.IllnessEpisodes, value)
+		}
+		return true
+	})
+	return
+}
+
+func deleteOrphansTx(tx *vbolt.Tx, report OrphanReport) {
+	for _, measurement := range report.Measurements {
+		deleteMeasurementTx(tx, measurement.Id)
+	}
+	for _, milestone := range report.Milestones {
+		deleteMilestoneTx(tx, milestone.Id)
+	}
+	for _, post := range report.Posts {
+		vbolt.Delete(tx, PostBucket, post.Id)
+	}
+	for _, vaccination := range report.Vaccinations {
+		deleteVaccinationTx(tx, vaccination.Id)
+	}
+	for _, visit := range report.Visits {
+		deleteVisitTx(tx, visit.Id)
+	}
+	for _, attachment := range report.Attachments {
+		vbolt.Delete(tx, ImageBucket, attachment.Id)
+	}
+	for _, dose := range report.MedicationDoses {
+		deleteMedicationDoseTx(tx, dose.Id)
+	}
+	for _, episode := range report.IllnessEpisodes {
+		deleteIllnessEpisodeTx(tx, episode.Id)
+	}
+}
+
+func RegisterDeletionPages(mux *http.ServeMux) {
+	mux.Handle("GET /children/delete/{id}", OwnerHandler(ContextFunc(deletePersonPage)))
+	mux.Handle("POST /children/delete/{id}", AuthHandler(ContextFunc(deletePerson)))
+
+	mux.Handle("GET /admin/integrity", AdminHandler(ContextFunc(integrityAdminPage)))
+	mux.Handle("POST /admin/integrity", AdminHandler(ContextFunc(cleanOrphans)))
+}
+
+func deletePersonPage(context ResponseContext) {
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		id := context.r.PathValue("id")
+		idVal, _ := strconv.Atoi(id)
+		deps := collectPersonDependents(tx, idVal)
+		context.familyId = deps.Person.FamilyId
+
+		var others []Person
+		for _, person := range getPeopleInFamily(tx, deps.Person.FamilyId) {
+			if person.Id != deps.Person.Id {
+				others = append(others, person)
+			}
+		}
+		RenderTemplateWithData(context, "children-delete", map[string]any{
+			"Deps":   deps,
+			"Others": others,
+		})
+	})
+}
+
+func deletePerson(context ResponseContext) {
+	context.r.ParseForm()
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	reassignTo, _ := strconv.Atoi(context.r.FormValue("reassignTo"))
+
+	var allowed bool
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		deps := collectPersonDependents(tx, id)
+		allowed = deps.Person.Id != 0 && isFamilyOwner(tx, deps.Person.FamilyId, context.user.Id)
+		if !allowed {
+			return
+		}
+		if reassignTo > 0 && getPerson(tx, reassignTo).FamilyId != deps.Person.FamilyId {
+			reassignTo = 0
+		}
+		trashPersonTx(tx, deps, reassignTo, context.user.Id)
+		vbolt.TxCommit(tx)
+	})
+	if !allowed {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+
+	http.Redirect(context.w, context.r, "/", http.StatusFound)
+}
+
+func integrityAdminPage(context ResponseContext) {
+	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
+		RenderAdminTemplateWithData(context, "integrity", map[string]any{
+			"Orphans": findOrphans(tx),
+		})
+	})
+}
+
+func cleanOrphans(context ResponseContext) {
+	var files []string
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		report := findOrphans(tx)
+		deleteOrphansTx(tx, report)
+		for _, attachment := range report.Attachments {
+			files = append(files, imageFiles(attachment)...)
+		}
+		vbolt.TxCommit(tx)
+	})
+	removeFiles(files)
+
+	http.Redirect(context.w, context.r, "/admin/integrity", http.StatusFound)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbolt"
+)
+
+// seedPerson stores a child with a measurement, a milestone, a post and a
+// profile picture
+func seedPerson(tx *vbolt.Tx, person Person) Person {
+	image := Image{Id: vbolt.NextIntId(tx, ImageBucket), OwnerId: 1, FamilyId: person.FamilyId, Filename: "portrait.jpg"}
+	SaveImage(tx, &image)
+	person.ImageId = image.Id
+	vbolt.Write(tx, PersonBucket, person.Id, &person)
+	updatePersonIndex(tx, person)
+
+	date := person.Birthday.AddDate(1, 0, 0)
+	saveMeasurementTx(tx, &Measurement{PersonId: person.Id, Kind: HeightKind, Value: 76, EntryUnit: "cm", Date: date})
+	saveMilestoneTx(tx, &Milestone{PersonId: person.Id, Type: MilestoneWalking, Date: date})
+	SavePost(tx, &Post{Id: vbolt.NextIntId(tx, PostBucket), PersonId: person.Id, FamilyId: person.FamilyId, EntryDate: date, Content: "first steps"})
+	return person
+}
+
+func TestTrashPerson(t *testing.T) {
+	testDBPath := "test_trash_person.db"
+	testDb := vbolt.Open(testDBPath)
+	vbolt.InitBuckets(testDb, &Info)
+	defer os.Remove(testDBPath)
+	defer testDb.Close()
+
+	birthday := time.Date(2019, 3, 12, 0, 0, 0, 0, time.UTC)
+	var maia, theo, ada Person
+	vbolt.WithWriteTx(testDb, func(tx *vbolt.Tx) {
+		maia = seedPerson(tx, Person{Id: 1, FamilyId: 1, Type: Child, Name: "Maia", Birthday: birthday})
+		theo = seedPerson(tx, Person{Id: 2, FamilyId: 1, Type: Child, Name: "Theo", Birthday: birthday})
+		ada = seedPerson(tx, Person{Id: 3, FamilyId: 1, Type: Child, Name: "Ada", Birthday: birthday})
+		vbolt.TxCommit(tx)
+	})
+
+	// Maia's posts go to the trash with her, Theo's move to Ada
+	vbolt.WithWriteTx(testDb, func(tx *vbolt.Tx) {
+		trashPersonTx(tx, collectPersonDependents(tx, maia.Id), 0, 1)
+		trashPersonTx(tx, collectPersonDependents(tx, theo.Id), ada.Id, 1)
+		vbolt.TxCommit(tx)
+	})
+
+	vbolt.WithReadTx(testDb, func(tx *vbolt.Tx) {
+		for _, person := range []Person{maia, theo} {
+			deps := collectPersonDependents(tx, person.Id)
+			if deps.Person.Id != 0 || len(deps.Measurements) != 0 || len(deps.Milestones) != 0 || len(deps.Posts) != 0 {
+				t.Errorf("%s: expected nothing left, got %+v", person.Name, deps)
+			}
+			if vbolt.HasKey(tx, ImageBucket, person.ImageId) {
+				t.Errorf("%s: expected the profile picture to be trashed", person.Name)
+			}
+		}
+		family := getPeopleInFamily(tx, 1)
+		if len(family) != 1 || family[0].Id != ada.Id {
+			t.Errorf("expected only Ada left in the family, got %+v", family)
+		}
+		if posts := collectPersonDependents(tx, ada.Id).Posts; len(posts) != 2 {
+			t.Errorf("expected Theo's post to move to Ada, got %+v", posts)
+		}
+
+		var entryIds []int
+		var entries []TrashEntry
+		vbolt.ReadTermTargets(tx, TrashIndex, 1, &entryIds, vbolt.Window{})
+		vbolt.ReadSlice(tx, TrashBucket, entryIds, &entries)
+		if len(entries) != 2 {
+			t.Fatalf("expected two trash entries, got %d", len(entries))
+		}
+		for _, entry := range entries {
+			expectedPosts := 0
+			if entry.Person.Id == maia.Id {
+				expectedPosts = 1
+			}
+			if len(entry.Measurements) != 1 || len(entry.Milestones) != 1 || len(entry.Posts) != expectedPosts || entry.Image.Id == 0 {
+				t.Errorf("%s: unexpected trash entry %+v", entry.Label, entry)
+			}
+		}
+
+		if report := findOrphans(tx); report.Count() != 0 {
+			t.Errorf("expected no orphans, got %+v", report)
+		}
+	})
+}
+
+func TestCleanOrphans(t *testing.T) {
+	// cleanOrphans is a handler, so it works on the site's database
+	previous := db
+	defer func() { db = previous }()
+	testDBPath := "test_clean_orphans.db"
+	db = vbolt.Open(testDBPath)
+	vbolt.InitBuckets(db, &Info)
+	defer os.Remove(testDBPath)
+	defer db.Close()
+
+	// records left behind by a person removed before deletes cascaded
+	date := time.Date(2020, 3, 12, 0, 0, 0, 0, time.UTC)
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		seedPerson(tx, Person{Id: 1, FamilyId: 1, Type: Child, Name: "Maia", Birthday: date.AddDate(-1, 0, 0)})
+		saveMeasurementTx(tx, &Measurement{PersonId: 9, Kind: HeightKind, Value: 76, EntryUnit: "cm", Date: date})
+		saveMilestoneTx(tx, &Milestone{PersonId: 9, Type: MilestoneWalking, Date: date})
+		SavePost(tx, &Post{Id: vbolt.NextIntId(tx, PostBucket), PersonId: 9, FamilyId: 1, EntryDate: date})
+		vbolt.TxCommit(tx)
+	})
+	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
+		report := findOrphans(tx)
+		if len(report.Measurements) != 1 || len(report.Milestones) != 1 || len(report.Posts) != 1 || report.Count() != 3 {
+			t.Fatalf("expected three orphans, got %+v", report)
+		}
+	})
+
+	recorder := httptest.NewRecorder()
+	cleanOrphans(ResponseContext{w: recorder, r: httptest.NewRequest("POST", "/admin/integrity", nil), isAdmin: true})
+
+	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
+		if report := findOrphans(tx); report.Count() != 0 {
+			t.Errorf("expected the orphans to be removed, got %+v", report)
+		}
+		if len(getPersonMeasurementsTx(tx, 9)) != 0 {
+			t.Error("expected the orphaned measurement to leave the index")
+		}
+		deps := collectPersonDependents(tx, 1)
+		if len(deps.Measurements) != 1 || len(deps.Milestones) != 1 || len(deps.Posts) != 1 {
+			t.Errorf("expected Maia's records to be kept, got %+v", deps)
+		}
+	})
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+)
+
+// Spreadsheet exports of a person's or a family's records, as CSV (one table)
+// or XLSX (a sheet per table). Values are in the viewer's unit system.
+
+// ExportTable is one sheet of an export; cells are strings, numbers or nil
+type ExportTable struct {
+	Name   string
+	Header []string
+	Rows   [][]any
+}
+
+func stringCells(values []string) []any {
+	cells := make([]any, len(values))
+	for i, value := range values {
+		cells[i] = value
+	}
+	return cells
+}
+
+// ExportRange keeps records dated From..To, inclusive; a zero end is open
+type ExportRange struct {
+	From time.Time
+	To   time.Time
+}
+
+func (r ExportRange) Contains(date time.Time) bool {
+	if !r.From.IsZero() && date.Before(r.From) {
+		return false
+	}
+	if !r.To.IsZero() && !date.Before(r.To.AddDate(0, 0, 1)) {
+		return false
+	}
+	return true
+}
+
+func parseExportRange(from string, to string) (r ExportRange, err error) {
+	if from != "" {
+		if r.From, err = time.Parse("2006-01-02", from); err != nil {
+			return r, fmt.Errorf("invalid from date: %q", from)
+		}
+	}
+	if to != "" {
+		if r.To, err = time.Parse("2006-01-02", to); err != nil {
+			return r, fmt.Errorf("invalid to date: %q", to)
+		}
+	}
+	if !r.From.IsZero() && !r.To.IsZero() && r.To.Before(r.From) {
+		return r, fmt.Errorf("the to date is before the from date")
+	}
+	return
+}
+
+func roundTo(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
+
+func exportMeasurementsTx(tx *vbolt.Tx, people []Person, dates ExportRange, system UnitSystem) ExportTable {
+	table := ExportTable{
+		Name:   "Measurements",
+		Header: []string{"Person", "Date", "Age (years)", "Corrected Age", "Measurement", "Value", "Unit", "Percentile"},
+	}
+	for _, person := range people {
+		for _, info := range measurementKinds {
+			unit := info.Unit(system)
+			for _, measurement := range queryMeasurementsTx(tx, person.Id, info.Kind) {
+				if !dates.Contains(measurement.Date) {
+					continue
+				}
+				var percentile any
+				if measurement.HasPercentile {
+					percentile = roundTo(measurement.Percentile, 1)
+				}
+				corrected := "no"
+				if measurement.Corrected {
+					corrected = "yes"
+				}
+				table.Rows = append(table.Rows, []any{
+					person.Name,
+					measurement.exportDate(),
+					roundTo(measurement.Age, 2),
+					corrected,
+					info.Label,
+					roundTo(unit.FromCanonical(measurement.Value), info.Precision),
+					unit.Symbol,
+					percentile,
+				})
+			}
+		}
+	}
+	return table
+}
+
+func exportMilestonesTx(tx *vbolt.Tx, people []Person, dates ExportRange) ExportTable {
+	table := ExportTable{
+		Name:   "Milestones",
+		Header: []string{"Person", "Date", "Age (years)", "Milestone", "Category", "Value", "Unit", "Text", "Notes"},
+	}
+	for _, person := range people {
+		for _, milestone := range queryMilestonesTx(tx, person.Id) {
+			if !dates.Contains(milestone.Date) {
+				continue
+			}
+			var value any
+			if milestone.NumericValue != 0 {
+				value = milestone.NumericValue
+			}
+			table.Rows = append(table.Rows, []any{
+				person.Name,
+				milestone.Date.Format("2006-01-02"),
+				roundTo(milestone.Age, 2),
+				milestone.TypeLabel,
+				parseMilestoneCategoryLabel(milestone.Category),
+				value,
+				milestone.Unit,
+				milestone.TextValue,
+				milestone.Notes,
+			})
+		}
+	}
+	return table
+}
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// postWords counts the words of a post's text, ignoring its markup
+func postWords(content string) int {
+	return len(strings.Fields(htmlTagPattern.ReplaceAllString(content, " ")))
+}
+
+// exportPostsTx lists post metadata only; the content stays on the site
+func exportPostsTx(tx *vbolt.Tx, people []Person, dates ExportRange) ExportTable {
+	table := ExportTable{
+		Name:   "Posts",
+		Header: []string{"Post", "Person", "Date", "Age (years)", "Words"},
+	}
+	byId := make(map[int]Person)
+	for _, person := range people {
+		byId[person.Id] = person
+	}
+	for _, post := range getAllPosts(tx) {
+		person, found := byId[post.PersonId]
+		if !found || !dates.Contains(post.EntryDate) {
+			continue
+		}
+		table.Rows = append(table.Rows, []any{
+			post.Id,
+			person.Name,
+			post.EntryDate.Format("2006-01-02"),
+			roundTo(person.AgeAt(post.EntryDate), 2),
+			postWords(post.Content),
+		})
+	}
+	return table
+}
+
+// isFamilyMemberTx reports whether the user is an admin, an owner or a member of the family
+func isFamilyMemberTx(tx *vbolt.Tx, context ResponseContext, familyId int) bool {
+	if familyId == 0 {
+		return false
+	}
+	return context.isAdmin || context.user.PrimaryFamilyId == familyId || isFamilyOwner(tx, familyId, context.user.Id)
+}
+
+var exportTables = []string{"measurements", "milestones", "posts"}
+
+// exportFileName makes a download name like "ada-lovelace-measurements.csv"
+func exportFileName(name string, what string, format string) string {
+	slug := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '-'
+		}
+	}, strings.ToLower(name))
+	slug = strings.Trim(slug, "-")
+	if slug == "" {
+		slug = "export"
+	}
+	return slug + "-" + what + "." + format
+}
+
+func writeCSVTable(context ResponseContext, table ExportTable) error {
+	writer := csv.NewWriter(context.w)
+	writer.Write(table.Header)
+	for _, row := range table.Rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			switch value := cell.(type) {
+			case nil:
+			case float64:
+				record[i] = strconv.FormatFloat(value, 'f', -1, 64)
+			default:
+				record[i] = fmt.Sprint(value)
+			}
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func RegisterExportPages(mux *http.ServeMux) {
+	mux.Handle("GET /export/person/{id}", AuthHandler(ContextFunc(exportPerson)))
+	mux.Handle("GET /export/family/{id}", AuthHandler(ContextFunc(exportFamily)))
+}
+
+func exportPerson(context ResponseContext) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	var person Person
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person = getPerson(tx, personId)
+	})
+	writeExport(context, person.FamilyId, person.Name, []Person{person})
+}
+
+func exportFamily(context ResponseContext) {
+	familyId, _ := strconv.Atoi(context.r.PathValue("id"))
+	var family Family
+	var people []Person
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		family = getFamily(tx, familyId)
+		people = getPeopleInFamily(tx, familyId)
+	})
+	writeExport(context, family.Id, family.Name, people)
+}
+
+// writeExport reads the query parameters format (csv or xlsx), what (a table
+// name; xlsx also takes "all"), from and to, and sends the file.
+func writeExport(context ResponseContext, familyId int, name string, people []Person) {
+	query := context.r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "xlsx" {
+		http.Error(context.w, "unknown export format: "+format, http.StatusBadRequest)
+		return
+	}
+	what := query.Get("what")
+	if what == "" {
+		what = "measurements"
+		if format == "xlsx" {
+			what = "all"
+		}
+	}
+	if what == "all" && format == "csv" {
+		http.Error(context.w, "a CSV export holds one table; choose measurements, milestones or posts", http.StatusBadRequest)
+		return
+	}
+	dates, err := parseExportRange(query.Get("from"), query.Get("to"))
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	system := requestUnits(context)
+
+	var tables []ExportTable
+	var allowed bool
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		allowed = isFamilyMemberTx(tx, context, familyId)
+		if !allowed {
+			return
+		}
+		for _, table := range exportTables {
+			if what != "all" && what != table {
+				continue
+			}
+			switch table {
+			case "measurements":
+				tables = append(tables, exportMeasurementsTx(tx, people, dates, system))
+			case "milestones":
+				tables = append(tables, exportMilestonesTx(tx, people, dates))
+			case "posts":
+				tables = append(tables, exportPostsTx(tx, people, dates))
+			}
+		}
+	})
+	if !allowed {
+		http.Error(context.w, "not a member of this family", http.StatusUnauthorized)
+		return
+	}
+	if len(tables) == 0 {
+		http.Error(context.w, "unknown export: "+what, http.StatusBadRequest)
+		return
+	}
+
+	context.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName(name, what, format)))
+	if format == "csv" {
+		context.w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		err = writeCSVTable(context, tables[0])
+	} else {
+		context.w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		err = writeXLSX(context.w, tables)
+	}
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusInternalServerError)
+	}
+}
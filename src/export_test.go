@@ -0,0 +1,84 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportRange(t *testing.T) {
+	dates, err := parseExportRange("2020-01-01", "2020-06-30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"2019-12-31": false,
+		"2020-01-01": true,
+		"2020-06-30": true,
+		"2020-07-01": false,
+	}
+	for day, expected := range cases {
+		date, _ := time.Parse("2006-01-02", day)
+		if dates.Contains(date.Add(15*time.Hour)) != expected {
+			t.Errorf("%s: expected %v", day, expected)
+		}
+	}
+	if _, err := parseExportRange("2020-06-30", "2020-01-01"); err == nil {
+		t.Error("expected an error for a reversed range")
+	}
+}
+
+func TestXLSXColumn(t *testing.T) {
+	for index, expected := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if column := xlsxColumn(index); column != expected {
+			t.Errorf("column %d: expected %s, got %s", index, expected, column)
+		}
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	tables := []ExportTable{
+		{Name: "Measurements", Header: []string{"Person", "Value"}, Rows: [][]any{{"Maia <3", 62.5}, {"Noah", nil}}},
+		{Name: "Posts/Notes", Header: []string{"Post"}, Rows: [][]any{{7}}},
+	}
+	var buf bytes.Buffer
+	if err := writeXLSX(&buf, tables); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string]string)
+	for _, file := range archive.File {
+		reader, _ := file.Open()
+		content, _ := io.ReadAll(reader)
+		reader.Close()
+		parts[file.Name] = string(content)
+		decoder := xml.NewDecoder(bytes.NewReader(content))
+		for {
+			if _, err := decoder.Token(); err != nil {
+				if err != io.EOF {
+					t.Errorf("%s is not well formed: %v", file.Name, err)
+				}
+				break
+			}
+		}
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml", "xl/worksheets/sheet2.xml"} {
+		if _, found := parts[name]; !found {
+			t.Errorf("missing part %s", name)
+		}
+	}
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	if !strings.Contains(sheet, `<c r="B2"><v>62.5</v></c>`) || !strings.Contains(sheet, "Maia &lt;3") || strings.Contains(sheet, `r="B3"`) {
+		t.Errorf("unexpected sheet: %s", sheet)
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `name="PostsNotes"`) {
+		t.Errorf("sheet name not cleaned: %s", parts["xl/workbook.xml"])
+	}
+}
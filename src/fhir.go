@@ -0,0 +1,497 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+)
+
+// FHIR R4 exchange with a pediatric portal: a person's measurements go out as
+// a Bundle of a Patient and vital sign Observations coded with LOINC, and a
+// Bundle of Observations from the portal can be read back in. Only the fields
+// used here are modelled; one resource struct covers both resource types.
+
+type FHIRCoding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type FHIRCodeableConcept struct {
+	Coding []FHIRCoding `json:"coding,omitempty"`
+	Text   string       `json:"text,omitempty"`
+}
+
+type FHIRReference struct {
+	Reference string `json:"reference,omitempty"`
+}
+
+type FHIRQuantity struct {
+	Value  *float64 `json:"value,omitempty"`
+	Unit   string   `json:"unit,omitempty"`
+	System string   `json:"system,omitempty"`
+	Code   string   `json:"code,omitempty"`
+}
+
+type FHIRPeriod struct {
+	Start string `json:"start,omitempty"`
+}
+
+type FHIRHumanName struct {
+	Text string `json:"text,omitempty"`
+}
+
+type FHIRResource struct {
+	ResourceType string `json:"resourceType"`
+	Id           string `json:"id,omitempty"`
+
+	// Patient
+	Name      []FHIRHumanName `json:"name,omitempty"`
+	Gender    string          `json:"gender,omitempty"`
+	BirthDate string          `json:"birthDate,omitempty"`
+
+	// Observation
+	Status            string                `json:"status,omitempty"`
+	Category          []FHIRCodeableConcept `json:"category,omitempty"`
+	Code              *FHIRCodeableConcept  `json:"code,omitempty"`
+	Subject           *FHIRReference        `json:"subject,omitempty"`
+	EffectiveDateTime string                `json:"effectiveDateTime,omitempty"`
+	EffectivePeriod   *FHIRPeriod           `json:"effectivePeriod,omitempty"`
+	ValueQuantity     *FHIRQuantity         `json:"valueQuantity,omitempty"`
+}
+
+type FHIRBundleEntry struct {
+	FullUrl  string        `json:"fullUrl,omitempty"`
+	Resource *FHIRResource `json:"resource,omitempty"`
+}
+
+type FHIRBundle struct {
+	ResourceType string            `json:"resourceType"`
+	Type         string            `json:"type"`
+	Timestamp    string            `json:"timestamp,omitempty"`
+	Entry        []FHIRBundleEntry `json:"entry"`
+}
+
+const (
+	loincSystem = "http://loinc.org"
+	ucumSystem  = "http://unitsofmeasure.org"
+)
+
+// fhirKinds gives the LOINC code an exported kind is written with and the
+// UCUM code of its metric unit. Shoe size has no LOINC code and stays home.
+var fhirKinds = map[MeasurementKind]struct {
+	Code    string
+	Display string
+	UCUM    string
+}{
+	HeightKind:            {"8302-2", "Body height", "cm"},
+	WeightKind:            {"29463-7", "Body weight", "kg"},
+	HeadCircumferenceKind: {"9843-4", "Head Occipital-frontal circumference", "cm"},
+	TemperatureKind:       {"8310-5", "Body temperature", "Cel"},
+}
+
+// fhirImportCodes also takes the narrower LOINC codes portals use for the
+// same measurements
+var fhirImportCodes = map[string]MeasurementKind{
+	"8302-2":  HeightKind,
+	"3137-7":  HeightKind,
+	"8306-3":  HeightKind, // body height, lying
+	"29463-7": WeightKind,
+	"3141-9":  WeightKind,
+	"9843-4":  HeadCircumferenceKind,
+	"8287-5":  HeadCircumferenceKind,
+	"8310-5":  TemperatureKind,
+	"8331-1":  TemperatureKind, // oral
+}
+
+// fhirUnits maps the UCUM codes of each kind to a unit
+var fhirUnits = map[MeasurementKind]map[string]Unit{
+	HeightKind:            {"cm": UnitCentimeters, "[in_i]": UnitInches, "m": lengthUnits["m"], "mm": lengthUnits["mm"]},
+	WeightKind:            {"kg": UnitKilograms, "[lb_av]": UnitPounds, "g": massUnits["g"], "[oz_av]": massUnits["oz"]},
+	HeadCircumferenceKind: {"cm": UnitCentimeters, "[in_i]": UnitInches, "mm": lengthUnits["mm"]},
+	TemperatureKind:       {"Cel": UnitCelsius, "[degF]": UnitFahrenheit},
+}
+
+var fhirVitalSigns = FHIRCodeableConcept{Coding: []FHIRCoding{{
+	System:  "http://terminology.hl7.org/CodeSystem/observation-category",
+	Code:    "vital-signs",
+	Display: "Vital Signs",
+}}}
+
+// fhirObservationStatuses are the status codes R4 allows
+var fhirObservationStatuses = []string{"registered", "preliminary", "final", "amended", "corrected", "cancelled", "entered-in-error", "unknown"}
+
+func fhirGender(gender GenderType) string {
+	switch gender {
+	case Male:
+		return "male"
+	case Female:
+		return "female"
+	default:
+		return "unknown"
+	}
+}
+
+func fhirPatient(person Person) FHIRResource {
+	return FHIRResource{
+		ResourceType: "Patient",
+		Id:           fmt.Sprintf("person-%d", person.Id),
+		Name:         []FHIRHumanName{{Text: person.Name}},
+		Gender:       fhirGender(person.Gender),
+		BirthDate:    person.Birthday.Format("2006-01-02"),
+	}
+}
+
+func fhirObservation(person Person, measurement Measurement) (resource FHIRResource, found bool) {
+	code, found := fhirKinds[measurement.Kind]
+	if !found {
+		return
+	}
+	info := measurement.Kind.Info()
+	value := roundTo(measurement.Value, info.Precision)
+	return FHIRResource{
+		ResourceType: "Observation",
+		Id:           fmt.Sprintf("measurement-%d", measurement.Id),
+		Status:       "final",
+		Category:     []FHIRCodeableConcept{fhirVitalSigns},
+		Code: &FHIRCodeableConcept{
+			Coding: []FHIRCoding{{System: loincSystem, Code: code.Code, Display: code.Display}},
+			Text:   info.Label,
+		},
+		Subject:           &FHIRReference{Reference: fmt.Sprintf("Patient/person-%d", person.Id)},
+		EffectiveDateTime: measurement.exportDate(),
+		ValueQuantity:     &FHIRQuantity{Value: &value, Unit: info.Metric.Symbol, System: ucumSystem, Code: code.UCUM},
+	}, true
+}
+
+// fhirBundleForPerson collects a person and their measurements into a
+// collection Bundle
+func fhirBundleForPerson(person Person, measurements []Measurement, now time.Time) FHIRBundle {
+	bundle := FHIRBundle{ResourceType: "Bundle", Type: "collection", Timestamp: now.Format(time.RFC3339)}
+	patient := fhirPatient(person)
+	bundle.Entry = append(bundle.Entry, FHIRBundleEntry{FullUrl: "urn:family:Patient/" + patient.Id, Resource: &patient})
+	for _, measurement := range measurements {
+		if observation, found := fhirObservation(person, measurement); found {
+			bundle.Entry = append(bundle.Entry, FHIRBundleEntry{FullUrl: "urn:family:Observation/" + observation.Id, Resource: &observation})
+		}
+	}
+	return bundle
+}
+
+// FHIRIssue is a problem with one entry of an imported Bundle
+type FHIRIssue struct {
+	Entry   int
+	Problem string
+}
+
+// fhirDate reads the day of an Observation's effective[x]; dates without a
+// day are too vague for a growth chart. The time is kept for kinds recorded
+// with one, when the Observation has it.
+func fhirDate(observation FHIRResource, timeOfDay bool) (date time.Time, dateOnly bool, err error) {
+	value := observation.EffectiveDateTime
+	if value == "" && observation.EffectivePeriod != nil {
+		value = observation.EffectivePeriod.Start
+	}
+	if value == "" {
+		return time.Time{}, false, fmt.Errorf("effective[x] is required")
+	}
+	if len(value) < 10 {
+		return time.Time{}, false, fmt.Errorf("effective date %q has no day", value)
+	}
+	if timeOfDay && len(value) > 10 {
+		if date, err := time.Parse(time.RFC3339, value); err == nil {
+			return date, false, nil
+		}
+	}
+	date, err = time.Parse("2006-01-02", value[:10])
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("effective date %q is not a FHIR date", value)
+	}
+	return date, timeOfDay, nil
+}
+
+// fhirMeasurement checks an Observation against the fields R4 and the vital
+// signs profile require, and converts it. skip is set for observations that
+// are valid but have nothing for us, like a cancelled one or a blood pressure.
+func fhirMeasurement(observation FHIRResource) (measurement Measurement, skip bool, err error) {
+	if observation.Status == "" {
+		return measurement, false, fmt.Errorf("status is required")
+	}
+	if !slices.Contains(fhirObservationStatuses, observation.Status) {
+		return measurement, false, fmt.Errorf("unknown status %q", observation.Status)
+	}
+	if observation.Code == nil || (len(observation.Code.Coding) == 0 && observation.Code.Text == "") {
+		return measurement, false, fmt.Errorf("code is required")
+	}
+	if observation.Subject == nil || observation.Subject.Reference == "" {
+		return measurement, false, fmt.Errorf("subject is required")
+	}
+	if observation.Status == "cancelled" || observation.Status == "entered-in-error" {
+		return measurement, true, nil
+	}
+
+	kind, found := MeasurementKind(0), false
+	for _, coding := range observation.Code.Coding {
+		if coding.System == loincSystem {
+			if kind, found = fhirImportCodes[coding.Code]; found {
+				break
+			}
+		}
+	}
+	if !found {
+		return measurement, true, nil
+	}
+
+	info := kind.Info()
+	date, dateOnly, err := fhirDate(observation, info.TimeOfDay)
+	if err != nil {
+		return measurement, false, err
+	}
+	quantity := observation.ValueQuantity
+	if quantity == nil || quantity.Value == nil {
+		return measurement, false, fmt.Errorf("valueQuantity.value is required")
+	}
+	if quantity.System != ucumSystem {
+		return measurement, false, fmt.Errorf("valueQuantity must be in UCUM units")
+	}
+	unit, found := fhirUnits[kind][quantity.Code]
+	if !found {
+		return measurement, false, fmt.Errorf("unit %q is not a %s unit", quantity.Code, strings.ToLower(kind.Info().Label))
+	}
+
+	measurement = Measurement{Kind: kind, Value: unit.ToCanonical(*quantity.Value), EntryUnit: info.Metric.Symbol, Date: date, DateOnly: dateOnly}
+	if err := info.Validate(measurement.Value, info.Metric); err != nil {
+		return measurement, false, err
+	}
+	return measurement, false, nil
+}
+
+// refersTo is whether a reference, relative or absolute, points at the entry
+func (entry FHIRBundleEntry) refersTo(reference string) bool {
+	if reference == "" || entry.Resource == nil {
+		return false
+	}
+	if entry.FullUrl != "" && reference == entry.FullUrl {
+		return true
+	}
+	id := entry.Resource.ResourceType + "/" + entry.Resource.Id
+	return entry.Resource.Id != "" && (reference == id || strings.HasSuffix(reference, "/"+id))
+}
+
+// fhirPatientFor finds the Patient in a Bundle that is the person: the only
+// one, or the only one born on the person's birthday. There is no patient
+// and no problem when the Bundle has no Patient entries.
+func fhirPatientFor(bundle FHIRBundle, person Person) (patient *FHIRBundleEntry, problem string) {
+	birthday := person.Birthday.Format("2006-01-02")
+	var patients, born []*FHIRBundleEntry
+	for i, entry := range bundle.Entry {
+		if entry.Resource == nil || entry.Resource.ResourceType != "Patient" {
+			continue
+		}
+		patients = append(patients, &bundle.Entry[i])
+		if entry.Resource.BirthDate == birthday {
+			born = append(born, &bundle.Entry[i])
+		}
+	}
+	switch {
+	case len(patients) == 0:
+		return nil, ""
+	case len(patients) == 1:
+		if date := patients[0].Resource.BirthDate; date != "" && date != birthday {
+			return nil, fmt.Sprintf("the Bundle's patient was born %s, but %s was born %s", date, person.Name, birthday)
+		}
+		return patients[0], ""
+	case len(born) == 1:
+		return born[0], ""
+	default:
+		return nil, fmt.Sprintf("the Bundle has %d patients and %d of them were born %s, like %s; export one patient at a time", len(patients), len(born), birthday, person.Name)
+	}
+}
+
+// planFHIRImport reads the Observations of a Bundle into new measurements for
+// a person, reporting any that fail validation. Observations must be about
+// the Patient in the Bundle that is the person or, when the Bundle has no
+// Patient, all about the same subject. Days, or times for readings
+// that have one, that already have a measurement of the kind are counted as
+// duplicates.
+func planFHIRImport(bundle FHIRBundle, person Person, existing map[MeasurementKind][]Measurement) (entries []Measurement, duplicates int, issues []FHIRIssue) {
+	if bundle.ResourceType != "Bundle" {
+		return nil, 0, []FHIRIssue{{Problem: fmt.Sprintf("expected a Bundle, got %q", bundle.ResourceType)}}
+	}
+	if bundle.Type == "" {
+		return nil, 0, []FHIRIssue{{Problem: "Bundle.type is required"}}
+	}
+	patient, problem := fhirPatientFor(bundle, person)
+	if problem != "" {
+		return nil, 0, []FHIRIssue{{Problem: problem}}
+	}
+
+	var subject string
+	seen := make(map[string]bool)
+	for kind, measurements := range existing {
+		for _, measurement := range measurements {
+			seen[fmt.Sprintf("%d:%s", kind, measurement.exportDate())] = true
+		}
+	}
+	for i, entry := range bundle.Entry {
+		if entry.Resource == nil {
+			issues = append(issues, FHIRIssue{Entry: i + 1, Problem: "entry has no resource"})
+			continue
+		}
+		if entry.Resource.ResourceType != "Observation" {
+			continue
+		}
+		measurement, skip, err := fhirMeasurement(*entry.Resource)
+		if err != nil {
+			issues = append(issues, FHIRIssue{Entry: i + 1, Problem: err.Error()})
+			continue
+		}
+		if skip {
+			continue
+		}
+		reference := entry.Resource.Subject.Reference
+		if patient != nil && !patient.refersTo(reference) {
+			issues = append(issues, FHIRIssue{Entry: i + 1, Problem: fmt.Sprintf("subject %s is not %s", reference, person.Name)})
+			continue
+		}
+		if patient == nil {
+			if subject == "" {
+				subject = reference
+			} else if reference != subject {
+				issues = append(issues, FHIRIssue{Entry: i + 1, Problem: fmt.Sprintf("subject %s is a different patient from %s", reference, subject)})
+				continue
+			}
+		}
+		if measurement.Date.Before(person.Birthday) || measurement.Date.After(time.Now()) {
+			issues = append(issues, FHIRIssue{Entry: i + 1, Problem: fmt.Sprintf("%s is outside %s's life", measurement.Date.Format("2006-01-02"), person.Name)})
+			continue
+		}
+		key := fmt.Sprintf("%d:%s", measurement.Kind, measurement.exportDate())
+		if seen[key] {
+			duplicates++
+			continue
+		}
+		seen[key] = true
+		measurement.PersonId = person.Id
+		entries = append(entries, measurement)
+	}
+	return
+}
+
+func RegisterFHIRPages(mux *http.ServeMux) {
+	mux.Handle("GET /fhir/export/{id}", AuthHandler(ContextFunc(exportFHIR)))
+	mux.Handle("GET /fhir/import/{id}", OwnerHandler(ContextFunc(fhirImportPage)))
+	mux.Handle("POST /fhir/import/{id}", AuthHandler(ContextFunc(importFHIR)))
+}
+
+func exportFHIR(context ResponseContext) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	var person Person
+	var measurements []Measurement
+	var allowed bool
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person = getPerson(tx, personId)
+		allowed = isFamilyMemberTx(tx, context, person.FamilyId)
+		for _, info := range measurementKinds {
+			measurements = append(measurements, queryMeasurementsTx(tx, personId, info.Kind)...)
+		}
+	})
+	if !allowed {
+		http.Error(context.w, "not a member of this family", http.StatusUnauthorized)
+		return
+	}
+
+	context.w.Header().Set("Content-Type", "application/fhir+json")
+	context.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName(person.Name, "fhir", "json")))
+	encoder := json.NewEncoder(context.w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(fhirBundleForPerson(person, measurements, time.Now())); err != nil {
+		http.Error(context.w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func fhirImportPage(context ResponseContext) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person := getPerson(tx, personId)
+		context.familyId = person.FamilyId
+		RenderTemplateWithData(context, "fhir-import", map[string]any{
+			"Person": person,
+		})
+	})
+}
+
+func importFHIR(context ResponseContext) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	if err := context.r.ParseMultipartForm(10 << 20); err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, _, err := context.r.FormFile("bundle")
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var bundle FHIRBundle
+	var person Person
+	var entries []Measurement
+	var duplicates int
+	var issues []FHIRIssue
+	var isOwner bool
+	if err := json.Unmarshal(content, &bundle); err != nil {
+		issues = []FHIRIssue{{Problem: "not FHIR JSON: " + err.Error()}}
+	}
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		person = getPerson(tx, personId)
+		isOwner = isFamilyOwner(tx, person.FamilyId, context.user.Id)
+		if !isOwner || len(issues) > 0 {
+			return
+		}
+		existing := make(map[MeasurementKind][]Measurement)
+		for kind := range fhirKinds {
+			existing[kind] = queryMeasurementsTx(tx, person.Id, kind)
+		}
+		entries, duplicates, issues = planFHIRImport(bundle, person, existing)
+		heights := false
+		for i := range entries {
+			saveMeasurementTx(tx, &entries[i])
+			heights = heights || entries[i].Kind == HeightKind
+		}
+		if len(entries) > 0 {
+			refreshGrowthAlertsTx(tx, person.Id)
+		}
+		if heights {
+			recordHeightPredictionsTx(tx, person.FamilyId)
+		}
+		vbolt.TxCommit(tx)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+
+	setDisplayUnits(entries, requestUnits(context))
+	context.familyId = person.FamilyId
+	RenderTemplateWithData(context, "fhir-import", map[string]any{
+		"Person":     person,
+		"Imported":   entries,
+		"Duplicates": duplicates,
+		"Issues":     issues,
+		"Done":       true,
+	})
+}
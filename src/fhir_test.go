@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFHIRRoundTrip(t *testing.T) {
+	person := Person{Id: 3, Name: "Maia", Gender: Female, Birthday: time.Date(2019, 3, 12, 0, 0, 0, 0, time.UTC)}
+	measurements := []Measurement{
+		{Id: 1, PersonId: 3, Kind: HeightKind, Value: 50.2, Date: person.Birthday},
+		{Id: 2, PersonId: 3, Kind: WeightKind, Value: 3.4, Date: person.Birthday},
+		{Id: 3, PersonId: 3, Kind: ShoeSizeKind, Value: 4, Date: person.Birthday},
+	}
+	bundle := fhirBundleForPerson(person, measurements, time.Now())
+	if len(bundle.Entry) != 3 || bundle.Entry[0].Resource.ResourceType != "Patient" || bundle.Entry[0].Resource.Gender != "female" {
+		t.Fatalf("unexpected bundle: %+v", bundle)
+	}
+	content, _ := json.Marshal(bundle)
+	if !strings.Contains(string(content), `"code":"8302-2"`) || !strings.Contains(string(content), `"code":"kg"`) {
+		t.Errorf("missing LOINC or UCUM codes: %s", content)
+	}
+
+	var decoded FHIRBundle
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	existing := map[MeasurementKind][]Measurement{WeightKind: {measurements[1]}}
+	entries, duplicates, issues := planFHIRImport(decoded, person, existing)
+	if len(entries) != 1 || entries[0].Kind != HeightKind || entries[0].Value != 50.2 || duplicates != 1 || len(issues) != 0 {
+		t.Errorf("unexpected import: %+v %d %+v", entries, duplicates, issues)
+	}
+}
+
+func TestFHIRImportValidation(t *testing.T) {
+	person := Person{Id: 3, Name: "Maia", Birthday: time.Date(2019, 3, 12, 0, 0, 0, 0, time.UTC)}
+	bundle := `{"resourceType": "Bundle", "type": "searchset", "entry": [
+		{"resource": {"resourceType": "Observation", "status": "final",
+			"code": {"coding": [{"system": "http://loinc.org", "code": "29463-7"}]},
+			"subject": {"reference": "Patient/p1"}, "effectiveDateTime": "2020-01-15T10:30:00-05:00",
+			"valueQuantity": {"value": 20.5, "system": "http://unitsofmeasure.org", "code": "[lb_av]"}}},
+		{"resource": {"resourceType": "Observation",
+			"code": {"coding": [{"system": "http://loinc.org", "code": "8302-2"}]},
+			"subject": {"reference": "Patient/p1"}, "effectiveDateTime": "2020-01-15"}},
+		{"resource": {"resourceType": "Observation", "status": "final",
+			"code": {"coding": [{"system": "http://loinc.org", "code": "8302-2"}]},
+			"subject": {"reference": "Patient/p1"}, "effectiveDateTime": "2020-01",
+			"valueQuantity": {"value": 80, "system": "http://unitsofmeasure.org", "code": "cm"}}},
+		{"resource": {"resourceType": "Observation", "status": "final",
+			"code": {"coding": [{"system": "http://loinc.org", "code": "8867-4"}]},
+			"subject": {"reference": "Patient/p1"}, "effectiveDateTime": "2020-01-15",
+			"valueQuantity": {"value": 110, "system": "http://unitsofmeasure.org", "code": "/min"}}},
+		{"resource": {"resourceType": "Observation", "status": "final",
+			"code": {"coding": [{"system": "http://loinc.org", "code": "8310-5"}]},
+			"subject": {"reference": "Patient/p1"}, "effectiveDateTime": "2020-01-16",
+			"valueQuantity": {"value": 38.2, "unit": "C", "code": "Cel"}}}
+	]}`
+	var decoded FHIRBundle
+	if err := json.Unmarshal([]byte(bundle), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	entries, _, issues := planFHIRImport(decoded, person, nil)
+	if len(entries) != 1 || entries[0].Kind != WeightKind || entries[0].Date.Format("2006-01-02") != "2020-01-15" {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+	// entries 2, 3 and 5 fail; the heart rate in 4 is skipped quietly
+	if len(issues) != 3 || issues[0].Entry != 2 || issues[1].Entry != 3 || issues[2].Entry != 5 {
+		t.Errorf("unexpected issues: %+v", issues)
+	}
+}
+
+func TestFHIRTemperatureTimes(t *testing.T) {
+	person := Person{Id: 3, Name: "Maia", Gender: Female, Birthday: time.Date(2019, 3, 12, 0, 0, 0, 0, time.UTC)}
+	taken := time.Date(2024, 2, 3, 1, 30, 0, 0, time.UTC)
+	legacy := time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)
+	measurements := []Measurement{
+		{Id: 1, PersonId: 3, Kind: TemperatureKind, Value: 38.5, Date: taken},
+		{Id: 2, PersonId: 3, Kind: TemperatureKind, Value: 37.9, Date: legacy, DateOnly: true},
+	}
+	bundle := fhirBundleForPerson(person, measurements, time.Now())
+	if got := bundle.Entry[1].Resource.EffectiveDateTime; got != "2024-02-03T01:30:00Z" {
+		t.Errorf("expected the full timestamp, got %q", got)
+	}
+	if got := bundle.Entry[2].Resource.EffectiveDateTime; got != "2024-02-04" {
+		t.Errorf("expected just the day for a date-only reading, got %q", got)
+	}
+
+	entries, duplicates, issues := planFHIRImport(bundle, person, nil)
+	if len(entries) != 2 || duplicates != 0 || len(issues) != 0 {
+		t.Fatalf("unexpected import: %+v %d %+v", entries, duplicates, issues)
+	}
+	if !entries[0].Date.Equal(taken) || entries[0].DateOnly {
+		t.Errorf("expected the time to be kept, got %+v", entries[0])
+	}
+	if !entries[1].Date.Equal(legacy) || !entries[1].DateOnly {
+		t.Errorf("expected a date-only reading, got %+v", entries[1])
+	}
+
+	// date-only readings keep their day on a clock behind UTC
+	localMeasurementTimes(measurements, time.FixedZone("EST", -5*60*60))
+	if measurements[1].Date.Day() != 4 || measurements[0].Date.Day() != 2 {
+		t.Errorf("unexpected local times: %v %v", measurements[0].Date, measurements[1].Date)
+	}
+}
+
+func TestFHIRImportSubjects(t *testing.T) {
+	person := Person{Id: 3, Name: "Maia", Birthday: time.Date(2019, 3, 12, 0, 0, 0, 0, time.UTC)}
+	observation := func(subject string, kg float64) string {
+		return `{"resource": {"resourceType": "Observation", "status": "final",
+			"code": {"coding": [{"system": "http://loinc.org", "code": "29463-7"}]},
+			"subject": {"reference": "` + subject + `"}, "effectiveDateTime": "2020-01-15",
+			"valueQuantity": {"value": ` + strconv.FormatFloat(kg, 'f', -1, 64) + `, "system": "http://unitsofmeasure.org", "code": "kg"}}}`
+	}
+	patient := func(id string, birthDate string) string {
+		return `{"fullUrl": "https://portal.example/fhir/Patient/` + id + `",
+			"resource": {"resourceType": "Patient", "id": "` + id + `", "birthDate": "` + birthDate + `"}}`
+	}
+	plan := func(entries ...string) ([]Measurement, []FHIRIssue) {
+		var bundle FHIRBundle
+		content := `{"resourceType": "Bundle", "type": "searchset", "entry": [` + strings.Join(entries, ",") + `]}`
+		if err := json.Unmarshal([]byte(content), &bundle); err != nil {
+			t.Fatal(err)
+		}
+		measurements, _, issues := planFHIRImport(bundle, person, nil)
+		return measurements, issues
+	}
+
+	// a sibling's observation in the same Bundle is rejected
+	entries, issues := plan(patient("p1", "2019-03-12"), patient("p2", "2021-06-01"),
+		observation("Patient/p1", 12), observation("https://portal.example/fhir/Patient/p1", 12.5), observation("Patient/p2", 9))
+	if len(entries) != 1 || len(issues) != 1 || issues[0].Entry != 5 {
+		t.Errorf("expected p1's weight and an issue for p2's, got %+v %+v", entries, issues)
+	}
+
+	// the only patient, born on another day, is someone else
+	if entries, issues := plan(patient("p2", "2021-06-01"), observation("Patient/p2", 9)); len(entries) != 0 || len(issues) != 1 || issues[0].Entry != 0 {
+		t.Errorf("expected the Bundle to be rejected, got %+v %+v", entries, issues)
+	}
+
+	// without a Patient entry every observation has to share a subject
+	entries, issues = plan(observation("Patient/p1", 12), observation("Patient/p2", 9))
+	if len(entries) != 1 || len(issues) != 1 || issues[0].Entry != 2 {
+		t.Errorf("expected the second subject to be rejected, got %+v %+v", entries, issues)
+	}
+}
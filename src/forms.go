@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Form reads submitted fields and collects one problem per field, so a page
+// can be rendered again with the input kept and the problems next to it.
+type Form struct {
+	values url.Values
+	loc    *time.Location // the viewer's zone, which decides what today is
+	Errors map[string]string
+}
+
+func bindForm(r *http.Request) *Form {
+	r.ParseForm()
+	return &Form{values: r.Form, loc: requestLocation(r), Errors: make(map[string]string)}
+}
+
+func (form *Form) Value(field string) string {
+	return strings.TrimSpace(form.values.Get(field))
+}
+
+func (form *Form) Checked(field string) bool {
+	return form.values.Get(field) == "on"
+}
+
+// Fail records a problem with a field; the first one reported is kept
+func (form *Form) Fail(field string, format string, args ...any) {
+	if _, found := form.Errors[field]; !found {
+		form.Errors[field] = fmt.Sprintf(format, args...)
+	}
+}
+
+func (form *Form) Valid() bool {
+	return len(form.Errors) == 0
+}
+
+func (form *Form) String(field string, label string, required bool) string {
+	value := form.Value(field)
+	if required && value == "" {
+		form.Fail(field, "%s is required", label)
+	}
+	return value
+}
+
+// List reads every non-blank value of a repeated field, like a group of
+// checkboxes sharing a name
+func (form *Form) List(field string) (values []string) {
+	for _, value := range form.values[field] {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return
+}
+
+func (form *Form) Int(field string, label string, required bool) int {
+	value := form.String(field, label, required)
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		form.Fail(field, "%s must be a whole number", label)
+	}
+	return n
+}
+
+func (form *Form) Float(field string, label string, required bool) float64 {
+	value := form.String(field, label, required)
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		form.Fail(field, "%s must be a number", label)
+		return 0
+	}
+	return n
+}
+
+func (form *Form) Date(field string, label string, required bool) time.Time {
+	value := form.String(field, label, required)
+	if value == "" {
+		return time.Time{}
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		form.Fail(field, "%s is not a valid date", label)
+	}
+	return date
+}
+
+// DateTime reads a date and time of day, as sent by a datetime-local input,
+// on the wall clock of loc
+func (form *Form) DateTime(field string, label string, required bool, loc *time.Location) time.Time {
+	value := form.String(field, label, required)
+	if value == "" {
+		return time.Time{}
+	}
+	date, err := time.ParseInLocation("2006-01-02T15:04", value, loc)
+	if err != nil {
+		form.Fail(field, "%s is not a valid date and time", label)
+	}
+	return date
+}
+
+// requestLocation is the viewer's time zone, which base.html keeps in the
+// tz cookie; the server's zone stands in until the cookie is set
+func requestLocation(r *http.Request) *time.Location {
+	cookie, err := r.Cookie("tz")
+	if err != nil {
+		return time.Local
+	}
+	name, err := url.QueryUnescape(cookie.Value)
+	if err != nil {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// NotFuture rejects dates after today, where today is the viewer's. Only the
+// calendar day is compared, so a date entered as midnight UTC is judged by the
+// day the viewer picked.
+func (form *Form) NotFuture(field string, label string, date time.Time) {
+	loc := form.loc
+	if loc == nil {
+		loc = time.Local
+	}
+	if afterDay(date, time.Now().In(loc)) {
+		form.Fail(field, "%s can't be in the future", label)
+	}
+}
+
+// afterDay reports whether date's calendar day comes after now's, each read
+// in its own zone
+func afterDay(date time.Time, now time.Time) bool {
+	year, month, day := date.Date()
+	nowYear, nowMonth, nowDay := now.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC).After(time.Date(nowYear, nowMonth, nowDay, 0, 0, 0, 0, time.UTC))
+}
+
+// DuringLife rejects dates before a person was born or after today
+func (form *Form) DuringLife(field string, label string, date time.Time, person Person) {
+	if date.IsZero() {
+		return
+	}
+	if date.Before(person.Birthday) {
+		form.Fail(field, "%s can't be before %s's birthday (%s)", label, person.Name, person.Birthday.Format("Jan 2, 2006"))
+	}
+	form.NotFuture(field, label, date)
+}
+
+// fieldError is used by templates to show a field's problem, if any
+func fieldError(errors map[string]string, field string) string {
+	return errors[field]
+}
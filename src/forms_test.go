@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormValidation(t *testing.T) {
+	values := url.Values{"value": {"abc"}, "measureDate": {"2019-01-01"}}
+	req := httptest.NewRequest("POST", "/", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	form := bindForm(req)
+
+	form.Float("value", "Height", true)
+	form.Int("personId", "Person", true)
+	date := form.Date("measureDate", "Measurement date", true)
+	form.DuringLife("measureDate", "Measurement date", date, Person{Name: "Maia", Birthday: time.Date(2019, 3, 12, 0, 0, 0, 0, time.UTC)})
+
+	for _, field := range []string{"value", "personId", "measureDate"} {
+		if form.Errors[field] == "" {
+			t.Fatalf("expected an error for %s, got %v", field, form.Errors)
+		}
+	}
+}
+
+func TestMeasurementOutlier(t *testing.T) {
+	info := HeightKind.Info()
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	others := []Measurement{
+		{Id: 1, Kind: HeightKind, Value: UnitInches.ToCanonical(30), Date: day},
+		{Id: 2, Kind: HeightKind, Value: UnitInches.ToCanonical(32), Date: day.AddDate(0, 6, 0)},
+	}
+
+	typo := Measurement{Kind: HeightKind, Value: UnitInches.ToCanonical(71), Date: day.AddDate(0, 3, 0)}
+	if err := info.CheckOutlier(typo, others, UnitInches); err == nil {
+		t.Fatalf("a 40 inch jump was not flagged")
+	}
+	normal := Measurement{Kind: HeightKind, Value: UnitInches.ToCanonical(31), Date: day.AddDate(0, 3, 0)}
+	if err := info.CheckOutlier(normal, others, UnitInches); err != nil {
+		t.Fatalf("a normal height was flagged: %v", err)
+	}
+}
+
+func TestNotFuture(t *testing.T) {
+	pacific := time.FixedZone("PST", -8*60*60)
+	sydney := time.FixedZone("AEST", 10*60*60)
+	cases := []struct {
+		name   string
+		date   time.Time
+		now    time.Time
+		future bool
+	}{
+		// late evening in California is already tomorrow in UTC
+		{"today, west of UTC", time.Date(2026, 2, 3, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 3, 23, 30, 0, 0, pacific), false},
+		{"tomorrow, west of UTC", time.Date(2026, 2, 4, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 3, 23, 30, 0, 0, pacific), true},
+		// early morning in Sydney is still yesterday in UTC
+		{"today, east of UTC", time.Date(2026, 2, 4, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 4, 8, 0, 0, 0, sydney), false},
+		{"later today", time.Date(2026, 2, 4, 21, 0, 0, 0, sydney), time.Date(2026, 2, 4, 8, 0, 0, 0, sydney), false},
+		{"yesterday", time.Date(2026, 2, 3, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 4, 8, 0, 0, 0, sydney), false},
+	}
+	for _, c := range cases {
+		if future := afterDay(c.date, c.now); future != c.future {
+			t.Errorf("%s: expected future %v, got %v", c.name, c.future, future)
+		}
+	}
+
+	// the viewer's today passes, whatever the server's clock says
+	req := httptest.NewRequest("POST", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "tz", Value: url.QueryEscape("Pacific/Kiritimati")})
+	form := bindForm(req)
+	now := time.Now().In(form.loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	form.NotFuture("date", "Date", today)
+	form.NotFuture("tomorrow", "Date", today.AddDate(0, 0, 1))
+	if form.Errors["date"] != "" {
+		t.Errorf("expected the viewer's today to be accepted: %v", form.Errors)
+	}
+	if form.Errors["tomorrow"] == "" {
+		t.Error("expected the viewer's tomorrow to be refused")
+	}
+}
+
+func TestFormDateTime(t *testing.T) {
+	values := url.Values{"time": {"2026-02-03T07:45"}, "bad": {"2026-02-03"}}
+	req := httptest.NewRequest("POST", "/", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	form := bindForm(req)
+
+	loc := time.FixedZone("EST", -5*60*60)
+	given := form.DateTime("time", "Time given", true, loc)
+	if !given.Equal(time.Date(2026, 2, 3, 12, 45, 0, 0, time.UTC)) {
+		t.Errorf("unexpected time %v", given)
+	}
+	form.DateTime("bad", "Time given", true, loc)
+	if form.Errors["bad"] == "" {
+		t.Errorf("expected a date without a time to be refused")
+	}
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+)
+
+// GEDCOM 5.5.1 and 7.0 share the same line grammar:
+//
+//	level [@xref@] TAG [value]
+//
+// so one parser handles both. 7.0 drops CONC, which we still accept.
+
+const (
+	Gedcom551 = "5.5.1"
+	Gedcom70  = "7.0"
+)
+
+var ErrGedcomSyntax = errors.New("GedcomSyntax")
+
+type GedcomRecord struct {
+	Xref     string
+	Tag      string
+	Value    string
+	Children []*GedcomRecord
+}
+
+func (record *GedcomRecord) Child(tag string) *GedcomRecord {
+	for _, child := range record.Children {
+		if child.Tag == tag {
+			return child
+		}
+	}
+	return nil
+}
+
+func (record *GedcomRecord) ChildValue(tag string) string {
+	child := record.Child(tag)
+	if child == nil {
+		return ""
+	}
+	return child.Value
+}
+
+func (record *GedcomRecord) ChildValues(tag string) (values []string) {
+	for _, child := range record.Children {
+		if child.Tag == tag {
+			values = append(values, child.Value)
+		}
+	}
+	return
+}
+
+// ParseGedcom reads a GEDCOM stream into its top-level records.
+func ParseGedcom(r io.Reader) (records []*GedcomRecord, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var stack []*GedcomRecord
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if lineNumber == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			continue
+		}
+
+		level, record, parseErr := parseGedcomLine(line)
+		if parseErr != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, parseErr)
+		}
+		if level > len(stack) {
+			return nil, fmt.Errorf("line %d: level %d skips a level: %w", lineNumber, level, ErrGedcomSyntax)
+		}
+		stack = stack[:level]
+
+		if level == 0 {
+			records = append(records, record)
+			stack = append(stack, record)
+			continue
+		}
+
+		parent := stack[level-1]
+		switch record.Tag {
+		case "CONT":
+			parent.Value += "\n" + record.Value
+		case "CONC":
+			parent.Value += record.Value
+		default:
+			parent.Children = append(parent.Children, record)
+			stack = append(stack, record)
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+func parseGedcomLine(line string) (level int, record *GedcomRecord, err error) {
+	record = &GedcomRecord{}
+
+	levelText, rest, _ := strings.Cut(line, " ")
+	level, err = strconv.Atoi(levelText)
+	if err != nil || level < 0 {
+		return 0, nil, ErrGedcomSyntax
+	}
+
+	if strings.HasPrefix(rest, "@") {
+		xref, after, found := strings.Cut(rest, " ")
+		if !found || !strings.HasSuffix(xref, "@") {
+			return 0, nil, ErrGedcomSyntax
+		}
+		record.Xref = xref
+		rest = after
+	}
+
+	record.Tag, record.Value, _ = strings.Cut(rest, " ")
+	if record.Tag == "" {
+		return 0, nil, ErrGedcomSyntax
+	}
+	return
+}
+
+// parseGedcomDate understands exact dates ("12 MAR 2019"), month precision
+// ("MAR 2019") and year precision ("2019"). Qualifiers like ABT or EST are
+// dropped; ranges and periods are not supported.
+func parseGedcomDate(value string) (time.Time, error) {
+	fields := strings.Fields(strings.ToUpper(value))
+	for len(fields) > 0 {
+		switch fields[0] {
+		case "ABT", "CAL", "EST", "BEF", "AFT":
+			fields = fields[1:]
+			continue
+		}
+		break
+	}
+	for i := range fields {
+		if len(fields[i]) == 3 {
+			fields[i] = fields[i][:1] + strings.ToLower(fields[i][1:])
+		}
+	}
+
+	text := strings.Join(fields, " ")
+	for _, layout := range []string{"2 Jan 2006", "Jan 2006", "2006"} {
+		parsed, err := time.Parse(layout, text)
+		if err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported date %q", value)
+}
+
+func formatGedcomDate(t time.Time) string {
+	return strings.ToUpper(t.Format("2 Jan 2006"))
+}
+
+func parseGedcomName(value string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(value, "/", " ")), " ")
+}
+
+type GedcomIndividual struct {
+	Xref      string
+	Name      string
+	Gender    GenderType
+	Birthday  time.Time
+	Type      PersonType
+	PhotoFile string
+}
+
+// ReadGedcomIndividuals maps INDI records to people. Anyone listed as HUSB or
+// WIFE in a FAM record is a parent, everybody else is treated as a child.
+func ReadGedcomIndividuals(records []*GedcomRecord) (individuals []GedcomIndividual) {
+	spouses := make(map[string]bool)
+	for _, record := range records {
+		if record.Tag != "FAM" {
+			continue
+		}
+		for _, xref := range record.ChildValues("HUSB") {
+			spouses[xref] = true
+		}
+		for _, xref := range record.ChildValues("WIFE") {
+			spouses[xref] = true
+		}
+	}
+
+	for _, record := range records {
+		if record.Tag != "INDI" {
+			continue
+		}
+		individual := GedcomIndividual{
+			Xref:   record.Xref,
+			Name:   parseGedcomName(record.ChildValue("NAME")),
+			Gender: Undisclosed,
+			Type:   Child,
+		}
+		switch record.ChildValue("SEX") {
+		case "M":
+			individual.Gender = Male
+		case "F":
+			individual.Gender = Female
+		}
+		if spouses[record.Xref] {
+			individual.Type = Parent
+		}
+		if birth := record.Child("BIRT"); birth != nil {
+			individual.Birthday, _ = parseGedcomDate(birth.ChildValue("DATE"))
+		}
+		if object := record.Child("OBJE"); object != nil {
+			individual.PhotoFile = object.ChildValue("FILE")
+		}
+		individuals = append(individuals, individual)
+	}
+	return
+}
+
+type GedcomMatch struct {
+	Individual GedcomIndividual
+	Person     Person
+}
+
+type GedcomImportPlan struct {
+	New       []GedcomIndividual
+	Matched   []GedcomMatch
+	Conflicts []GedcomMatch
+}
+
+// planGedcomImport compares individuals against the people already in the
+// family. Names are matched case-insensitively; a name match with a different
+// birthday or gender is a conflict and is never written.
+func planGedcomImport(individuals []GedcomIndividual, people []Person) (plan GedcomImportPlan) {
+	byName := make(map[string]Person)
+	for _, person := range people {
+		byName[strings.ToLower(person.Name)] = person
+	}
+
+	for _, individual := range individuals {
+		person, found := byName[strings.ToLower(individual.Name)]
+		if !found {
+			plan.New = append(plan.New, individual)
+			continue
+		}
+		match := GedcomMatch{Individual: individual, Person: person}
+		sameBirthday := individual.Birthday.IsZero() || individual.Birthday.Equal(person.Birthday)
+		sameGender := individual.Gender == Undisclosed || individual.Gender == person.Gender
+		if sameBirthday && sameGender {
+			plan.Matched = append(plan.Matched, match)
+		} else {
+			plan.Conflicts = append(plan.Conflicts, match)
+		}
+	}
+	return
+}
+
+// WriteGedcom exports a family as a single FAM record. GEDCOM only allows one
+// HUSB and one WIFE per family, so additional parents, and relatives other
+// than parents and children, are written as individuals without family links.
+func WriteGedcom(w io.Writer, family Family, people []Person, images map[int]Image, version string) error {
+	out := bufio.NewWriter(w)
+	line := func(format string, args ...any) {
+		fmt.Fprintf(out, format+"\r\n", args...)
+	}
+
+	line("0 HEAD")
+	line("1 SOUR FAMILY_SITE")
+	line("1 GEDC")
+	line("2 VERS %s", version)
+	if version == Gedcom551 {
+		line("2 FORM LINEAGE-LINKED")
+		line("1 CHAR UTF-8")
+	}
+
+	familyXref := fmt.Sprintf("@F%d@", family.Id)
+	var husband, wife string
+	var children []string
+	for _, person := range people {
+		xref := fmt.Sprintf("@I%d@", person.Id)
+		line("0 %s INDI", xref)
+		line("1 NAME %s", person.Name)
+		switch person.Gender {
+		case Male:
+			line("1 SEX M")
+		case Female:
+			line("1 SEX F")
+		default:
+			line("1 SEX U")
+		}
+		if !person.Birthday.IsZero() {
+			line("1 BIRT")
+			line("2 DATE %s", formatGedcomDate(person.Birthday))
+		}
+		if image, ok := images[person.ImageId]; ok && image.Filename != "" {
+			line("1 OBJE")
+			line("2 FILE %s", image.Filename)
+			line("3 FORM %s", gedcomMediaForm(image.Filename, version))
+		}
+
+		switch {
+		case person.Type == Child:
+			line("1 FAMC %s", familyXref)
+			children = append(children, xref)
+		case person.Type != Parent:
+		case person.Gender == Male && husband == "":
+			line("1 FAMS %s", familyXref)
+			husband = xref
+		case person.Gender != Male && wife == "":
+			line("1 FAMS %s", familyXref)
+			wife = xref
+		}
+	}
+
+	line("0 %s FAM", familyXref)
+	if husband != "" {
+		line("1 HUSB %s", husband)
+	}
+	if wife != "" {
+		line("1 WIFE %s", wife)
+	}
+	for _, child := range children {
+		line("1 CHIL %s", child)
+	}
+	line("0 TRLR")
+
+	return out.Flush()
+}
+
+// gedcomMediaForm is a bare extension in 5.5.1 and a media type in 7.0.
+func gedcomMediaForm(filename string, version string) string {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	if ext == "jpeg" {
+		ext = "jpg"
+	}
+	if version == Gedcom551 {
+		return ext
+	}
+	switch ext {
+	case "jpg":
+		return "image/jpeg"
+	case "png", "gif", "webp":
+		return "image/" + ext
+	default:
+		return "application/octet-stream"
+	}
+}
+
+func RegisterGedcomPages(mux *http.ServeMux) {
+	mux.Handle("GET /family/gedcom/{id}", OwnerHandler(ContextFunc(gedcomPage)))
+	mux.Handle("POST /family/gedcom/{id}", AuthHandler(ContextFunc(importGedcom)))
+	mux.Handle("GET /family/gedcom/export/{id}", AuthHandler(ContextFunc(exportGedcom)))
+}
+
+func gedcomPage(context ResponseContext) {
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		id := context.r.PathValue("id")
+		idVal, _ := strconv.Atoi(id)
+		context.familyId = idVal
+		RenderTemplateWithData(context, "gedcom-import", map[string]any{
+			"Family": getFamily(tx, idVal),
+		})
+	})
+}
+
+// readGedcomUpload returns the uploaded file on the first (preview) request,
+// or the text the preview page carried forward on the commit request.
+func readGedcomUpload(context ResponseContext) (string, error) {
+	context.r.Body = http.MaxBytesReader(context.w, context.r.Body, 10<<23)
+	if err := context.r.ParseMultipartForm(10 << 23); err != nil {
+		return "", err
+	}
+
+	if text := context.r.FormValue("gedcom"); text != "" {
+		return text, nil
+	}
+
+	file, _, err := context.r.FormFile("gedcomFile")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	return string(content), err
+}
+
+func importGedcom(context ResponseContext) {
+	familyId, _ := strconv.Atoi(context.r.PathValue("id"))
+
+	var family Family
+	var people []Person
+	var isOwner bool
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		family = getFamily(tx, familyId)
+		people = getPeopleInFamily(tx, familyId)
+		isOwner = isFamilyOwner(tx, familyId, context.user.Id)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+
+	text, err := readGedcomUpload(context)
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	records, err := ParseGedcom(strings.NewReader(text))
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	plan := planGedcomImport(ReadGedcomIndividuals(records), people)
+
+	if context.r.FormValue("mode") != "commit" {
+		context.familyId = familyId
+		RenderTemplateWithData(context, "gedcom-preview", map[string]any{
+			"Family": family,
+			"Plan":   plan,
+			"Gedcom": text,
+		})
+		return
+	}
+
+	var familyImages map[string]Image
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		familyImages = familyImagesTx(tx, familyId, people)
+	})
+	var uploads []*multipart.FileHeader
+	if context.r.MultipartForm != nil {
+		uploads = context.r.MultipartForm.File["photos"]
+	}
+	// individual index => their picture, copied before anything is written
+	photos := make(map[int]Image)
+	for i, individual := range plan.New {
+		photo, found, err := importGedcomPhoto(context.user.Id, familyId, individual.PhotoFile, uploads, familyImages)
+		if err != nil {
+			for _, saved := range photos {
+				removeFiles(imageFiles(saved))
+			}
+			http.Error(context.w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if found {
+			photos[i] = photo
+		}
+	}
+
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		for i, individual := range plan.New {
+			entry := Person{
+				Id:       vbolt.NextIntId(tx, PersonBucket),
+				FamilyId: familyId,
+				Name:     individual.Name,
+				Gender:   individual.Gender,
+				Type:     individual.Type,
+				Birthday: individual.Birthday,
+			}
+			if photo, found := photos[i]; found {
+				photo.Id = vbolt.NextIntId(tx, ImageBucket)
+				SaveImage(tx, &photo)
+				entry.ImageId = photo.Id
+			}
+			vbolt.Write(tx, PersonBucket, entry.Id, &entry)
+			updatePersonIndex(tx, entry)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	http.Redirect(context.w, context.r, "/", http.StatusFound)
+}
+
+// familyImagesTx maps the stored file names of the family's own pictures,
+// which is what an exported GEDCOM names in OBJE FILE, to their records
+func familyImagesTx(tx *vbolt.Tx, familyId int, people []Person) map[string]Image {
+	images := make(map[string]Image)
+	vbolt.IterateAll(tx, ImageBucket, func(key int, value Image) bool {
+		if value.FamilyId == familyId && value.Filename != "" {
+			images[value.Filename] = value
+		}
+		return true
+	})
+	for _, person := range people {
+		var image Image
+		if person.ImageId > 0 && vbolt.Read(tx, ImageBucket, person.ImageId, &image) && image.Filename != "" {
+			images[image.Filename] = image
+		}
+	}
+	return images
+}
+
+// gedcomMediaName is the file name at the end of an OBJE FILE path, which
+// may have been written on Windows
+func gedcomMediaName(photoFile string) string {
+	return path.Base(strings.ReplaceAll(photoFile, "\\", "/"))
+}
+
+// importGedcomPhoto copies the picture an OBJE FILE names into a new image of
+// its own, with a thumbnail. GEDCOM files do not embed media, so the picture
+// has to be uploaded with the import or already be one of the family's;
+// anything else in uploads/ belongs to someone else and is never linked.
+func importGedcomPhoto(ownerId int, familyId int, photoFile string, uploads []*multipart.FileHeader, familyImages map[string]Image) (image Image, found bool, err error) {
+	if photoFile == "" {
+		return
+	}
+	name := gedcomMediaName(photoFile)
+	var source io.ReadCloser
+	for _, header := range uploads {
+		if header.Filename == name {
+			if source, err = header.Open(); err != nil {
+				return
+			}
+			break
+		}
+	}
+	if existing, ok := familyImages[name]; source == nil && ok {
+		if source, err = os.Open(buildPath(existing.Filename)); err != nil {
+			return image, false, nil
+		}
+		name = existing.Name()
+	}
+	if source == nil {
+		return
+	}
+	defer source.Close()
+
+	filename, err := saveUploadFile(source, name)
+	if err != nil {
+		return
+	}
+	smallFilename, err := saveThumbnail(filename)
+	if err != nil {
+		removeFiles([]string{buildPath(filename)})
+		return image, false, fmt.Errorf("%s is not a picture", name)
+	}
+	image = Image{
+		OwnerId:        ownerId,
+		FamilyId:       familyId,
+		Filename:       filename,
+		Small_Filename: smallFilename,
+		Access:         FamilyLevel,
+	}
+	return image, true, nil
+}
+
+func exportGedcom(context ResponseContext) {
+	familyId, _ := strconv.Atoi(context.r.PathValue("id"))
+	version := Gedcom551
+	if context.r.URL.Query().Get("version") == Gedcom70 {
+		version = Gedcom70
+	}
+
+	var family Family
+	var people []Person
+	var isOwner bool
+	images := make(map[int]Image)
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		family = getFamily(tx, familyId)
+		people = getPeopleInFamily(tx, familyId)
+		isOwner = isFamilyOwner(tx, familyId, context.user.Id)
+		for _, person := range people {
+			if person.ImageId > 0 {
+				var image Image
+				vbolt.Read(tx, ImageBucket, person.ImageId, &image)
+				images[image.Id] = image
+			}
+		}
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+
+	context.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	context.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"family-%d.ged\"", family.Id))
+	err := WriteGedcom(context.w, family, people, images, version)
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusInternalServerError)
+	}
+}
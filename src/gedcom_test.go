@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testGedcom = `0 HEAD
+1 GEDC
+2 VERS 5.5.1
+2 FORM LINEAGE-LINKED
+1 CHAR UTF-8
+0 @I1@ INDI
+1 NAME John /Smith/
+1 SEX M
+1 FAMS @F1@
+0 @I2@ INDI
+1 NAME Jane /Smith/
+1 SEX F
+1 BIRT
+2 DATE ABT MAR 1985
+1 FAMS @F1@
+0 @I3@ INDI
+1 NAME Maia /Smith/
+1 SEX F
+1 BIRT
+2 DATE 12 MAR 2019
+1 FAMC @F1@
+1 NOTE First line
+2 CONT second line
+0 @F1@ FAM
+1 HUSB @I1@
+1 WIFE @I2@
+1 CHIL @I3@
+0 TRLR
+`
+
+func TestGedcomImport(t *testing.T) {
+	records, err := ParseGedcom(strings.NewReader(testGedcom))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if len(records) != 6 {
+		t.Fatalf("expected 6 records, got %d", len(records))
+	}
+	if note := records[3].ChildValue("NOTE"); note != "First line\nsecond line" {
+		t.Fatalf("CONT not joined: %q", note)
+	}
+
+	individuals := ReadGedcomIndividuals(records)
+	expected := []GedcomIndividual{
+		{Xref: "@I1@", Name: "John Smith", Gender: Male, Type: Parent},
+		{Xref: "@I2@", Name: "Jane Smith", Gender: Female, Type: Parent, Birthday: time.Date(1985, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{Xref: "@I3@", Name: "Maia Smith", Gender: Female, Type: Child, Birthday: time.Date(2019, 3, 12, 0, 0, 0, 0, time.UTC)},
+	}
+	if len(individuals) != len(expected) {
+		t.Fatalf("expected %d individuals, got %d", len(expected), len(individuals))
+	}
+	for i, individual := range individuals {
+		if individual != expected[i] {
+			t.Fatalf("individual %d: expected %+v, got %+v", i, expected[i], individual)
+		}
+	}
+
+	people := []Person{
+		{Id: 1, Name: "john smith", Gender: Male},
+		{Id: 2, Name: "Jane Smith", Gender: Female, Birthday: time.Date(1986, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	plan := planGedcomImport(individuals, people)
+	if len(plan.New) != 1 || len(plan.Matched) != 1 || len(plan.Conflicts) != 1 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	if plan.New[0].Name != "Maia Smith" || plan.Conflicts[0].Person.Id != 2 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+}
+
+func TestGedcomRoundTrip(t *testing.T) {
+	family := Family{Id: 7, Name: "Smith"}
+	people := []Person{
+		{Id: 1, Name: "John Smith", Gender: Male, Type: Parent},
+		{Id: 3, Name: "Maia Smith", Gender: Female, Type: Child, Birthday: time.Date(2019, 3, 12, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, version := range []string{Gedcom551, Gedcom70} {
+		var buf bytes.Buffer
+		if err := WriteGedcom(&buf, family, people, nil, version); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		records, err := ParseGedcom(&buf)
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		if got := records[0].Child("GEDC").ChildValue("VERS"); got != version {
+			t.Fatalf("expected version %s, got %s", version, got)
+		}
+
+		individuals := ReadGedcomIndividuals(records)
+		if len(individuals) != 2 {
+			t.Fatalf("expected 2 individuals, got %d", len(individuals))
+		}
+		if individuals[0].Type != Parent || individuals[1].Type != Child {
+			t.Fatalf("types not preserved: %+v", individuals)
+		}
+		if !individuals[1].Birthday.Equal(people[1].Birthday) {
+			t.Fatalf("birthday not preserved: %v", individuals[1].Birthday)
+		}
+	}
+}
+
+func TestImportGedcomPhoto(t *testing.T) {
+	dir, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(dir)
+	os.MkdirAll("uploads", os.ModePerm)
+	for _, name := range []string{"100-maia.png", "200-other-family.png"} {
+		file, _ := os.Create(buildPath(name))
+		png.Encode(file, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+		file.Close()
+	}
+	familyImages := map[string]Image{"100-maia.png": {Id: 5, FamilyId: 1, Filename: "100-maia.png"}}
+
+	photo, found, err := importGedcomPhoto(9, 1, `C:\Photos\100-maia.png`, nil, familyImages)
+	if err != nil || !found {
+		t.Fatalf("expected the family's picture to be found: %v", err)
+	}
+	if photo.Filename == "100-maia.png" || photo.Small_Filename == "" || photo.FamilyId != 1 || photo.Access != FamilyLevel {
+		t.Errorf("expected a copy with its own thumbnail: %+v", photo)
+	}
+	for _, file := range imageFiles(photo) {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("missing %s", file)
+		}
+	}
+
+	if _, found, _ := importGedcomPhoto(9, 1, "200-other-family.png", nil, familyImages); found {
+		t.Errorf("a file that is not the family's must not be linked")
+	}
+}
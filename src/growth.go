@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LMSPoint is one row of a growth reference: the Box-Cox power (L), median (M)
+// and coefficient of variation (S) at a point, in metric units. At is the age
+// in months, except for weight-for-length where it is the length in cm.
+type LMSPoint struct {
+	At float64
+	L  float64
+	M  float64
+	S  float64
+}
+
+type GrowthReference struct {
+	Source string
+	Gender GenderType
+	Points []LMSPoint
+}
+
+// The WHO Child Growth Standards cover birth to 24 months and the CDC 2000
+// growth charts cover 2 to 20 years. The points are the published monthly
+// tables in growthdata/, filled in by loadGrowthData at startup; ages in
+// between are interpolated.
+var growthReferences = map[MeasurementKind][]GrowthReference{
+	HeightKind: {{Source: "WHO", Gender: Male}, {Source: "WHO", Gender: Female}, {Source: "CDC", Gender: Male}, {Source: "CDC", Gender: Female}},
+	WeightKind: {{Source: "WHO", Gender: Male}, {Source: "WHO", Gender: Female}, {Source: "CDC", Gender: Male}, {Source: "CDC", Gender: Female}},
+}
+
+// percentiles drawn as reference bands on the charts
+var bandPercentiles = []float64{3, 10, 25, 50, 75, 90, 97}
+
+//go:embed growthdata
+var growthData embed.FS
+
+// growthDataFile is a published LMS table in growthdata/. At names the
+// column holding the age or length; files with a Sex column (1 male, 2
+// female) fill both genders, the others fill Gender.
+type growthDataFile struct {
+	Name       string
+	At         string
+	Source     string
+	Gender     GenderType
+	References []GrowthReference
+}
+
+var growthDataFiles = []growthDataFile{
+	{Name: "lhfa_boys.txt", At: "Month", Source: "WHO", Gender: Male, References: growthReferences[HeightKind]},
+	{Name: "lhfa_girls.txt", At: "Month", Source: "WHO", Gender: Female, References: growthReferences[HeightKind]},
+	{Name: "wfa_boys.txt", At: "Month", Source: "WHO", Gender: Male, References: growthReferences[WeightKind]},
+	{Name: "wfa_girls.txt", At: "Month", Source: "WHO", Gender: Female, References: growthReferences[WeightKind]},
+	{Name: "statage.csv", At: "Agemos", Source: "CDC", References: growthReferences[HeightKind]},
+	{Name: "wtage.csv", At: "Agemos", Source: "CDC", References: growthReferences[WeightKind]},
+	{Name: "bmiagerev.csv", At: "Agemos", Source: "CDC", References: bmiReferences},
+	{Name: "wfl_boys.txt", At: "Length", Source: "WHO", Gender: Male, References: weightForLengthReferences},
+	{Name: "wfl_girls.txt", At: "Length", Source: "WHO", Gender: Female, References: weightForLengthReferences},
+}
+
+// loadGrowthData fills the references from the published tables in
+// growthdata/. Every table is required; percentiles from anything else
+// would be passed off as CDC or WHO ones.
+func loadGrowthData() error {
+	var missing []string
+	for _, file := range growthDataFiles {
+		content, err := growthData.ReadFile("growthdata/" + file.Name)
+		if errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, file.Name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		tables, err := parseLMSTable(content, file.At, file.Gender)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+		for i := range file.References {
+			reference := &file.References[i]
+			if points, found := tables[reference.Gender]; found && reference.Source == file.Source {
+				reference.Points = points
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s; see growthdata/README.txt", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// parseLMSTable reads a CDC (comma separated) or WHO (tab separated) LMS
+// table. Repeated header rows, which the CDC files have, are skipped.
+func parseLMSTable(content []byte, atColumn string, gender GenderType) (map[GenderType][]LMSPoint, error) {
+	reader := csv.NewReader(bytes.NewReader(content))
+	if line, _, _ := bytes.Cut(content, []byte("\n")); bytes.Contains(line, []byte("\t")) {
+		reader.Comma = '\t'
+	}
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("empty table")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{atColumn, "L", "M", "S"} {
+		if _, found := columns[strings.ToLower(name)]; !found {
+			return nil, fmt.Errorf("no %s column", name)
+		}
+	}
+	sexColumn, hasSex := columns["sex"]
+
+	tables := make(map[GenderType][]LMSPoint)
+	for n, row := range rows[1:] {
+		if strings.EqualFold(strings.TrimSpace(row[0]), strings.TrimSpace(rows[0][0])) {
+			continue
+		}
+		field := func(name string) (float64, error) {
+			i := columns[strings.ToLower(name)]
+			if i >= len(row) {
+				return 0, fmt.Errorf("row %d: no %s", n+2, name)
+			}
+			value, err := strconv.ParseFloat(strings.TrimSpace(row[i]), 64)
+			if err != nil {
+				return 0, fmt.Errorf("row %d: %s: %w", n+2, name, err)
+			}
+			return value, nil
+		}
+
+		rowGender := gender
+		if hasSex && sexColumn < len(row) {
+			switch strings.TrimSpace(row[sexColumn]) {
+			case "1":
+				rowGender = Male
+			case "2":
+				rowGender = Female
+			default:
+				return nil, fmt.Errorf("row %d: unknown sex %q", n+2, row[sexColumn])
+			}
+		}
+
+		var point LMSPoint
+		var errs [4]error
+		point.At, errs[0] = field(atColumn)
+		point.L, errs[1] = field("L")
+		point.M, errs[2] = field("M")
+		point.S, errs[3] = field("S")
+		if err := errors.Join(errs[:]...); err != nil {
+			return nil, err
+		}
+		tables[rowGender] = append(tables[rowGender], point)
+	}
+	for _, points := range tables {
+		for i := 1; i < len(points); i++ {
+			if points[i].At <= points[i-1].At {
+				return nil, fmt.Errorf("%s %v is out of order", atColumn, points[i].At)
+			}
+		}
+	}
+	return tables, nil
+}
+
+func findGrowthReference(references []GrowthReference, source string, gender GenderType) (GrowthReference, bool) {
+	for _, reference := range references {
+		if reference.Source == source && reference.Gender == gender {
+			return reference, true
+		}
+	}
+	return GrowthReference{}, false
+}
+
+// ageReference picks WHO under 24 months and CDC from 24 months on
+func ageReference(references []GrowthReference, gender GenderType, ageMonths float64) (GrowthReference, bool) {
+	if ageMonths < 24 {
+		return findGrowthReference(references, "WHO", gender)
+	}
+	return findGrowthReference(references, "CDC", gender)
+}
+
+// LMS interpolates the reference at a point inside its range
+func (reference GrowthReference) LMS(at float64) (LMSPoint, bool) {
+	points := reference.Points
+	if len(points) == 0 || at < points[0].At || at > points[len(points)-1].At {
+		return LMSPoint{}, false
+	}
+	for i := 1; i < len(points); i++ {
+		if at <= points[i].At {
+			a, b := points[i-1], points[i]
+			return LMSPoint{
+				At: at,
+				L:  interpolate(a.At, a.L, b.At, b.L, at),
+				M:  interpolate(a.At, a.M, b.At, b.M, at),
+				S:  interpolate(a.At, a.S, b.At, b.S, at),
+			}, true
+		}
+	}
+	return points[0], true
+}
+
+func ageLMS(references []GrowthReference, gender GenderType, ageMonths float64) (LMSPoint, bool) {
+	reference, found := ageReference(references, gender, ageMonths)
+	if !found {
+		return LMSPoint{}, false
+	}
+	return reference.LMS(ageMonths)
+}
+
+func (lms LMSPoint) ZScore(value float64) float64 {
+	if lms.L == 0 {
+		return math.Log(value/lms.M) / lms.S
+	}
+	return (math.Pow(value/lms.M, lms.L) - 1) / (lms.L * lms.S)
+}
+
+// ValueAt is the measurement at a z-score, the inverse of ZScore
+func (lms LMSPoint) ValueAt(z float64) float64 {
+	if lms.L == 0 {
+		return lms.M * math.Exp(lms.S*z)
+	}
+	return lms.M * math.Pow(1+lms.L*lms.S*z, 1/lms.L)
+}
+
+func zScoreToPercentile(z float64) float64 {
+	return 50 * (1 + math.Erf(z/math.Sqrt2))
+}
+
+// percentileToZScore inverts zScoreToPercentile by bisection
+func percentileToZScore(percentile float64) float64 {
+	low, high := -6.0, 6.0
+	for i := 0; i < 60; i++ {
+		mid := (low + high) / 2
+		if zScoreToPercentile(mid) < percentile {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return (low + high) / 2
+}
+
+// growthPercentile scores a metric value; found is false when there is no
+// reference for the kind, sex or age.
+func growthPercentile(kind MeasurementKind, gender GenderType, ageYears float64, value float64) (z float64, percentile float64, found bool) {
+	lms, found := ageLMS(growthReferences[kind], gender, ageYears*12)
+	if !found || value <= 0 {
+		return 0, 0, false
+	}
+	z = lms.ZScore(value)
+	return z, zScoreToPercentile(z), true
+}
+
+type BandPoint struct {
+	Age   float64
+	Value float64
+}
+
+type PercentileBand struct {
+	Percentile float64
+	Points     []BandPoint
+}
+
+// percentileBands traces each band percentile of age based references at the
+// table ages up to maxAgeYears, converted into unit.
+func percentileBands(references []GrowthReference, gender GenderType, maxAgeYears float64, unit Unit) (bands []PercentileBand) {
+	var ages []float64
+	for _, source := range []string{"WHO", "CDC"} {
+		reference, _ := findGrowthReference(references, source, gender)
+		for _, point := range reference.Points {
+			if point.At <= maxAgeYears*12 && (source == "CDC" || point.At < 24) {
+				ages = append(ages, point.At)
+			}
+		}
+	}
+	if len(ages) == 0 {
+		return nil
+	}
+
+	for _, percentile := range bandPercentiles {
+		band := PercentileBand{Percentile: percentile}
+		z := percentileToZScore(percentile)
+		for _, ageMonths := range ages {
+			if lms, found := ageLMS(references, gender, ageMonths); found {
+				band.Points = append(band.Points, BandPoint{Age: ageMonths / 12, Value: unit.FromCanonical(lms.ValueAt(z))})
+			}
+		}
+		bands = append(bands, band)
+	}
+	return
+}
+
+// formatPercentile renders a percentile the way a pediatrician says it, e.g. "62nd"
+func formatPercentile(percentile float64) string {
+	if percentile < 1 {
+		return "<1st"
+	}
+	if percentile > 99 {
+		return ">99th"
+	}
+	n := int(math.Round(percentile))
+	suffix := "th"
+	if n%100 < 11 || n%100 > 13 {
+		switch n % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	return strconv.Itoa(n) + suffix
+}
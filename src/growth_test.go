@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseLMSTable(t *testing.T) {
+	// CDC layout: a Sex column and the header repeated before the second block
+	cdc := "Sex,Agemos,L,M,S,P3\n" +
+		"1,24,0.9,86.4,0.040,79.9\n" +
+		"1,24.5,1.0,86.8,0.040,80.3\n" +
+		"Sex,Agemos,L,M,S,P3\n" +
+		"2,24,1.0,84.9,0.040,78.6\n"
+	tables, err := parseLMSTable([]byte(cdc), "Agemos", Undisclosed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables[Male]) != 2 || len(tables[Female]) != 1 {
+		t.Fatalf("expected 2 male and 1 female rows, got %+v", tables)
+	}
+	if point := tables[Male][1]; point != (LMSPoint{At: 24.5, L: 1.0, M: 86.8, S: 0.040}) {
+		t.Errorf("unexpected point %+v", point)
+	}
+
+	// WHO layout: tab separated, one file per gender
+	who := "Length\tL\tM\tS\n45\t-0.35\t2.44\t0.091\n45.5\t-0.35\t2.52\t0.091\n"
+	tables, err = parseLMSTable([]byte(who), "Length", Female)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables[Female]) != 2 || tables[Female][1].At != 45.5 {
+		t.Errorf("expected two female rows, got %+v", tables)
+	}
+
+	if _, err := parseLMSTable([]byte("Sex,Agemos,L,M\n1,24,1,86\n"), "Agemos", Undisclosed); err == nil {
+		t.Error("expected a table without S to be rejected")
+	}
+	if _, err := parseLMSTable([]byte("Sex,Agemos,L,M,S\n1,25,1,86,0.04\n1,24,1,85,0.04\n"), "Agemos", Undisclosed); err == nil {
+		t.Error("expected rows out of order to be rejected")
+	}
+}
+
+// TestPublishedReferences checks the loaded tables against values printed
+// on the WHO and CDC charts
+func TestPublishedReferences(t *testing.T) {
+	if err := loadGrowthData(); err != nil {
+		t.Skipf("growth data not installed: %v", err)
+	}
+	cases := []struct {
+		name       string
+		kind       MeasurementKind
+		gender     GenderType
+		ageYears   float64
+		value      float64
+		percentile float64
+		tolerance  float64
+	}{
+		{"WHO boys length at birth, median", HeightKind, Male, 0, 49.8842, 50, 0.1},
+		{"WHO boys length at birth, 3rd", HeightKind, Male, 0, 46.3, 3, 0.5},
+		{"WHO girls weight at birth, median", WeightKind, Female, 0, 3.2322, 50, 0.1},
+		{"WHO boys weight at 12 months, median", WeightKind, Male, 1, 9.6479, 50, 0.1},
+		{"CDC men's stature at 20, median", HeightKind, Male, 20, 176.8, 50, 5},
+		{"CDC women's stature at 20, median", HeightKind, Female, 20, 163.3, 50, 5},
+	}
+	for _, c := range cases {
+		z, percentile, found := growthPercentile(c.kind, c.gender, c.ageYears, c.value)
+		if !found {
+			t.Errorf("%s: no reference", c.name)
+			continue
+		}
+		if math.Abs(percentile-c.percentile) > c.tolerance {
+			t.Errorf("%s: expected the %gth percentile, got %.2f (z %.3f)", c.name, c.percentile, percentile, z)
+		}
+	}
+}
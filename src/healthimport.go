@@ -0,0 +1,383 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+)
+
+// Import of phone health data: an Apple Health export (export.zip or its
+// export.xml) or a Google Fit Takeout (the zip or one of its "All data" JSON
+// files). The files are read as streams, record by record, since an Apple
+// export runs to hundreds of megabytes. Neither app has a head circumference
+// type, so only weights and heights come out of them.
+
+// HealthRecord is one reading, converted to the kind's metric unit
+type HealthRecord struct {
+	Kind  MeasurementKind
+	Time  time.Time
+	Value float64
+}
+
+// Date is the day of the reading as the measurement store keeps dates
+func (record HealthRecord) Date() time.Time {
+	date, _ := time.Parse("2006-01-02", record.Time.Format("2006-01-02"))
+	return date
+}
+
+var massUnits = map[string]Unit{
+	"kg": UnitKilograms,
+	"lb": UnitPounds,
+	"g":  {Symbol: "g", Scale: 0.001},
+	"oz": {Symbol: "oz", Scale: 0.028349523125},
+	"st": {Symbol: "st", Scale: 6.35029318},
+}
+
+var lengthUnits = map[string]Unit{
+	"cm": UnitCentimeters,
+	"in": UnitInches,
+	"m":  {Symbol: "m", Scale: 100},
+	"mm": {Symbol: "mm", Scale: 0.1},
+	"ft": {Symbol: "ft", Scale: 30.48},
+}
+
+// appleHealthTypes maps Apple's quantity types to a kind and its units
+var appleHealthTypes = map[string]struct {
+	Kind  MeasurementKind
+	Units map[string]Unit
+}{
+	"HKQuantityTypeIdentifierBodyMass": {WeightKind, massUnits},
+	"HKQuantityTypeIdentifierHeight":   {HeightKind, lengthUnits},
+}
+
+// readAppleHealthXML streams the Record elements of an Apple Health
+// export.xml, passing on the weights and heights
+func readAppleHealthXML(r io.Reader, emit func(HealthRecord)) error {
+	decoder := xml.NewDecoder(bufio.NewReader(r))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading Apple Health export: %w", err)
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok || element.Name.Local != "Record" {
+			continue
+		}
+		attrs := make(map[string]string, len(element.Attr))
+		for _, attr := range element.Attr {
+			attrs[attr.Name.Local] = attr.Value
+		}
+		recordType, found := appleHealthTypes[attrs["type"]]
+		if !found {
+			continue
+		}
+		unit, found := recordType.Units[attrs["unit"]]
+		if !found {
+			continue
+		}
+		value, err := strconv.ParseFloat(attrs["value"], 64)
+		if err != nil {
+			continue
+		}
+		at, err := time.Parse("2006-01-02 15:04:05 -0700", attrs["startDate"])
+		if err != nil {
+			continue
+		}
+		emit(HealthRecord{Kind: recordType.Kind, Time: at, Value: unit.ToCanonical(value)})
+	}
+}
+
+// googleFitTypes maps Google Fit data types to a kind and the unit Fit
+// stores them in
+var googleFitTypes = map[string]struct {
+	Kind MeasurementKind
+	Unit Unit
+}{
+	"com.google.weight": {WeightKind, UnitKilograms},
+	"com.google.height": {HeightKind, lengthUnits["m"]},
+}
+
+type googleFitPoint struct {
+	DataTypeName   string `json:"dataTypeName"`
+	StartTimeNanos int64  `json:"startTimeNanos"`
+	FitValue       []struct {
+		Value struct {
+			FpVal *float64 `json:"fpVal"`
+		} `json:"value"`
+	} `json:"fitValue"`
+}
+
+// readGoogleFitJSON streams the "Data Points" array of a Takeout data file
+// one point at a time
+func readGoogleFitJSON(r io.Reader, emit func(HealthRecord)) error {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return fmt.Errorf("reading Google Fit data: not a JSON object")
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return fmt.Errorf("reading Google Fit data: %w", err)
+		}
+		if key != "Data Points" {
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return fmt.Errorf("reading Google Fit data: %w", err)
+			}
+			continue
+		}
+		if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+			return fmt.Errorf("reading Google Fit data: Data Points is not a list")
+		}
+		for decoder.More() {
+			var point googleFitPoint
+			if err := decoder.Decode(&point); err != nil {
+				return fmt.Errorf("reading Google Fit data: %w", err)
+			}
+			fitType, found := googleFitTypes[point.DataTypeName]
+			if !found || len(point.FitValue) == 0 || point.FitValue[0].Value.FpVal == nil {
+				continue
+			}
+			emit(HealthRecord{
+				Kind:  fitType.Kind,
+				Time:  time.Unix(0, point.StartTimeNanos).UTC(),
+				Value: fitType.Unit.ToCanonical(*point.FitValue[0].Value.FpVal),
+			})
+		}
+		if _, err := decoder.Token(); err != nil {
+			return fmt.Errorf("reading Google Fit data: %w", err)
+		}
+	}
+	return nil
+}
+
+// isGoogleFitFile picks the Takeout files worth reading: the weight and
+// height data sources
+func isGoogleFitFile(name string) bool {
+	base := path.Base(name)
+	return strings.HasSuffix(base, ".json") && (strings.Contains(base, "com.google.weight") || strings.Contains(base, "com.google.height"))
+}
+
+// readHealthExport works out what was uploaded from its first bytes and its
+// name, and reads every record in it. Zip files are read in place, which is
+// why the upload must be an io.ReaderAt.
+func readHealthExport(file io.ReaderAt, size int64, name string, emit func(HealthRecord)) error {
+	head := make([]byte, 512)
+	n, _ := file.ReadAt(head, 0)
+	head = bytes.TrimSpace(head[:n])
+	stream := io.NewSectionReader(file, 0, size)
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PK")):
+		archive, err := zip.NewReader(file, size)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, entry := range archive.File {
+			var read func(io.Reader, func(HealthRecord)) error
+			switch {
+			case path.Base(entry.Name) == "export.xml":
+				read = readAppleHealthXML
+			case strings.Contains(entry.Name, "Fit/") && isGoogleFitFile(entry.Name):
+				read = readGoogleFitJSON
+			default:
+				continue
+			}
+			found = true
+			content, err := entry.Open()
+			if err != nil {
+				return err
+			}
+			err = read(content, emit)
+			content.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", entry.Name, err)
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s has no Apple Health export.xml or Google Fit weight or height data", name)
+		}
+		return nil
+	case bytes.HasPrefix(head, []byte("<")):
+		return readAppleHealthXML(stream, emit)
+	case bytes.HasPrefix(head, []byte("{")):
+		return readGoogleFitJSON(stream, emit)
+	default:
+		return fmt.Errorf("%s is not an Apple Health or Google Fit export", name)
+	}
+}
+
+// HealthImportSummary counts what happened to the records of an import
+type HealthImportSummary struct {
+	Read       int
+	Days       int
+	Imported   int
+	Duplicates int
+	Invalid    int
+	First      time.Time
+	Last       time.Time
+}
+
+// dailyHealthRecords keeps the last reading of each kind per day, since a
+// phone can log a weight several times a day and the store keeps one
+type dailyHealthRecords map[string]HealthRecord
+
+func (daily dailyHealthRecords) Add(record HealthRecord) {
+	key := fmt.Sprintf("%d:%s", record.Kind, record.Time.Format("2006-01-02"))
+	if previous, found := daily[key]; !found || !record.Time.Before(previous.Time) {
+		daily[key] = record
+	}
+}
+
+// planHealthImport turns a person's daily readings into new measurements,
+// skipping days that already have one of the kind and readings outside the
+// person's life or the kind's range.
+func planHealthImport(daily dailyHealthRecords, person Person, existing map[MeasurementKind][]Measurement) (entries []Measurement, summary HealthImportSummary) {
+	seen := make(map[string]bool)
+	for kind, measurements := range existing {
+		for _, measurement := range measurements {
+			seen[fmt.Sprintf("%d:%s", kind, measurement.Date.Format("2006-01-02"))] = true
+		}
+	}
+	keys := make([]string, 0, len(daily))
+	for key := range daily {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	summary.Days = len(daily)
+	for _, key := range keys {
+		record := daily[key]
+		date := record.Date()
+		info := record.Kind.Info()
+		if date.Before(person.Birthday) || date.After(time.Now()) || info.Validate(record.Value, info.Metric) != nil {
+			summary.Invalid++
+			continue
+		}
+		if seen[key] {
+			summary.Duplicates++
+			continue
+		}
+		entries = append(entries, Measurement{
+			PersonId:  person.Id,
+			Kind:      record.Kind,
+			Value:     record.Value,
+			EntryUnit: info.Metric.Symbol,
+			Date:      date,
+		})
+		if summary.First.IsZero() || date.Before(summary.First) {
+			summary.First = date
+		}
+		if date.After(summary.Last) {
+			summary.Last = date
+		}
+	}
+	summary.Imported = len(entries)
+	return
+}
+
+func RegisterHealthImportPages(mux *http.ServeMux) {
+	mux.Handle("GET /family/health-import/{id}", OwnerHandler(ContextFunc(healthImportPage)))
+	mux.Handle("POST /family/health-import/{id}", AuthHandler(ContextFunc(importHealthExport)))
+}
+
+func healthImportPage(context ResponseContext) {
+	familyId, _ := strconv.Atoi(context.r.PathValue("id"))
+	context.familyId = familyId
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		RenderTemplateWithData(context, "health-import", map[string]any{
+			"Family": getFamily(tx, familyId),
+			"People": getPeopleInFamily(tx, familyId),
+		})
+	})
+}
+
+func importHealthExport(context ResponseContext) {
+	familyId, _ := strconv.Atoi(context.r.PathValue("id"))
+
+	// anything past the memory limit is spooled to a temporary file, so the
+	// export is never held in memory whole
+	if err := context.r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer context.r.MultipartForm.RemoveAll()
+	personId, _ := strconv.Atoi(context.r.FormValue("personId"))
+	file, header, err := context.r.FormFile("export")
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	var person Person
+	var isOwner bool
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person = getPerson(tx, personId)
+		isOwner = person.FamilyId == familyId && isFamilyOwner(tx, familyId, context.user.Id)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+
+	daily := make(dailyHealthRecords)
+	read := 0
+	err = readHealthExport(file, header.Size, header.Filename, func(record HealthRecord) {
+		read++
+		daily.Add(record)
+	})
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var summary HealthImportSummary
+	var family Family
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		family = getFamily(tx, familyId)
+		existing := make(map[MeasurementKind][]Measurement)
+		for _, kind := range []MeasurementKind{WeightKind, HeightKind} {
+			existing[kind] = queryMeasurementsTx(tx, person.Id, kind)
+		}
+		var entries []Measurement
+		entries, summary = planHealthImport(daily, person, existing)
+		heights := false
+		for i := range entries {
+			saveMeasurementTx(tx, &entries[i])
+			heights = heights || entries[i].Kind == HeightKind
+		}
+		if len(entries) > 0 {
+			refreshGrowthAlertsTx(tx, person.Id)
+		}
+		if heights {
+			recordHeightPredictionsTx(tx, familyId)
+		}
+		vbolt.TxCommit(tx)
+	})
+	summary.Read = read
+
+	context.familyId = familyId
+	RenderTemplateWithData(context, "health-import-result", map[string]any{
+		"Family":  family,
+		"Person":  person,
+		"Summary": summary,
+	})
+}
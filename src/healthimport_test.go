@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const appleHealthSample = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE HealthData [
+<!ELEMENT HealthData (ExportDate,Me,(Record|Workout)*)>
+]>
+<HealthData locale="en_US">
+ <ExportDate value="2024-05-01 09:00:00 -0700"/>
+ <Record type="HKQuantityTypeIdentifierBodyMass" sourceName="Phone" unit="lb" startDate="2024-04-01 07:30:00 -0700" endDate="2024-04-01 07:30:00 -0700" value="110"/>
+ <Record type="HKQuantityTypeIdentifierBodyMass" sourceName="Scale" unit="kg" startDate="2024-04-01 21:00:00 -0700" endDate="2024-04-01 21:00:00 -0700" value="50">
+  <MetadataEntry key="HKWasUserEntered" value="1"/>
+ </Record>
+ <Record type="HKQuantityTypeIdentifierHeight" sourceName="Phone" unit="ft" startDate="2024-04-02 08:00:00 -0700" endDate="2024-04-02 08:00:00 -0700" value="5.25"/>
+ <Record type="HKQuantityTypeIdentifierStepCount" sourceName="Phone" unit="count" startDate="2024-04-02 08:00:00 -0700" endDate="2024-04-02 09:00:00 -0700" value="1200"/>
+</HealthData>`
+
+const googleFitSample = `{
+  "Data Source": "derived:com.google.weight:com.google.android.gms:merge_weight",
+  "Data Points": [
+    {"fitValue": [{"value": {"fpVal": 49.5}}], "dataTypeName": "com.google.weight", "startTimeNanos": 1711954800000000000, "endTimeNanos": 1711954800000000000},
+    {"fitValue": [{"value": {"fpVal": 1.6}}], "dataTypeName": "com.google.height", "startTimeNanos": 1712041200000000000, "endTimeNanos": 1712041200000000000}
+  ]
+}`
+
+func readSample(t *testing.T, content []byte, name string) (records []HealthRecord) {
+	err := readHealthExport(bytes.NewReader(content), int64(len(content)), name, func(record HealthRecord) {
+		records = append(records, record)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestReadHealthExports(t *testing.T) {
+	apple := readSample(t, []byte(appleHealthSample), "export.xml")
+	if len(apple) != 3 {
+		t.Fatalf("expected 3 Apple records, got %d", len(apple))
+	}
+	if math.Abs(apple[0].Value-49.895) > 0.001 || apple[2].Kind != HeightKind || math.Abs(apple[2].Value-160.02) > 0.001 {
+		t.Errorf("unexpected Apple records: %+v", apple)
+	}
+
+	google := readSample(t, []byte(googleFitSample), "weight.json")
+	if len(google) != 2 || google[0].Value != 49.5 || google[1].Kind != HeightKind || google[1].Value != 160 {
+		t.Errorf("unexpected Google Fit records: %+v", google)
+	}
+
+	var zipped bytes.Buffer
+	archive := zip.NewWriter(&zipped)
+	file, _ := archive.Create("apple_health_export/export.xml")
+	file.Write([]byte(appleHealthSample))
+	file, _ = archive.Create("Takeout/Fit/All data/derived_com.google.weight_com.google.android.gms_merged.json")
+	file.Write([]byte(googleFitSample))
+	archive.Close()
+	if records := readSample(t, zipped.Bytes(), "export.zip"); len(records) != 5 {
+		t.Errorf("expected 5 records from the zip, got %d", len(records))
+	}
+
+	err := readHealthExport(strings.NewReader("name,weight"), 11, "data.csv", func(HealthRecord) {})
+	if err == nil {
+		t.Error("expected an error for an unknown file")
+	}
+}
+
+func TestPlanHealthImport(t *testing.T) {
+	person := Person{Id: 4, Name: "Noah", Birthday: time.Date(2010, 1, 5, 0, 0, 0, 0, time.UTC)}
+	daily := make(dailyHealthRecords)
+	for _, record := range readSample(t, []byte(appleHealthSample), "export.xml") {
+		daily.Add(record)
+	}
+	daily.Add(HealthRecord{Kind: WeightKind, Time: time.Date(2009, 6, 1, 8, 0, 0, 0, time.UTC), Value: 3})
+	daily.Add(HealthRecord{Kind: HeightKind, Time: time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC), Value: 150})
+
+	existing := map[MeasurementKind][]Measurement{
+		HeightKind: {{PersonId: 4, Kind: HeightKind, Value: 150, Date: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}},
+	}
+	entries, summary := planHealthImport(daily, person, existing)
+	if summary.Days != 4 || summary.Imported != 2 || summary.Duplicates != 1 || summary.Invalid != 1 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	// the evening weight replaces the morning one
+	for _, entry := range entries {
+		if entry.Kind == WeightKind && entry.Value != 50 {
+			t.Errorf("expected the last weight of the day, got %v", entry.Value)
+		}
+	}
+}
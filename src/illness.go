@@ -0,0 +1,541 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+// IllnessEpisode is one time a child was sick; End is zero while they still
+// are. Temperatures are ordinary temperature measurements, and belong to the
+// episode whose time they fall in.
+type IllnessEpisode struct {
+	Id       int
+	PersonId int
+	Start    time.Time
+	End      time.Time
+	Symptoms []string
+	Notes    string
+
+	Temperatures []Measurement
+}
+
+func PackIllnessEpisode(self *IllnessEpisode, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.Time(&self.Start, buf)
+	vpack.Time(&self.End, buf)
+	vpack.Slice(&self.Symptoms, vpack.String, buf)
+	vpack.String(&self.Notes, buf)
+}
+
+var IllnessEpisodeBucket = vbolt.Bucket(&Info, "illness_episodes", vpack.FInt, PackIllnessEpisode)
+
+// IllnessEpisodeIndex term: person id, priority: start, target: episode id
+var IllnessEpisodeIndex = vbolt.IndexExt(&Info, "illness_episodes_by", vpack.FInt, vpack.UnixTimeKey, vpack.FInt)
+
+// feverCelsius is the temperature counted as a fever
+const feverCelsius = 38.0
+
+// commonSymptoms are offered as checkboxes; anything else is typed in
+var commonSymptoms = []string{"Fever", "Cough", "Runny nose", "Sore throat", "Ear pain", "Vomiting", "Diarrhea", "Rash", "Tiredness", "Poor appetite"}
+
+func (episode IllnessEpisode) Ongoing() bool {
+	return episode.End.IsZero()
+}
+
+// until is when the episode ended, or now while it goes on
+func (episode IllnessEpisode) until(now time.Time) time.Time {
+	if episode.Ongoing() {
+		return now
+	}
+	return episode.End
+}
+
+func (episode IllnessEpisode) contains(at time.Time, now time.Time) bool {
+	return !at.Before(episode.Start) && !at.After(episode.until(now))
+}
+
+// Duration is how long the episode lasted, or has so far
+func (episode IllnessEpisode) Duration() string {
+	return formatDuration(episode.until(time.Now()).Sub(episode.Start))
+}
+
+func (episode IllnessEpisode) HasSymptom(symptom string) bool {
+	return slices.Contains(episode.Symptoms, symptom)
+}
+
+// OtherSymptoms are the symptoms that were typed in rather than ticked
+func (episode IllnessEpisode) OtherSymptoms() string {
+	var others []string
+	for _, symptom := range episode.Symptoms {
+		if !slices.Contains(commonSymptoms, symptom) {
+			others = append(others, symptom)
+		}
+	}
+	return strings.Join(others, ", ")
+}
+
+// formatDuration rounds down to whole hours, e.g. "2 days 5 hours"
+func formatDuration(duration time.Duration) string {
+	hours := int(duration.Hours())
+	days, hours := hours/24, hours%24
+	count := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case days == 0:
+		return count(hours, "hour")
+	case hours == 0:
+		return count(days, "day")
+	default:
+		return count(days, "day") + " " + count(hours, "hour")
+	}
+}
+
+func (measurement Measurement) IsFever() bool {
+	return measurement.Kind == TemperatureKind && measurement.Value >= feverCelsius
+}
+
+// TemperatureSummary is what a doctor asks about a fever
+type TemperatureSummary struct {
+	Readings      int
+	Highest       Measurement
+	FeverReadings int
+	FirstFever    time.Time
+	LastFever     time.Time
+}
+
+// FeverSpan is the time from the first reading with a fever to the last
+func (summary TemperatureSummary) FeverSpan() string {
+	return formatDuration(summary.LastFever.Sub(summary.FirstFever))
+}
+
+// summarizeTemperatures takes readings oldest first
+func summarizeTemperatures(readings []Measurement) (summary TemperatureSummary) {
+	summary.Readings = len(readings)
+	for _, reading := range readings {
+		if summary.Highest.Id == 0 || reading.Value > summary.Highest.Value {
+			summary.Highest = reading
+		}
+		if reading.IsFever() {
+			if summary.FeverReadings == 0 {
+				summary.FirstFever = reading.Date
+			}
+			summary.LastFever = reading.Date
+			summary.FeverReadings++
+		}
+	}
+	return
+}
+
+func (episode IllnessEpisode) Summary() TemperatureSummary {
+	return summarizeTemperatures(episode.Temperatures)
+}
+
+func saveIllnessEpisodeTx(tx *vbolt.Tx, episode *IllnessEpisode) {
+	if episode.Id == 0 {
+		episode.Id = vbolt.NextIntId(tx, IllnessEpisodeBucket)
+	}
+	vbolt.Write(tx, IllnessEpisodeBucket, episode.Id, episode)
+	vbolt.SetTargetSingleTermExt(tx, IllnessEpisodeIndex, episode.Id, episode.Start, episode.PersonId)
+}
+
+func deleteIllnessEpisodeTx(tx *vbolt.Tx, episodeId int) {
+	vbolt.Delete(tx, IllnessEpisodeBucket, episodeId)
+	vbolt.SetTargetTermsPlain(tx, IllnessEpisodeIndex, episodeId, nil)
+}
+
+func getIllnessEpisodeTx(tx *vbolt.Tx, id int) (episode IllnessEpisode) {
+	vbolt.Read(tx, IllnessEpisodeBucket, id, &episode)
+	return
+}
+
+// getPersonIllnessEpisodesTx returns a person's episodes, newest first
+func getPersonIllnessEpisodesTx(tx *vbolt.Tx, personId int) (episodes []IllnessEpisode) {
+	var ids []int
+	vbolt.ReadTermTargets(tx, IllnessEpisodeIndex, personId, &ids, vbolt.Window{})
+	vbolt.ReadSlice(tx, IllnessEpisodeBucket, ids, &episodes)
+	sort.SliceStable(episodes, func(i, j int) bool { return episodes[i].Start.After(episodes[j].Start) })
+	return
+}
+
+// episodeTemperatures keeps the readings taken during an episode, oldest first
+func episodeTemperatures(temperatures []Measurement, episode IllnessEpisode, now time.Time) (readings []Measurement) {
+	for _, temperature := range temperatures {
+		if temperature.Kind == TemperatureKind && episode.contains(temperature.Date, now) {
+			readings = append(readings, temperature)
+		}
+	}
+	sort.SliceStable(readings, func(i, j int) bool { return readings[i].Date.Before(readings[j].Date) })
+	return
+}
+
+// prepIllnessEpisodesTx loads the temperatures for one person's episodes and
+// puts every time on the viewer's clock
+func prepIllnessEpisodesTx(tx *vbolt.Tx, episodes []IllnessEpisode, system UnitSystem, loc *time.Location) {
+	if len(episodes) == 0 {
+		return
+	}
+	temperatures := queryMeasurementsTx(tx, episodes[0].PersonId, TemperatureKind)
+	setDisplayUnits(temperatures, system)
+	localMeasurementTimes(temperatures, loc)
+	now := time.Now()
+	for i := range episodes {
+		episodes[i].Temperatures = episodeTemperatures(temperatures, episodes[i], now)
+		episodes[i].Start = episodes[i].Start.In(loc)
+		if !episodes[i].Ongoing() {
+			episodes[i].End = episodes[i].End.In(loc)
+		}
+	}
+}
+
+// trashIllnessEpisodeTx moves an episode to the trash. Its temperatures stay,
+// as they are measurements in their own right.
+func trashIllnessEpisodeTx(tx *vbolt.Tx, episode IllnessEpisode, familyId int, userId int) {
+	deleteIllnessEpisodeTx(tx, episode.Id)
+	episode.Temperatures = nil
+	saveTrashEntry(tx, &TrashEntry{
+		FamilyId:        familyId,
+		Kind:            TrashIllnessEpisode,
+		Label:           "Illness, " + episode.Start.Format("Jan 2, 2006"),
+		DeletedBy:       userId,
+		IllnessEpisodes: []IllnessEpisode{episode},
+	})
+}
+
+// feverLabel is the fever threshold in the unit being shown
+func feverLabel(unit Unit) string {
+	return TemperatureKind.Info().Format(unit.FromCanonical(feverCelsius)) + " " + unit.Symbol
+}
+
+func RegisterIllnessPages(mux *http.ServeMux) {
+	mux.Handle("GET /illnesses/{id}", AuthHandler(ContextFunc(illnessesPage)))
+	mux.Handle("GET /illnesses/add/{id}", AuthHandler(ContextFunc(addIllnessPage)))
+	mux.Handle("GET /illnesses/edit/{id}", AuthHandler(ContextFunc(editIllnessPage)))
+	mux.Handle("POST /illnesses/save", AuthHandler(ContextFunc(saveIllness)))
+	mux.Handle("GET /illnesses/view/{id}", AuthHandler(ContextFunc(illnessPage)))
+	mux.Handle("POST /illnesses/temperature/{id}", AuthHandler(ContextFunc(saveIllnessTemperature)))
+	mux.Handle("GET /illnesses/summary/{id}", AuthHandler(ContextFunc(illnessSummaryPage)))
+	mux.Handle("POST /illnesses/delete/{id}", AuthHandler(ContextFunc(deleteIllness)))
+	mux.Handle("GET /api/illnesses/{id}", AuthHandler(ContextFunc(illnessApi)))
+}
+
+// illnessesPage lists a person's illnesses to the family
+func illnessesPage(context ResponseContext) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person := getPerson(tx, personId)
+		if person.Id == 0 || !isFamilyMemberTx(tx, context, person.FamilyId) {
+			http.Error(context.w, "not a family member", http.StatusUnauthorized)
+			return
+		}
+		context.familyId = person.FamilyId
+		system := preferredUnits(tx, context.user)
+		episodes := getPersonIllnessEpisodesTx(tx, person.Id)
+		prepIllnessEpisodesTx(tx, episodes, system, requestLocation(context.r))
+		RenderTemplateWithData(context, "illnesses", map[string]any{
+			"Person":   person,
+			"Episodes": episodes,
+		})
+	})
+}
+
+func renderIllnessForm(context ResponseContext, person Person, episode IllnessEpisode, form *Form) {
+	context.familyId = person.FamilyId
+	var errors map[string]string
+	if form != nil {
+		errors = form.Errors
+	}
+	RenderTemplateWithData(context, "illness-add", map[string]any{
+		"Person":   person,
+		"Episode":  episode,
+		"Symptoms": commonSymptoms,
+		"Errors":   errors,
+	})
+}
+
+func addIllnessPage(context ResponseContext) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person := getPerson(tx, personId)
+		if person.Id == 0 || !isFamilyOwner(tx, person.FamilyId, context.user.Id) {
+			http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+			return
+		}
+		start := time.Now().In(requestLocation(context.r))
+		renderIllnessForm(context, person, IllnessEpisode{PersonId: person.Id, Start: start}, nil)
+	})
+}
+
+func editIllnessPage(context ResponseContext) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	loc := requestLocation(context.r)
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		episode := getIllnessEpisodeTx(tx, id)
+		person := getPerson(tx, episode.PersonId)
+		if episode.Id == 0 || !isFamilyOwner(tx, person.FamilyId, context.user.Id) {
+			http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+			return
+		}
+		episode.Start = episode.Start.In(loc)
+		if !episode.Ongoing() {
+			episode.End = episode.End.In(loc)
+		}
+		renderIllnessForm(context, person, episode, nil)
+	})
+}
+
+func saveIllness(context ResponseContext) {
+	form := bindForm(context.r)
+	loc := requestLocation(context.r)
+	episode := IllnessEpisode{
+		Id:       form.Int("id", "Id", false),
+		PersonId: form.Int("personId", "Person", true),
+		Start:    form.DateTime("start", "Started", true, loc),
+		End:      form.DateTime("end", "Ended", false, loc),
+		Symptoms: form.List("symptom"),
+		Notes:    form.String("notes", "Notes", false),
+	}
+	for _, symptom := range strings.Split(form.Value("otherSymptoms"), ",") {
+		if symptom = strings.TrimSpace(symptom); symptom != "" && !episode.HasSymptom(symptom) {
+			episode.Symptoms = append(episode.Symptoms, symptom)
+		}
+	}
+	if !episode.Ongoing() && episode.End.Before(episode.Start) {
+		form.Fail("end", "Ended can't be before it started")
+	}
+
+	var person Person
+	var isOwner bool
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		person = getPerson(tx, episode.PersonId)
+		isOwner = person.Id > 0 && isFamilyOwner(tx, person.FamilyId, context.user.Id)
+		if episode.Id > 0 {
+			isOwner = isOwner && getIllnessEpisodeTx(tx, episode.Id).PersonId == person.Id
+		}
+		if !isOwner {
+			return
+		}
+		form.DuringLife("start", "Started", episode.Start, person)
+		form.DuringLife("end", "Ended", episode.End, person)
+		if !form.Valid() {
+			return
+		}
+		saveIllnessEpisodeTx(tx, &episode)
+		vbolt.TxCommit(tx)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+	if !form.Valid() {
+		renderIllnessForm(context, person, episode, form)
+		return
+	}
+
+	http.Redirect(context.w, context.r, fmt.Sprintf("/illnesses/view/%d", episode.Id), http.StatusFound)
+}
+
+// renderIllness shows an episode with its temperature chart; reading is the
+// entry in the add-a-reading form, shown again in unit when it was rejected
+func renderIllness(context ResponseContext, reading Measurement, unit Unit, form *Form) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	loc := requestLocation(context.r)
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		episode := getIllnessEpisodeTx(tx, id)
+		person := getPerson(tx, episode.PersonId)
+		if episode.Id == 0 || !isFamilyMemberTx(tx, context, person.FamilyId) {
+			http.Error(context.w, "not a family member", http.StatusUnauthorized)
+			return
+		}
+		context.familyId = person.FamilyId
+		system := preferredUnits(tx, context.user)
+		episodes := []IllnessEpisode{episode}
+		prepIllnessEpisodesTx(tx, episodes, system, loc)
+
+		if form == nil {
+			unit = TemperatureKind.Info().Unit(system)
+			reading.Date = episodes[0].until(time.Now())
+		}
+		reading.Date = reading.Date.In(loc)
+		var errors map[string]string
+		if form != nil {
+			errors = form.Errors
+		}
+		RenderTemplateWithData(context, "illness", map[string]any{
+			"Person":  person,
+			"Episode": episodes[0],
+			"Kind":    TemperatureKind.Info(),
+			"Unit":    TemperatureKind.Info().Unit(system),
+			"Fever":   feverLabel(TemperatureKind.Info().Unit(system)),
+			"Reading": reading,
+			"Entry":   unit,
+			"Errors":  errors,
+		})
+	})
+}
+
+func illnessPage(context ResponseContext) {
+	renderIllness(context, Measurement{}, Unit{}, nil)
+}
+
+// saveIllnessTemperature records a temperature taken during an episode
+func saveIllnessTemperature(context ResponseContext) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	form := bindForm(context.r)
+	info := TemperatureKind.Info()
+	reading := Measurement{
+		Kind: TemperatureKind,
+		Date: form.DateTime("time", "Time taken", true, requestLocation(context.r)),
+	}
+	entered := form.Float("value", info.Label, true)
+	unit, found := info.findUnit(form.Value("unit"))
+	if !found {
+		form.Fail("unit", "unknown unit for %s", info.Label)
+		unit = info.Metric
+	}
+	reading.Value = unit.ToCanonical(entered)
+	reading.EntryUnit = unit.Symbol
+	reading.DisplayValue = entered
+	if form.Errors["value"] == "" {
+		if err := info.Validate(reading.Value, unit); err != nil {
+			form.Fail("value", "%s", err.Error())
+		}
+	}
+
+	var isOwner bool
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		episode := getIllnessEpisodeTx(tx, id)
+		person := getPerson(tx, episode.PersonId)
+		isOwner = episode.Id > 0 && isFamilyOwner(tx, person.FamilyId, context.user.Id)
+		if !isOwner {
+			return
+		}
+		reading.PersonId = person.Id
+		form.DuringLife("time", "Time taken", reading.Date, person)
+		if form.Errors["time"] == "" && !episode.contains(reading.Date, time.Now()) {
+			form.Fail("time", "Time taken has to be during the illness; change its dates to add an earlier or later reading")
+		}
+		if !form.Valid() {
+			return
+		}
+		saveMeasurementTx(tx, &reading)
+		vbolt.TxCommit(tx)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+	if !form.Valid() {
+		renderIllness(context, reading, unit, form)
+		return
+	}
+
+	http.Redirect(context.w, context.r, fmt.Sprintf("/illnesses/view/%d#readings", id), http.StatusFound)
+}
+
+// illnessSummaryPage is a printable account of an episode to take to the
+// doctor, with the medicine given while it lasted
+func illnessSummaryPage(context ResponseContext) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	loc := requestLocation(context.r)
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		episode := getIllnessEpisodeTx(tx, id)
+		person := getPerson(tx, episode.PersonId)
+		if episode.Id == 0 || !isFamilyMemberTx(tx, context, person.FamilyId) {
+			http.Error(context.w, "not a family member", http.StatusUnauthorized)
+			return
+		}
+		context.familyId = person.FamilyId
+		system := preferredUnits(tx, context.user)
+		now := time.Now()
+
+		var doses []MedicationDose
+		for _, dose := range getPersonMedicationDosesTx(tx, person.Id) {
+			if episode.contains(dose.Time, now) {
+				dose.Time = dose.Time.In(loc)
+				doses = append(doses, dose)
+			}
+		}
+		slices.Reverse(doses)
+		episodes := []IllnessEpisode{episode}
+		prepIllnessEpisodesTx(tx, episodes, system, loc)
+
+		RenderTemplateWithData(context, "illness-summary", map[string]any{
+			"Person":  person,
+			"Age":     person.AgeAt(episode.Start),
+			"Episode": episodes[0],
+			"Summary": episodes[0].Summary(),
+			"Unit":    TemperatureKind.Info().Unit(system),
+			"Fever":   feverLabel(TemperatureKind.Info().Unit(system)),
+			"Doses":   doses,
+			"Today":   now.In(loc),
+		})
+	})
+}
+
+// illnessApi is an episode's temperatures in the shape the measurement
+// charts read
+func illnessApi(context ResponseContext) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	var series MeasurementSeries
+	var isMember bool
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		episode := getIllnessEpisodeTx(tx, id)
+		person := getPerson(tx, episode.PersonId)
+		isMember = episode.Id > 0 && isFamilyMemberTx(tx, context, person.FamilyId)
+		if !isMember {
+			return
+		}
+		system := preferredUnits(tx, context.user)
+		episodes := []IllnessEpisode{episode}
+		prepIllnessEpisodesTx(tx, episodes, system, time.UTC)
+		series = MeasurementSeries{
+			PersonName:   person.Name,
+			Unit:         TemperatureKind.Info().Unit(system).Symbol,
+			Measurements: episodes[0].Temperatures,
+		}
+	})
+	if !isMember {
+		http.Error(context.w, "not a family member", http.StatusUnauthorized)
+		return
+	}
+	context.w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(context.w).Encode(series)
+}
+
+func deleteIllness(context ResponseContext) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	var episode IllnessEpisode
+	var isOwner bool
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		episode = getIllnessEpisodeTx(tx, id)
+		person := getPerson(tx, episode.PersonId)
+		isOwner = episode.Id > 0 && isFamilyOwner(tx, person.FamilyId, context.user.Id)
+		if !isOwner {
+			return
+		}
+		trashIllnessEpisodeTx(tx, episode, person.FamilyId, context.user.Id)
+		vbolt.TxCommit(tx)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+
+	http.Redirect(context.w, context.r, fmt.Sprintf("/illnesses/%d", episode.PersonId), http.StatusFound)
+}
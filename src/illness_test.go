@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestEpisodeTemperatures(t *testing.T) {
+	start := time.Date(2026, 3, 1, 18, 30, 0, 0, time.UTC)
+	now := start.Add(50 * time.Hour)
+	temperatures := []Measurement{
+		{Id: 1, Kind: TemperatureKind, Value: 37.2, Date: start.Add(-time.Hour)},
+		{Id: 2, Kind: TemperatureKind, Value: 38.6, Date: start.Add(20 * time.Hour)},
+		{Id: 3, Kind: TemperatureKind, Value: 39.4, Date: start.Add(2 * time.Hour)},
+		{Id: 4, Kind: TemperatureKind, Value: 37.9, Date: start.Add(30 * time.Hour)},
+		{Id: 5, Kind: TemperatureKind, Value: 38.1, Date: start.Add(40 * time.Hour)},
+	}
+
+	ongoing := IllnessEpisode{Start: start}
+	readings := episodeTemperatures(temperatures, ongoing, now)
+	if len(readings) != 4 || readings[0].Id != 3 || readings[3].Id != 5 {
+		t.Fatalf("expected readings 3, 2, 4, 5 in time order, got %+v", readings)
+	}
+	summary := summarizeTemperatures(readings)
+	if summary.Readings != 4 || summary.Highest.Id != 3 || summary.FeverReadings != 3 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	if !summary.FirstFever.Equal(start.Add(2*time.Hour)) || summary.FeverSpan() != "1 day 14 hours" {
+		t.Errorf("unexpected fever span from %v: %s", summary.FirstFever, summary.FeverSpan())
+	}
+
+	ended := IllnessEpisode{Start: start, End: start.Add(24 * time.Hour)}
+	if readings := episodeTemperatures(temperatures, ended, now); len(readings) != 2 {
+		t.Errorf("expected the two readings in the first day, got %+v", readings)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	cases := map[time.Duration]string{
+		90 * time.Minute: "1 hour",
+		5 * time.Hour:    "5 hours",
+		48 * time.Hour:   "2 days",
+		29 * time.Hour:   "1 day 5 hours",
+	}
+	for duration, want := range cases {
+		if got := formatDuration(duration); got != want {
+			t.Errorf("formatDuration(%v) = %q, want %q", duration, got, want)
+		}
+	}
+}
+
+func TestFormList(t *testing.T) {
+	form := &Form{values: url.Values{"symptom": {"Cough", " ", " Rash "}}, Errors: map[string]string{}}
+	if got := form.List("symptom"); len(got) != 2 || got[0] != "Cough" || got[1] != "Rash" {
+		t.Errorf("unexpected symptoms: %q", got)
+	}
+}
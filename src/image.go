@@ -4,10 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"go.hasen.dev/vbolt"
@@ -79,40 +82,16 @@ func SaveImageFile(context ResponseContext, fileParameter string, access AccessL
 	}
 	defer file.Close()
 
-	filename := fmt.Sprintf("%d-%s", time.Now().Unix(), handler.Filename)
-
-	if err = os.MkdirAll("uploads", os.ModePerm); err != nil {
-		return image, err
-	}
-
-	dst, err := os.Create(buildPath(filename))
+	filename, err := saveUploadFile(file, handler.Filename)
 	if err != nil {
 		return image, err
 	}
-	defer dst.Close()
-	if _, err := io.Copy(dst, file); err != nil {
-		return image, err
-	}
 
-	origImage, err := imaging.Open(buildPath(filename))
+	smallFilename, err := saveThumbnail(filename)
 	if err != nil {
 		return image, err
 	}
 
-	resizedImage := imaging.Thumbnail(origImage, 150, 150, imaging.Lanczos)
-
-	smallFilename := fmt.Sprintf("small-%s", filename)
-	smallFile, err := os.Create(buildPath(smallFilename))
-	if err != nil {
-		return image, err
-	}
-	defer smallFile.Close()
-
-	opts := jpeg.Options{Quality: 80}
-	if err := jpeg.Encode(smallFile, resizedImage, &opts); err != nil {
-		return image, err
-	}
-
 	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
 		image = Image{
 			Id:             vbolt.NextIntId(tx, ImageBucket),
@@ -130,25 +109,136 @@ func SaveImageFile(context ResponseContext, fileParameter string, access AccessL
 	return image, nil
 }
 
-func DeleteImageFile(imageId int, readTx *vbolt.Tx) (err error) {
-	var image Image
-	vbolt.Read(readTx, ImageBucket, imageId, &image)
+// saveThumbnail writes the small version of a stored picture shown in lists,
+// failing when the file is not a picture
+func saveThumbnail(filename string) (smallFilename string, err error) {
+	origImage, err := imaging.Open(buildPath(filename))
+	if err != nil {
+		return "", err
+	}
 
-	if err := os.Remove(buildPath(image.Filename)); err != nil {
-		return err
+	resizedImage := imaging.Thumbnail(origImage, 150, 150, imaging.Lanczos)
+
+	smallFilename = fmt.Sprintf("small-%s", filename)
+	smallFile, err := os.Create(buildPath(smallFilename))
+	if err != nil {
+		return "", err
+	}
+	defer smallFile.Close()
+
+	opts := jpeg.Options{Quality: 80}
+	if err := jpeg.Encode(smallFile, resizedImage, &opts); err != nil {
+		return "", err
+	}
+	return smallFilename, nil
+}
+
+// saveUploadFile copies an upload into uploads/ under a unique name
+func saveUploadFile(file io.Reader, name string) (filename string, err error) {
+	filename = fmt.Sprintf("%d-%s", time.Now().UnixNano(), filepath.Base(name))
+	if err = os.MkdirAll("uploads", os.ModePerm); err != nil {
+		return "", err
 	}
-	if len(image.Small_Filename) > 0 {
-		if err := os.Remove(buildPath(image.Small_Filename)); err != nil {
-			return err
+	dst, err := os.Create(buildPath(filename))
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, file)
+	return filename, err
+}
+
+// attachmentTypes are the documents that can be attached to a record, by
+// the extension they are stored under; uploads are only ever served as one
+// of these types
+var attachmentTypes = map[string]string{
+	"application/pdf": ".pdf",
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+}
+
+// sniffContentType reads the type from the start of the file's content,
+// whatever its name says, and rewinds it
+func sniffContentType(file io.ReadSeeker) (string, error) {
+	sniff := make([]byte, 512)
+	n, _ := io.ReadFull(file, sniff)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(sniff[:n]), nil
+}
+
+// saveAttachmentFiles stores every file uploaded under fileParameter as a
+// document only members of the family can open. The records come back
+// without ids, for the caller to save along with what they are attached to;
+// on an error the files already stored are removed.
+func saveAttachmentFiles(context ResponseContext, fileParameter string, familyId int) (attachments []Image, err error) {
+	if context.r.MultipartForm == nil {
+		return nil, nil
+	}
+	for _, header := range context.r.MultipartForm.File[fileParameter] {
+		var attachment Image
+		attachment, err = saveAttachmentFile(header, context.user.Id, familyId)
+		if err != nil {
+			for _, saved := range attachments {
+				removeFiles(imageFiles(saved))
+			}
+			return nil, err
 		}
+		attachments = append(attachments, attachment)
 	}
+	return
+}
 
-	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
-		vbolt.Delete(tx, ImageBucket, imageId)
-		tx.Commit()
-	})
+func saveAttachmentFile(header *multipart.FileHeader, ownerId int, familyId int) (attachment Image, err error) {
+	file, err := header.Open()
+	if err != nil {
+		return attachment, err
+	}
+	defer file.Close()
 
-	return nil
+	contentType, err := sniffContentType(file)
+	if err != nil {
+		return attachment, err
+	}
+	extension, allowed := attachmentTypes[contentType]
+	if !allowed {
+		return attachment, fmt.Errorf("%s is not a PDF or picture", header.Filename)
+	}
+
+	// stored under the extension of what it really is, never the one it came with
+	name := filepath.Base(header.Filename)
+	filename, err := saveUploadFile(file, strings.TrimSuffix(name, filepath.Ext(name))+extension)
+	if err != nil {
+		return attachment, err
+	}
+	return Image{OwnerId: ownerId, FamilyId: familyId, Filename: filename, Access: FamilyLevel}, nil
+}
+
+// Name is the file name as uploaded, without the prefix that keeps it unique
+func (image Image) Name() string {
+	if _, name, found := strings.Cut(image.Filename, "-"); found {
+		return name
+	}
+	return image.Filename
+}
+
+func imageFiles(image Image) (files []string) {
+	if image.Filename != "" {
+		files = append(files, buildPath(image.Filename))
+	}
+	if image.Small_Filename != "" {
+		files = append(files, buildPath(image.Small_Filename))
+	}
+	return
+}
+
+func removeFiles(files []string) {
+	for _, file := range files {
+		os.Remove(file)
+	}
 }
 
 func uploadImage(context ResponseContext) {
@@ -173,14 +263,11 @@ func uploadPersonImage(context ResponseContext) {
 	id := context.r.PathValue("id")
 	idVal, _ := strconv.Atoi(id)
 
-	var person Person
-	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
-		person = getPerson(tx, idVal)
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		person := getPerson(tx, idVal)
 		if person.ImageId > 0 {
-			DeleteImageFile(person.ImageId, tx)
+			trashImageTx(tx, person.ImageId, person.Id, 0, context.user.Id)
 		}
-	})
-	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
 		person.ImageId = image.Id
 		vbolt.Write(tx, PersonBucket, person.Id, &person)
 		tx.Commit()
@@ -199,14 +286,11 @@ func uploadFamilyImage(context ResponseContext) {
 	id := context.r.PathValue("id")
 	idVal, _ := strconv.Atoi(id)
 
-	var family Family
-	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
-		family = getFamily(tx, idVal)
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		family := getFamily(tx, idVal)
 		if family.ImageId > 0 {
-			DeleteImageFile(family.ImageId, tx)
+			trashImageTx(tx, family.ImageId, 0, family.Id, context.user.Id)
 		}
-	})
-	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
 		family.ImageId = image.Id
 		vbolt.Write(tx, FamilyBucket, idVal, &family)
 		tx.Commit()
@@ -256,34 +340,58 @@ func serveImage(context ResponseContext) {
 		} else {
 			filePath = buildPath(image.Filename)
 		}
+		if image.Access == FamilyLevel && !isFamilyMemberTx(tx, context, image.FamilyId) {
+			filePath = ""
+		}
 	})
 
-	if image.Access == OwnerLevel && image.OwnerId != context.user.Id {
+	if image.Id == 0 {
 		filePath = ""
 	}
-	if image.Access == FamilyLevel && image.FamilyId != context.user.PrimaryFamilyId {
+	if image.Access == OwnerLevel && image.OwnerId != context.user.Id {
 		filePath = ""
 	}
 
 	if filePath == "" {
 		http.Error(context.w, "cannot show image", http.StatusBadRequest)
-	} else {
-		http.ServeFile(context.w, context.r, filePath)
+		return
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		http.Error(context.w, "image not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// the type comes from the content, so nothing uploaded is served as a page
+	contentType, err := sniffContentType(file)
+	if _, allowed := attachmentTypes[contentType]; err != nil || !allowed {
+		contentType = "application/octet-stream"
 	}
+	header := context.w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("X-Content-Type-Options", "nosniff")
+	// pictures are shown by their thumbnail; full files are attached documents
+	if image.Small_Filename == "" {
+		header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": image.Name()}))
+	}
+	http.ServeContent(context.w, context.r, image.Name(), info.ModTime(), file)
 }
 
 func deletePersonImage(context ResponseContext) {
 	id := context.r.PathValue("id")
 	idVal, _ := strconv.Atoi(id)
 
-	var person Person
-	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
-		person = getPerson(tx, idVal)
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		person := getPerson(tx, idVal)
 		if person.ImageId > 0 {
-			DeleteImageFile(person.ImageId, tx)
+			trashImageTx(tx, person.ImageId, person.Id, 0, context.user.Id)
 		}
-	})
-	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
 		person.ImageId = 0
 		vbolt.Write(tx, PersonBucket, person.Id, &person)
 		tx.Commit()
@@ -296,14 +404,11 @@ func deleteFamilyImage(context ResponseContext) {
 	id := context.r.PathValue("id")
 	idVal, _ := strconv.Atoi(id)
 
-	var family Family
-	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
-		family = getFamily(tx, idVal)
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		family := getFamily(tx, idVal)
 		if family.ImageId > 0 {
-			DeleteImageFile(family.ImageId, tx)
+			trashImageTx(tx, family.ImageId, 0, family.Id, context.user.Id)
 		}
-	})
-	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
 		family.ImageId = 0
 		vbolt.Write(tx, FamilyBucket, family.Id, &family)
 		tx.Commit()
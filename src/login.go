@@ -44,10 +44,13 @@ type User struct {
 	FirstName       string
 	LastName        string
 	PrimaryFamilyId int
+	Units           UnitSystem
+	Creation        time.Time
 }
 
+// The layout is shared with backend/users.go; keep the two in step.
 func PackUser(self *User, buf *vpack.Buffer) {
-	vpack.Version(1, buf)
+	version := vpack.Version(3, buf)
 	vpack.Int(&self.Id, buf)
 	vpack.String(&self.Email, buf)
 	vpack.IntEnum(&self.Status, buf)
@@ -55,6 +58,73 @@ func PackUser(self *User, buf *vpack.Buffer) {
 	vpack.String(&self.FirstName, buf)
 	vpack.String(&self.LastName, buf)
 	vpack.Int(&self.PrimaryFamilyId, buf)
+	if version >= 2 {
+		vpack.IntEnum(&self.Units, buf)
+	}
+	if version >= 3 {
+		vpack.Time(&self.Creation, buf)
+	}
+}
+
+// Users written by backend/ before the layouts were unified: version 1 there
+// had Creation where Status is here, and the hashes lived in "passwd". These
+// are never initialized; migrateBackendUsers reads them if they exist.
+
+type backendUserV1 struct {
+	Id        int
+	Email     string
+	Creation  time.Time
+	LastLogin time.Time
+	FirstName string
+	LastName  string
+}
+
+func packBackendUserV1(self *backendUserV1, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.String(&self.Email, buf)
+	vpack.Time(&self.Creation, buf)
+	vpack.Time(&self.LastLogin, buf)
+	vpack.String(&self.FirstName, buf)
+	vpack.String(&self.LastName, buf)
+}
+
+var backendInfo vbolt.Info
+
+var backendUsersBucket = vbolt.Bucket(&backendInfo, "users", vpack.FInt, packBackendUserV1)
+var backendPasswdBucket = vbolt.Bucket(&backendInfo, "passwd", vpack.FInt, vpack.ByteSlice)
+
+// migrateBackendUsers converts users that have a hash in the old "passwd"
+// bucket from the backend layout, moves their hashes to PasswordBucket and
+// then rewrites every user in the current layout.
+func migrateBackendUsers(tx *vbolt.Tx) (records int) {
+	if tx.Bucket([]byte(backendPasswdBucket.Name)) != nil {
+		hashes := make(map[int][]byte)
+		vbolt.IterateAll(tx, backendPasswdBucket, func(key int, value []byte) bool {
+			hashes[key] = value
+			return true
+		})
+		for userId, hash := range hashes {
+			var legacy backendUserV1
+			if vbolt.Read(tx, backendUsersBucket, userId, &legacy) {
+				user := User{
+					Id:        legacy.Id,
+					Email:     legacy.Email,
+					Status:    Active,
+					LastLogin: legacy.LastLogin,
+					FirstName: legacy.FirstName,
+					LastName:  legacy.LastName,
+					Creation:  legacy.Creation,
+				}
+				vbolt.Write(tx, UsersBucket, userId, &user)
+			}
+			if !vbolt.HasKey(tx, PasswordBucket, userId) {
+				vbolt.Write(tx, PasswordBucket, userId, &hash)
+			}
+		}
+		tx.DeleteBucket([]byte(backendPasswdBucket.Name))
+	}
+	return rewriteBucket(tx, UsersBucket)
 }
 
 // Buckets
@@ -128,6 +198,7 @@ func AddUserTx(tx *vbolt.Tx, req AddUserRequest, hash []byte) User {
 	user.Status = Active
 	user.FirstName = req.FirstName
 	user.LastName = req.LastName
+	user.Creation = time.Now()
 
 	vbolt.Write(tx, UsersBucket, user.Id, &user)
 	vbolt.Write(tx, PasswordBucket, user.Id, &hash)
@@ -438,29 +509,33 @@ func forgotEmail(context ResponseContext) {
 	}
 	saveToken(token, accountEmail)
 
+	resetLink := os.Getenv("SITE_ROOT") + "/reset-password?token=" + token
+
+	err = sendEmail([]string{accountEmail}, "Reset Your Password",
+		"Hello,\r\n\r\n"+
+			"To reset your password, please click the link below:\r\n"+
+			resetLink+"\r\n\r\n"+
+			"If you did not request a password reset, please ignore this email.\r\n")
+	if err != nil {
+		log.Fatalf("Failed to send email: %v", err)
+	}
+
+	http.Redirect(context.w, context.r, "/reset-password-sent", http.StatusFound)
+}
+
+// sendEmail sends a plain text message from the site's EMAIL account
+func sendEmail(recipients []string, subject string, body string) error {
 	email := os.Getenv("EMAIL")
 	appPassword := os.Getenv("APP_PASSWORD")
 	smtpHost := "smtp.gmail.com"
 	smtpPort := "587"
-	recipient := accountEmail
 
-	resetLink := os.Getenv("SITE_ROOT") + "/reset-password?token=" + token
-
-	message := []byte("Subject: Reset Your Password\r\n" +
+	message := []byte("Subject: " + subject + "\r\n" +
 		"\r\n" +
-		"Hello,\r\n\r\n" +
-		"To reset your password, please click the link below:\r\n" +
-		resetLink + "\r\n\r\n" +
-		"If you did not request a password reset, please ignore this email.\r\n")
+		body)
 
 	auth := smtp.PlainAuth("", email, appPassword, smtpHost)
-
-	err = smtp.SendMail(smtpHost+":"+smtpPort, auth, email, []string{recipient}, message)
-	if err != nil {
-		log.Fatalf("Failed to send email: %v", err)
-	}
-
-	http.Redirect(context.w, context.r, "/reset-password-sent", http.StatusFound)
+	return smtp.SendMail(smtpHost+":"+smtpPort, auth, email, recipients, message)
 }
 
 func resetEmailSent(context ResponseContext) {
@@ -556,14 +631,22 @@ func googleCallback(ctx ResponseContext) {
 
 func editUserPage(context ResponseContext) {
 	RenderTemplateWithData(context, "edit-profile", map[string]any{
-		"firstname": context.user.FirstName,
-		"lastname":  context.user.LastName,
+		"firstname":   context.user.FirstName,
+		"lastname":    context.user.LastName,
+		"units":       context.user.Units,
+		"UnitSystems": unitSystems,
 	})
 }
 
 func saveUser(context ResponseContext) {
+	units, err := parseUnitSystem(context.r.PostFormValue("units"))
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	context.user.FirstName = context.r.PostFormValue("firstname")
 	context.user.LastName = context.r.PostFormValue("lastname")
+	context.user.Units = units
 
 	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
 		vbolt.Write(tx, UsersBucket, context.user.Id, &context.user)
@@ -7,6 +7,7 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -45,6 +46,18 @@ var funcMap = template.FuncMap{
 		}
 		return t.Format("2006-01-02")
 	},
+	"formatDateTime": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("Jan 2, 2006 3:04 PM")
+	},
+	"formatDateTimeForInput": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("2006-01-02T15:04")
+	},
 	"formatNumber": func(n float64) string {
 		return fmt.Sprintf("%.2f", n)
 	},
@@ -71,29 +84,33 @@ var funcMap = template.FuncMap{
 	"displayHtml": func(content string) template.HTML {
 		return template.HTML(content)
 	},
-	"formatMilestoneType": func(milestoneType MilestoneType) string {
-		return parseMilestoneTypeLabel(milestoneType)
+	"formatMilestoneCategory": func(category MilestoneCategory) string {
+		return parseMilestoneCategoryLabel(category)
+	},
+	"formatUnitSystem": func(system UnitSystem) string {
+		return parseUnitSystemLabel(system)
 	},
+	"formatPercentile":   formatPercentile,
+	"checklistBandLabel": checklistBandLabel,
+	"fieldError":         fieldError,
 	"displayType": func(person Person) string {
-		switch person.Gender {
-		case Male:
-			if person.Type == Parent {
-				return "Father"
-			} else {
-				return "Son"
-			}
-		case Female:
-			if person.Type == Parent {
-				return "Mother"
-			} else {
-				return "Daughter"
-			}
+		if person.Relationship != "" {
+			return person.Relationship
 		}
-		return ""
+		return defaultRelationshipLabel(person)
+	},
+	"parsePersonTypeLabel": func(personType PersonType) string {
+		return parsePersonTypeLabel(personType)
+	},
+	"formatPersonType": func(personType PersonType) string {
+		return defaultRelationshipLabel(Person{Type: personType, Gender: Undisclosed})
 	},
 	"formatVisibility": func(visibility VisibilityType) string {
 		return parseVisibilityLabel(visibility)
 	},
+	"formatTrashKind": func(kind TrashKind) string {
+		return parseTrashKindLabel(kind)
+	},
 }
 
 var templatePaths map[string]string
@@ -365,24 +382,26 @@ func main() {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
 
+	if path := os.Getenv("MEDICATION_TABLE"); path != "" {
+		if err := loadMedicationTable(path); err != nil {
+			log.Fatalf("Error loading medication table: %v", err)
+		}
+	}
+
+	if err := loadGrowthData(); err != nil {
+		log.Fatalf("Error loading growth data: %v", err)
+	}
+
 	db = vbolt.Open(dbFile)
 	vbolt.InitBuckets(db, &Info)
 
-	// migrations
-	vbolt.ApplyDBProcess(db, "2025-0307-reset-user", func() {
-		vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
-			tx.DeleteBucket([]byte(UsersBucket.Name))
-			tx.DeleteBucket([]byte(PasswordBucket.Name))
-			tx.DeleteBucket([]byte(EmailBucket.Name))
-			tx.CreateBucket([]byte(UsersBucket.Name))
-			tx.CreateBucket([]byte(PasswordBucket.Name))
-			tx.CreateBucket([]byte(EmailBucket.Name))
-			vbolt.TxCommit(tx)
-		})
-	})
+	runMigrations(db)
 
 	defer db.Close()
 
+	go runTrashPurge(time.Hour)
+	go runVaccineReminders(time.Hour)
+
 	mux := &Mux{
 		family: http.NewServeMux(),
 		maia:   http.NewServeMux(),
@@ -393,13 +412,30 @@ func main() {
 	mux.maia.Handle("/", http.FileServer(http.Dir("../maia/html")))
 
 	RegisterMeasurementsPages(mux.family)
+	RegisterComparisonPages(mux.family)
+	RegisterBodyMassPages(mux.family)
+	RegisterGrowthAlertPages(mux.family)
+	RegisterHeightPredictionPages(mux.family)
 	RegisterChildrenPage(mux.family)
 	RegisterPostPages(mux.family)
 	RegisterLoginPages(mux.family)
 	RegisterMilestonesPages(mux.family)
+	RegisterChecklistPages(mux.family)
+	RegisterVaccinationPages(mux.family)
+	RegisterVisitPages(mux.family)
+	RegisterMedicationPages(mux.family)
+	RegisterIllnessPages(mux.family)
 	RegisterAdminPages(mux.family)
 	RegisterDashboardPages(mux.family)
 	RegisterImagePages(mux.family)
+	RegisterGedcomPages(mux.family)
+	RegisterCSVImportPages(mux.family)
+	RegisterHealthImportPages(mux.family)
+	RegisterExportPages(mux.family)
+	RegisterFHIRPages(mux.family)
+	RegisterDeletionPages(mux.family)
+	RegisterTrashPages(mux.family)
+	RegisterMigrationPages(mux.family)
 
 	// HTTP to HTTPS redirect handler
 	go func() {
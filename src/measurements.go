@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
@@ -12,113 +14,271 @@ import (
 	"go.hasen.dev/vpack"
 )
 
-type PersonHeight struct {
-	Id         int
-	PersonId   int
-	Inches     float64
-	Date       time.Time
-	DateString string
-	Age        float64
-	PersonName string
+type MeasurementKind int
+
+const (
+	HeightKind MeasurementKind = iota
+	WeightKind
+	HeadCircumferenceKind
+	ShoeSizeKind
+	TemperatureKind
+)
+
+// MeasurementKindInfo describes how a kind of measurement is entered and shown.
+// Values are stored in the Metric unit; values outside Min..Max (also metric)
+// are rejected when saved. A change from the neighbouring measurements bigger
+// than MaxYearlyChange per year plus Tolerance is flagged as a likely typo;
+// kinds with no MaxYearlyChange are not checked. TimeOfDay kinds are recorded
+// with the time they were taken, not just the date.
+type MeasurementKindInfo struct {
+	Kind            MeasurementKind
+	Slug            string
+	Label           string
+	Metric          Unit
+	Imperial        Unit
+	Min             float64
+	Max             float64
+	Precision       int
+	MaxYearlyChange float64
+	Tolerance       float64
+	TimeOfDay       bool
 }
 
-type PersonWeight struct {
-	Id         int
-	PersonId   int
-	Pounds     float64
-	Date       time.Time
-	DateString string
-	Age        float64
-	PersonName string
+// indexed by MeasurementKind; append new kinds at the end
+var measurementKinds = []MeasurementKindInfo{
+	{Kind: HeightKind, Slug: "height", Label: "Height", Metric: UnitCentimeters, Imperial: UnitInches, Min: 12, Max: 255, Precision: 2, MaxYearlyChange: 30, Tolerance: 3},
+	{Kind: WeightKind, Slug: "weight", Label: "Weight", Metric: UnitKilograms, Imperial: UnitPounds, Min: 0.2, Max: 320, Precision: 2, MaxYearlyChange: 20, Tolerance: 2},
+	{Kind: HeadCircumferenceKind, Slug: "head", Label: "Head Circumference", Metric: UnitCentimeters, Imperial: UnitInches, Min: 20, Max: 76, Precision: 2, MaxYearlyChange: 15, Tolerance: 2},
+	{Kind: ShoeSizeKind, Slug: "shoe", Label: "Shoe Size", Metric: UnitShoeSize, Imperial: UnitShoeSize, Min: 0, Max: 20, Precision: 1, MaxYearlyChange: 4, Tolerance: 1.5},
+	{Kind: TemperatureKind, Slug: "temperature", Label: "Temperature", Metric: UnitCelsius, Imperial: UnitFahrenheit, Min: 32, Max: 43.5, Precision: 1, TimeOfDay: true},
 }
 
-type MilestoneResponse struct {
-	People     []Person
-	Milestones []MilestoneAges
+func (kind MeasurementKind) Info() MeasurementKindInfo {
+	if kind < 0 || int(kind) >= len(measurementKinds) {
+		return MeasurementKindInfo{Kind: kind, Label: "Unknown"}
+	}
+	return measurementKinds[kind]
 }
 
-type MilestoneAges struct {
-	MilestoneAge float64
-	Average      float64
-	Values       []float64
+func getMeasurementKind(slug string) (MeasurementKindInfo, bool) {
+	for _, info := range measurementKinds {
+		if info.Slug == slug {
+			return info, true
+		}
+	}
+	return MeasurementKindInfo{}, false
 }
 
-func PackPersonHeight(self *PersonHeight, buf *vpack.Buffer) {
-	vpack.Version(1, buf)
-	vpack.Int(&self.Id, buf)
-	vpack.Int(&self.PersonId, buf)
-	vpack.Float64(&self.Inches, buf)
-	vpack.Time(&self.Date, buf)
+func (info MeasurementKindInfo) Unit(system UnitSystem) Unit {
+	if system == Metric {
+		return info.Metric
+	}
+	return info.Imperial
 }
 
-func PackPersonWeight(self *PersonWeight, buf *vpack.Buffer) {
-	vpack.Version(1, buf)
+// Units lists the units a value can be entered in
+func (info MeasurementKindInfo) Units() []Unit {
+	if info.Metric == info.Imperial {
+		return []Unit{info.Metric}
+	}
+	return []Unit{info.Imperial, info.Metric}
+}
+
+func (info MeasurementKindInfo) findUnit(symbol string) (Unit, bool) {
+	for _, unit := range info.Units() {
+		if unit.Symbol == symbol {
+			return unit, true
+		}
+	}
+	return Unit{}, false
+}
+
+func (info MeasurementKindInfo) Format(value float64) string {
+	return strconv.FormatFloat(value, 'f', info.Precision, 64)
+}
+
+// Validate checks a stored (metric) value, reporting the range in the unit it
+// was entered in.
+func (info MeasurementKindInfo) Validate(value float64, unit Unit) error {
+	if value < info.Min || value > info.Max {
+		return fmt.Errorf("%s must be between %s and %s %s", info.Label,
+			info.Format(unit.FromCanonical(info.Min)), info.Format(unit.FromCanonical(info.Max)), unit.Symbol)
+	}
+	return nil
+}
+
+// CheckOutlier compares a value with the measurements just before and after
+// it, which are in date order and exclude the one being saved.
+func (info MeasurementKindInfo) CheckOutlier(entry Measurement, others []Measurement, unit Unit) error {
+	if info.MaxYearlyChange == 0 {
+		return nil
+	}
+	var neighbours []Measurement
+	for i, other := range others {
+		if !other.Date.Before(entry.Date) {
+			neighbours = append(neighbours, other)
+			break
+		}
+		if i == len(others)-1 || !others[i+1].Date.Before(entry.Date) {
+			neighbours = append(neighbours, other)
+		}
+	}
+	for _, other := range neighbours {
+		years := math.Abs(entry.Date.Sub(other.Date).Hours()) / (365.25 * 24)
+		if change := math.Abs(entry.Value - other.Value); change > info.MaxYearlyChange*years+info.Tolerance {
+			return fmt.Errorf("that is %s %s away from the %s %s on %s; check for a typo or confirm it",
+				info.Format(change/unit.Scale), unit.Symbol,
+				info.Format(unit.FromCanonical(other.Value)), unit.Symbol, other.Date.Format("Jan 2, 2006"))
+		}
+	}
+	return nil
+}
+
+type Measurement struct {
+	Id        int
+	PersonId  int
+	Kind      MeasurementKind
+	Value     float64 // in the kind's metric unit
+	EntryUnit string  // symbol of the unit it was entered in
+	Date      time.Time
+	DateOnly  bool // a TimeOfDay kind recorded with just a day, at midnight UTC
+
+	DateString    string
+	Age           float64 // corrected for prematurity when Corrected
+	Corrected     bool
+	PersonName    string
+	DisplayValue  float64
+	DisplayUnit   string
+	ZScore        float64
+	Percentile    float64
+	HasPercentile bool
+}
+
+// HasTime is whether the time of day the measurement was taken is known
+func (measurement Measurement) HasTime() bool {
+	return measurement.Kind.Info().TimeOfDay && !measurement.DateOnly
+}
+
+// exportDate is the day, or the full UTC timestamp when the time is known
+func (measurement Measurement) exportDate() string {
+	if measurement.HasTime() {
+		return measurement.Date.UTC().Format(time.RFC3339)
+	}
+	return measurement.Date.Format("2006-01-02")
+}
+
+// localMeasurementTimes puts time-of-day measurements on the viewer's clock;
+// date-only ones stay at midnight UTC so they keep their day
+func localMeasurementTimes(measurements []Measurement, loc *time.Location) {
+	for i := range measurements {
+		if measurements[i].HasTime() {
+			measurements[i].Date = measurements[i].Date.In(loc)
+		}
+	}
+}
+
+// setDisplayUnits converts values into the unit system being shown
+func setDisplayUnits(measurements []Measurement, system UnitSystem) {
+	for i := range measurements {
+		unit := measurements[i].Kind.Info().Unit(system)
+		measurements[i].DisplayValue = unit.FromCanonical(measurements[i].Value)
+		measurements[i].DisplayUnit = unit.Symbol
+	}
+}
+
+// MeasurementSeries is one person's measurements of a kind, with the growth
+// reference bands for their sex when there are any, and adult height
+// predictions for children's heights.
+type MeasurementSeries struct {
+	PersonName   string
+	Unit         string
+	Measurements []Measurement
+	Bands        []PercentileBand
+	Predictions  []PredictionRange
+}
+
+func PackMeasurement(self *Measurement, buf *vpack.Buffer) {
+	version := vpack.Version(3, buf)
 	vpack.Int(&self.Id, buf)
 	vpack.Int(&self.PersonId, buf)
-	vpack.Float64(&self.Pounds, buf)
+	vpack.IntEnum(&self.Kind, buf)
+	vpack.Float64(&self.Value, buf)
 	vpack.Time(&self.Date, buf)
+	if version >= 2 {
+		vpack.String(&self.EntryUnit, buf)
+	}
+	if version >= 3 {
+		vpack.Bool(&self.DateOnly, buf)
+	}
 }
 
-var PersonHeightBucket = vbolt.Bucket(&Info, "personHeight", vpack.FInt, PackPersonHeight)
-var PersonWeightsBucket = vbolt.Bucket(&Info, "personWeight", vpack.FInt, PackPersonWeight)
+type MeasurementTerm struct {
+	PersonId int
+	Kind     MeasurementKind
+}
 
-// PersonHeightIdx term: person id, priority: timestamp, target: person height id
-var PersonHeightIdx = vbolt.IndexExt(&Info, "heights_by", vpack.FInt, vpack.UnixTimeKey, vpack.FInt)
+func PackMeasurementTerm(self *MeasurementTerm, buf *vpack.Buffer) {
+	vpack.FInt(&self.PersonId, buf)
+	vpack.IntEnum(&self.Kind, buf)
+}
 
-// PersonWeightIdx term: person id, priority: timestamp, target: person weight id
-var PersonWeightIdx = vbolt.IndexExt(&Info, "weights_by", vpack.FInt, vpack.UnixTimeKey, vpack.FInt)
+var MeasurementBucket = vbolt.Bucket(&Info, "measurements", vpack.FInt, PackMeasurement)
 
-func updateIndex(tx *vbolt.Tx, entry PersonHeight) {
-	priority := entry.Date
-	vbolt.SetTargetSingleTermExt(
-		tx,              // transaction
-		PersonHeightIdx, // index reference
-		entry.Id,        // target
-		priority,        // priority (same for all terms)
-		entry.PersonId,  // terms (slice)
-	)
+// MeasurementIndex term: person id and kind, priority: timestamp, target: measurement id
+var MeasurementIndex = vbolt.IndexExt(&Info, "measurements_by", PackMeasurementTerm, vpack.UnixTimeKey, vpack.FInt)
+
+// updateMeasurementIndex replaces the measurement's old index entry, so an
+// edited date or person does not leave a stale one behind
+func updateMeasurementIndex(tx *vbolt.Tx, entry Measurement) {
+	term := MeasurementTerm{PersonId: entry.PersonId, Kind: entry.Kind}
+	vbolt.SetTargetSingleTermExt(tx, MeasurementIndex, entry.Id, entry.Date, term)
 }
-func updateWeightIndex(tx *vbolt.Tx, entry PersonWeight) {
-	priority := entry.Date
-	vbolt.SetTargetSingleTermExt(
-		tx,              // transaction
-		PersonWeightIdx, // index reference
-		entry.Id,        // target
-		priority,        // priority (same for all terms)
-		entry.PersonId,  // terms (slice)
-	)
+
+func saveMeasurementTx(tx *vbolt.Tx, entry *Measurement) {
+	if entry.Id == 0 {
+		entry.Id = vbolt.NextIntId(tx, MeasurementBucket)
+	}
+	vbolt.Write(tx, MeasurementBucket, entry.Id, entry)
+	updateMeasurementIndex(tx, *entry)
 }
 
-func QueryHeights(personId int) (heights []PersonHeight) {
-	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
-		people := getAllPeopleMap(tx)
-
-		var window vbolt.Window
-		var heightIds []int
-		vbolt.ReadTermTargets(tx, PersonHeightIdx, personId, &heightIds, window)
-		vbolt.ReadSlice(tx, PersonHeightBucket, heightIds, &heights)
-		for i := range heights {
-			heights[i].DateString = heights[i].Date.Format("January 02, 2006")
-			heights[i].Age = heights[i].Date.Sub(people[heights[i].PersonId].Birthday).Hours() / (365.25 * 24) // Age in years
-			heights[i].PersonName = people[heights[i].PersonId].Name
-		}
-	})
+func deleteMeasurementTx(tx *vbolt.Tx, measurementId int) {
+	vbolt.Delete(tx, MeasurementBucket, measurementId)
+	vbolt.SetTargetTermsPlain(tx, MeasurementIndex, measurementId, nil)
+}
+
+// getPersonMeasurementsTx returns the stored records of every kind for a person
+func getPersonMeasurementsTx(tx *vbolt.Tx, personId int) (measurements []Measurement) {
+	for _, info := range measurementKinds {
+		var ids []int
+		var entries []Measurement
+		vbolt.ReadTermTargets(tx, MeasurementIndex, MeasurementTerm{PersonId: personId, Kind: info.Kind}, &ids, vbolt.Window{})
+		vbolt.ReadSlice(tx, MeasurementBucket, ids, &entries)
+		measurements = append(measurements, entries...)
+	}
+	return
+}
+
+func queryMeasurementsTx(tx *vbolt.Tx, personId int, kind MeasurementKind) (measurements []Measurement) {
+	person := getPerson(tx, personId)
+
+	var ids []int
+	vbolt.ReadTermTargets(tx, MeasurementIndex, MeasurementTerm{PersonId: personId, Kind: kind}, &ids, vbolt.Window{})
+	vbolt.ReadSlice(tx, MeasurementBucket, ids, &measurements)
+	for i := range measurements {
+		measurements[i].DateString = measurements[i].Date.Format("January 02, 2006")
+		measurements[i].Age = person.AgeAt(measurements[i].Date)
+		measurements[i].Corrected = person.isAgeCorrected(measurements[i].Date)
+		measurements[i].PersonName = person.Name
+		measurements[i].ZScore, measurements[i].Percentile, measurements[i].HasPercentile =
+			growthPercentile(kind, person.Gender, measurements[i].Age, measurements[i].Value)
+	}
 	return
 }
 
-func QueryWeights(personId int) (weights []PersonWeight) {
+func QueryMeasurements(personId int, kind MeasurementKind) (measurements []Measurement) {
 	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
-		people := getAllPeopleMap(tx)
-
-		var window vbolt.Window
-		var weightIds []int
-		vbolt.ReadTermTargets(tx, PersonWeightIdx, personId, &weightIds, window)
-		vbolt.ReadSlice(tx, PersonWeightsBucket, weightIds, &weights)
-		for i := range weights {
-			weights[i].DateString = weights[i].Date.Format("January 02, 2006")
-			weights[i].Age = weights[i].Date.Sub(people[weights[i].PersonId].Birthday).Hours() / (365.25 * 24) // Age in years
-			weights[i].PersonName = people[weights[i].PersonId].Name
-		}
+		measurements = queryMeasurementsTx(tx, personId, kind)
 	})
 	return
 }
@@ -128,287 +288,507 @@ func interpolate(x1, y1, x2, y2, x float64) float64 {
 	return y1 + (y2-y1)/(x2-x1)*(x-x1)
 }
 
-func getHeightMilestones(heights []PersonHeight, milestones []float64) (milestoneInches []float64) {
-	milestoneInches = make([]float64, 0, len(milestones))
-	milestoneIdx, heightIdx := 0, 0
-
-	for milestoneIdx < len(milestones) {
-		nextMilestone := milestones[milestoneIdx]
-
-		if heightIdx >= len(heights) {
-			milestoneInches = append(milestoneInches, 0)
-			milestoneIdx++
-			continue
-		}
-
-		if heights[heightIdx].Age == nextMilestone {
-			milestoneInches = append(milestoneInches, heights[heightIdx].Inches)
-			milestoneIdx++
-		} else if heights[heightIdx].Age > nextMilestone && heightIdx > 0 {
-			x1, y1 := heights[heightIdx-1].Age, heights[heightIdx-1].Inches
-			x2, y2 := heights[heightIdx].Age, heights[heightIdx].Inches
-			interpolated := interpolate(x1, y1, x2, y2, nextMilestone)
-			milestoneInches = append(milestoneInches, interpolated)
-			milestoneIdx++
-		} else {
-			heightIdx++
-		}
+func RegisterMeasurementsPages(mux *http.ServeMux) {
+	mux.Handle("GET /measurements/{kind}", PublicHandler(ContextFunc(measurementsPage)))
+	mux.Handle("GET /measurements/{kind}/add", AuthHandler(ContextFunc(addMeasurementPage)))
+	mux.Handle("POST /measurements/{kind}/add", AuthHandler(ContextFunc(saveMeasurementPage)))
+	mux.Handle("GET /measurements/{kind}/edit/{id}", OwnerHandler(ContextFunc(editMeasurementPage)))
+	mux.Handle("GET /measurements/{kind}/delete/{id}", OwnerHandler(ContextFunc(deleteMeasurementPage)))
+	mux.Handle("POST /measurements/{kind}/delete/{id}", AuthHandler(ContextFunc(deleteMeasurement)))
+	mux.Handle("GET /measurements/{kind}/table/{id}", PublicHandler(ContextFunc(measurementTablePage)))
+	mux.Handle("GET /api/measurements/{kind}/{id}", PublicHandler(ContextFunc(measurementApi)))
+	mux.Handle("GET /api/measurements/{kind}/table", PublicHandler(ContextFunc(measurementTableApi)))
+
+	// the original height and weight urls still work
+	for _, slug := range []string{"height", "weight"} {
+		mux.Handle("GET /"+slug, PublicHandler(ContextFunc(withMeasurementKind(slug, measurementsPage))))
+		mux.Handle("GET /"+slug+"/add", AuthHandler(ContextFunc(withMeasurementKind(slug, addMeasurementPage))))
+		mux.Handle("GET /"+slug+"/table/{id}", PublicHandler(ContextFunc(withMeasurementKind(slug, measurementTablePage))))
+		mux.Handle("GET /api/"+slug+"/{id}", PublicHandler(ContextFunc(withMeasurementKind(slug, measurementApi))))
+		mux.Handle("GET /api/"+slug+"/table", PublicHandler(ContextFunc(withMeasurementKind(slug, measurementTableApi))))
 	}
+}
 
-	return milestoneInches
+func withMeasurementKind(slug string, next ContextFunc) ContextFunc {
+	return func(context ResponseContext) {
+		context.r.SetPathValue("kind", slug)
+		next(context)
+	}
 }
 
-func getWeightMilestones(weights []PersonWeight, milestones []float64) (milestonePounds []float64) {
-	milestonePounds = make([]float64, 0, len(milestones))
-	milestoneIdx, weightIdx := 0, 0
+func measurementKindFromPath(context ResponseContext) (MeasurementKindInfo, bool) {
+	info, found := getMeasurementKind(context.r.PathValue("kind"))
+	if !found {
+		http.Error(context.w, "unknown measurement kind", http.StatusNotFound)
+	}
+	return info, found
+}
 
-	for milestoneIdx < len(milestones) {
-		nextMilestone := milestones[milestoneIdx]
+func measurementsPage(context ResponseContext) {
+	info, found := measurementKindFromPath(context)
+	if !found {
+		return
+	}
+	unit := info.Unit(requestUnits(context))
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		RenderTemplateWithData(context, "measurement", map[string]any{
+			"Kind":    info,
+			"Kinds":   measurementKinds,
+			"Unit":    unit,
+			"People":  getPeopleInFamily(tx, context.user.PrimaryFamilyId),
+			"Cousins": comparableCousinsTx(tx, context.user.PrimaryFamilyId),
+			"Grids":   comparisonGrids,
+		})
+	})
+}
 
-		if weightIdx >= len(weights) {
-			milestonePounds = append(milestonePounds, 0)
-			milestoneIdx++
-			continue
-		}
+func addMeasurementPage(context ResponseContext) {
+	info, found := measurementKindFromPath(context)
+	if !found {
+		return
+	}
+	unit := info.Unit(requestUnits(context))
+	renderMeasurementForm(context, info, unit, Measurement{Kind: info.Kind}, context.user.PrimaryFamilyId, nil)
+}
 
-		if weights[weightIdx].Age == nextMilestone {
-			milestonePounds = append(milestonePounds, weights[weightIdx].Pounds)
-			milestoneIdx++
-		} else if weights[weightIdx].Age > nextMilestone && weightIdx > 0 {
-			x1, y1 := weights[weightIdx-1].Age, weights[weightIdx-1].Pounds
-			x2, y2 := weights[weightIdx].Age, weights[weightIdx].Pounds
-			interpolated := interpolate(x1, y1, x2, y2, nextMilestone)
-			milestonePounds = append(milestonePounds, interpolated)
-			milestoneIdx++
-		} else {
-			weightIdx++
-		}
+// renderMeasurementForm shows the add/edit form, again with the problems when
+// a save was rejected; the measurement's DisplayValue is what goes in the box.
+func renderMeasurementForm(context ResponseContext, info MeasurementKindInfo, unit Unit, measurement Measurement, familyId int, form *Form) {
+	data := map[string]any{
+		"Kind":        info,
+		"Unit":        unit,
+		"Measurement": measurement,
+	}
+	if form != nil {
+		data["Errors"] = form.Errors
+		_, data["Outlier"] = form.Errors["outlier"]
 	}
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		data["People"] = getPeopleInFamily(tx, familyId)
+	})
+	RenderTemplateWithData(context, "measurement-add", data)
+}
 
-	return milestonePounds
+// readMeasurementTx loads a measurement of a kind, reporting a missing one as not found
+func readMeasurementTx(tx *vbolt.Tx, info MeasurementKindInfo, id int) (measurement Measurement, found bool) {
+	vbolt.Read(tx, MeasurementBucket, id, &measurement)
+	return measurement, measurement.Id != 0 && measurement.Kind == info.Kind
 }
 
-func RegisterMeasurementsPages(mux *http.ServeMux) {
-	mux.Handle("GET /height", PublicHandler(ContextFunc(mainHeightsPage)))
-	mux.Handle("GET /weight", PublicHandler(ContextFunc(mainWeightsPage)))
-	mux.Handle("GET /height/add", AuthHandler(ContextFunc(addHeightPage)))
-	mux.Handle("POST /height/add", AuthHandler(ContextFunc(saveHeightPage)))
-	mux.Handle("GET /weight/add", AuthHandler(ContextFunc(addWeightPage)))
-	mux.Handle("POST /weight/add", AuthHandler(ContextFunc(saveWeightPage)))
-	mux.Handle("GET /api/height/{id}", PublicHandler(ContextFunc(heightApi)))
-	mux.Handle("GET /api/weight/{id}", PublicHandler(ContextFunc(weightApi)))
-
-	mux.Handle("GET /height/table/{id}", PublicHandler(ContextFunc(heightTablePage)))
-	mux.Handle("GET /weight/table/{id}", PublicHandler(ContextFunc(weightTablePage)))
-	mux.Handle("GET /api/height/table", PublicHandler(ContextFunc(heightTableApi)))
-	mux.Handle("GET /api/weight/table", PublicHandler(ContextFunc(weightTableApi)))
-}
-
-func mainHeightsPage(context ResponseContext) {
+func editMeasurementPage(context ResponseContext) {
+	info, found := measurementKindFromPath(context)
+	if !found {
+		return
+	}
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	var measurement Measurement
 	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
-		RenderTemplateWithData(context, "height", map[string]any{
-			"People": getPeopleInFamily(tx, context.user.PrimaryFamilyId),
-		})
+		measurement, found = readMeasurementTx(tx, info, id)
+		context.familyId = getPerson(tx, measurement.PersonId).FamilyId
 	})
+	if !found {
+		http.Error(context.w, "measurement not found", http.StatusNotFound)
+		return
+	}
+
+	// shown in the unit it was entered in
+	unit, found := info.findUnit(measurement.EntryUnit)
+	if !found {
+		unit = info.Unit(requestUnits(context))
+	}
+	measurement.DisplayValue = unit.FromCanonical(measurement.Value)
+	if measurement.HasTime() {
+		measurement.Date = measurement.Date.In(requestLocation(context.r))
+	} else if info.TimeOfDay {
+		// the form asks for a time; start from midnight on the same day
+		year, month, day := measurement.Date.Date()
+		measurement.Date = time.Date(year, month, day, 0, 0, 0, 0, requestLocation(context.r))
+	}
+	renderMeasurementForm(context, info, unit, measurement, context.familyId, nil)
 }
-func mainWeightsPage(context ResponseContext) {
+
+func deleteMeasurementPage(context ResponseContext) {
+	info, found := measurementKindFromPath(context)
+	if !found {
+		return
+	}
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	system := requestUnits(context)
 	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
-		RenderTemplateWithData(context, "weight", map[string]any{
-			"People": getPeopleInFamily(tx, context.user.PrimaryFamilyId),
+		measurement, found := readMeasurementTx(tx, info, id)
+		if !found {
+			http.Error(context.w, "measurement not found", http.StatusNotFound)
+			return
+		}
+		person := getPerson(tx, measurement.PersonId)
+		context.familyId = person.FamilyId
+		measurements := []Measurement{measurement}
+		setDisplayUnits(measurements, system)
+		localMeasurementTimes(measurements, requestLocation(context.r))
+		RenderTemplateWithData(context, "measurement-delete", map[string]any{
+			"Kind":        info,
+			"Person":      person,
+			"Measurement": measurements[0],
 		})
 	})
 }
 
-func addHeightPage(context ResponseContext) {
-	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
-		RenderTemplateWithData(context, "height-add", map[string]interface{}{
-			"People": getPeopleInFamily(tx, context.user.PrimaryFamilyId),
-		})
+func deleteMeasurement(context ResponseContext) {
+	info, found := measurementKindFromPath(context)
+	if !found {
+		return
+	}
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+
+	var measurement Measurement
+	var err error
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		measurement, found = readMeasurementTx(tx, info, id)
+		familyId := getPerson(tx, measurement.PersonId).FamilyId
+		if !found || !isFamilyOwner(tx, familyId, context.user.Id) {
+			err = errors.New("not a family owner")
+			return
+		}
+		trashMeasurementTx(tx, measurement, familyId, context.user.Id)
+		vbolt.TxCommit(tx)
 	})
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	http.Redirect(context.w, context.r, fmt.Sprintf("/measurements/%s/table/%d", info.Slug, measurement.PersonId), http.StatusFound)
 }
 
-func saveHeightPage(context ResponseContext) {
-	context.r.ParseForm()
-	measureDate := context.r.FormValue("measureDate")
-	inches, _ := strconv.ParseFloat(context.r.FormValue("inches"), 64)
-	personId, _ := strconv.Atoi(context.r.FormValue("personId"))
-	id, _ := strconv.Atoi(context.r.FormValue("id"))
+func saveMeasurementPage(context ResponseContext) {
+	info, found := measurementKindFromPath(context)
+	if !found {
+		return
+	}
+	form := bindForm(context.r)
+	id := form.Int("id", "Id", false)
+	personId := form.Int("personId", "Person", true)
+	var measureDate time.Time
+	if info.TimeOfDay {
+		measureDate = form.DateTime("measureDate", "Measurement time", true, requestLocation(context.r))
+	} else {
+		measureDate = form.Date("measureDate", "Measurement date", true)
+	}
+	entered := form.Float("value", info.Label, true)
 
-	measureDateTime, _ := time.Parse("2006-01-02", measureDate)
+	unit, found := info.findUnit(form.Value("unit"))
+	if !found {
+		form.Fail("unit", "unknown unit for %s", info.Label)
+		unit = info.Unit(requestUnits(context))
+	}
+	value := unit.ToCanonical(entered)
+	if form.Errors["value"] == "" {
+		if err := info.Validate(value, unit); err != nil {
+			form.Fail("value", "%s", err.Error())
+		}
+	}
 
-	entry := PersonHeight{
-		Id:       id,
-		Date:     measureDateTime,
-		PersonId: personId,
-		Inches:   inches,
+	entry := Measurement{
+		Id:           id,
+		PersonId:     personId,
+		Kind:         info.Kind,
+		Value:        value,
+		EntryUnit:    unit.Symbol,
+		Date:         measureDate,
+		DisplayValue: entered,
 	}
+	var alerts []GrowthAlert
+	var familyId int
+	var err error
 	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
-		if entry.Id == 0 {
-			entry.Id = vbolt.NextIntId(tx, PersonHeightBucket)
+		person := getPerson(tx, entry.PersonId)
+		familyId = person.FamilyId
+		if person.Id == 0 {
+			form.Fail("personId", "Person is required")
+			familyId = context.user.PrimaryFamilyId
+			return
+		}
+		if !isFamilyOwner(tx, familyId, context.user.Id) {
+			err = errors.New("not a family owner")
+			return
+		}
+		form.DuringLife("measureDate", "Measurement date", entry.Date, person)
+		if form.Valid() && !form.Checked("confirmOutlier") {
+			var others []Measurement
+			for _, other := range queryMeasurementsTx(tx, entry.PersonId, info.Kind) {
+				if other.Id != entry.Id {
+					others = append(others, other)
+				}
+			}
+			if err := info.CheckOutlier(entry, others, unit); err != nil {
+				form.Fail("outlier", "%s", err.Error())
+			}
+		}
+		if !form.Valid() {
+			return
+		}
+		// an edit may move the measurement to another person, whose alerts change too
+		var previous Measurement
+		if entry.Id != 0 {
+			var found bool
+			previous, found = readMeasurementTx(tx, info, entry.Id)
+			if !found || !isFamilyOwner(tx, getPerson(tx, previous.PersonId).FamilyId, context.user.Id) {
+				err = errors.New("not a family owner")
+				return
+			}
+		}
+		saveMeasurementTx(tx, &entry)
+		alerts = refreshGrowthAlertsTx(tx, entry.PersonId)
+		if previous.Id != 0 && previous.PersonId != entry.PersonId {
+			refreshGrowthAlertsTx(tx, previous.PersonId)
+		}
+		if entry.Kind == HeightKind {
+			recordHeightPredictionsTx(tx, familyId)
 		}
-		vbolt.Write(tx, PersonHeightBucket, entry.Id, &entry)
-		updateIndex(tx, entry)
 		vbolt.TxCommit(tx)
 	})
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !form.Valid() {
+		renderMeasurementForm(context, info, unit, entry, familyId, form)
+		return
+	}
+	go emailGrowthAlerts(familyId, alerts)
 
-	http.Redirect(context.w, context.r, "/height", http.StatusFound)
+	if id != 0 {
+		http.Redirect(context.w, context.r, fmt.Sprintf("/measurements/%s/table/%d", info.Slug, entry.PersonId), http.StatusFound)
+		return
+	}
+	http.Redirect(context.w, context.r, "/measurements/"+info.Slug, http.StatusFound)
 }
 
-func addWeightPage(context ResponseContext) {
+func measurementApi(context ResponseContext) {
+	info, found := measurementKindFromPath(context)
+	if !found {
+		return
+	}
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	system := requestUnits(context)
+	unit := info.Unit(system)
+
+	var series MeasurementSeries
 	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
-		RenderTemplateWithData(context, "weight-add", map[string]interface{}{
-			"People": getPeopleInFamily(tx, context.user.PrimaryFamilyId),
-		})
+		person := getPerson(tx, personId)
+		measurements := queryMeasurementsTx(tx, personId, info.Kind)
+		setDisplayUnits(measurements, system)
+
+		maxAge := 0.0
+		for _, measurement := range measurements {
+			maxAge = max(maxAge, measurement.Age)
+		}
+		series = MeasurementSeries{
+			PersonName:   person.Name,
+			Unit:         unit.Symbol,
+			Measurements: measurements,
+			Bands:        percentileBands(growthReferences[info.Kind], person.Gender, maxAge+1, unit),
+		}
+		if info.Kind == HeightKind {
+			series.Predictions = predictionRanges(predictAdultHeightTx(tx, person), unit)
+		}
 	})
+	context.w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(context.w).Encode(series)
 }
 
-func saveWeightPage(context ResponseContext) {
-	context.r.ParseForm()
-	measureDate := context.r.FormValue("measureDate")
-	pounds, _ := strconv.ParseFloat(context.r.FormValue("pounds"), 64)
-	personId, _ := strconv.Atoi(context.r.FormValue("personId"))
-	id, _ := strconv.Atoi(context.r.FormValue("id"))
-
-	measureDateTime, _ := time.Parse("2006-01-02", measureDate)
+func measurementTablePage(context ResponseContext) {
+	info, found := measurementKindFromPath(context)
+	if !found {
+		return
+	}
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	system := requestUnits(context)
+	measurements := QueryMeasurements(personId, info.Kind)
+	setDisplayUnits(measurements, system)
+	localMeasurementTimes(measurements, requestLocation(context.r))
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		context.familyId = getPerson(tx, personId).FamilyId
+	})
 
-	entry := PersonWeight{
-		Id:       id,
-		Date:     measureDateTime,
-		PersonId: personId,
-		Pounds:   pounds,
+	showPercentiles := false
+	for _, measurement := range measurements {
+		showPercentiles = showPercentiles || measurement.HasPercentile
 	}
-	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
-		if entry.Id == 0 {
-			entry.Id = vbolt.NextIntId(tx, PersonWeightsBucket)
+	// measurement id => velocity since the previous measurement
+	velocities := make(map[int]string)
+	if _, tracked := growthReferences[info.Kind]; tracked {
+		for _, velocity := range growthVelocities(measurements, system) {
+			velocities[velocity.ToId] = fmt.Sprintf("%+.2f %s", velocity.DisplayRate, velocity.DisplayUnit)
 		}
-		vbolt.Write(tx, PersonWeightsBucket, entry.Id, &entry)
-		updateWeightIndex(tx, entry)
-		vbolt.TxCommit(tx)
+	}
+	RenderTemplateWithData(context, "measurement-table", map[string]any{
+		"Kind":            info,
+		"Unit":            info.Unit(system),
+		"Measurements":    measurements,
+		"ShowPercentiles": showPercentiles,
+		"Velocities":      velocities,
 	})
+}
 
-	http.Redirect(context.w, context.r, "/weight", http.StatusFound)
+// Legacy height and weight storage. Nothing writes here anymore; the buckets
+// are kept so earlier migrations still run and can be copied from.
+
+type PersonHeight struct {
+	Id       int
+	PersonId int
+	Inches   float64
+	Date     time.Time
 }
-func heightApi(context ResponseContext) {
-	personId, _ := strconv.Atoi(context.r.PathValue("id"))
-	context.w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(context.w).Encode(QueryHeights(personId))
+
+type PersonWeight struct {
+	Id       int
+	PersonId int
+	Pounds   float64
+	Date     time.Time
 }
-func weightApi(context ResponseContext) {
-	personId, _ := strconv.Atoi(context.r.PathValue("id"))
-	context.w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(context.w).Encode(QueryWeights(personId))
+
+func PackPersonHeight(self *PersonHeight, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.Float64(&self.Inches, buf)
+	vpack.Time(&self.Date, buf)
 }
-func heightTablePage(context ResponseContext) {
-	personId, _ := strconv.Atoi(context.r.PathValue("id"))
-	RenderTemplateWithData(context, "height-table", map[string]interface{}{
-		"Heights": QueryHeights(personId),
-	})
+
+func PackPersonWeight(self *PersonWeight, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.Float64(&self.Pounds, buf)
+	vpack.Time(&self.Date, buf)
 }
-func weightTablePage(context ResponseContext) {
-	personId, _ := strconv.Atoi(context.r.PathValue("id"))
-	RenderTemplateWithData(context, "weight-table", map[string]interface{}{
-		"Weights": QueryWeights(personId),
-	})
+
+var PersonHeightBucket = vbolt.Bucket(&Info, "personHeight", vpack.FInt, PackPersonHeight)
+var PersonWeightsBucket = vbolt.Bucket(&Info, "personWeight", vpack.FInt, PackPersonWeight)
+
+// PersonHeightIdx term: person id, priority: timestamp, target: person height id
+var PersonHeightIdx = vbolt.IndexExt(&Info, "heights_by", vpack.FInt, vpack.UnixTimeKey, vpack.FInt)
+
+// PersonWeightIdx term: person id, priority: timestamp, target: person weight id
+var PersonWeightIdx = vbolt.IndexExt(&Info, "weights_by", vpack.FInt, vpack.UnixTimeKey, vpack.FInt)
+
+func updateIndex(tx *vbolt.Tx, entry PersonHeight) {
+	vbolt.SetTargetSingleTermExt(tx, PersonHeightIdx, entry.Id, entry.Date, entry.PersonId)
 }
-func heightTableApi(context ResponseContext) {
-	milestones := []float64{0, 1.0 / 12, 2.0 / 12, 3.0 / 12,
-		4.0 / 12, 5.0 / 12, 6.0 / 12, 7.0 / 12, 8.0 / 12,
-		9.0 / 12, 10.0 / 12, 11.0 / 12, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13}
-	personIDs := context.r.URL.Query()["ids"]
-	var response MilestoneResponse
 
-	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
-		people := getAllPeopleMap(tx)
-		for _, personId := range personIDs {
-			id, _ := strconv.Atoi(personId)
-			response.People = append(response.People, people[id])
-		}
-	})
+func updateWeightIndex(tx *vbolt.Tx, entry PersonWeight) {
+	vbolt.SetTargetSingleTermExt(tx, PersonWeightIdx, entry.Id, entry.Date, entry.PersonId)
+}
 
-	response.Milestones = make([]MilestoneAges, 0, len(milestones))
-	for i := range milestones {
-		response.Milestones = append(response.Milestones, MilestoneAges{
-			MilestoneAge: milestones[i],
-			Values:       make([]float64, len(personIDs)),
-		})
+func legacyMeasurements(heights []PersonHeight, weights []PersonWeight) (measurements []Measurement) {
+	for _, height := range heights {
+		measurements = append(measurements, Measurement{PersonId: height.PersonId, Kind: HeightKind, Value: height.Inches, Date: height.Date})
 	}
-	for i := range personIDs {
-		personId, _ := strconv.Atoi(personIDs[i])
-		personHeights := QueryHeights(personId)
-		personMilestones := getHeightMilestones(personHeights, milestones)
-		for j := range personMilestones {
-			response.Milestones[j].Values[i] = personMilestones[j]
-			response.Milestones[j].Average += personMilestones[j]
-		}
+	for _, weight := range weights {
+		measurements = append(measurements, Measurement{PersonId: weight.PersonId, Kind: WeightKind, Value: weight.Pounds, Date: weight.Date})
 	}
+	return
+}
 
-	for i := range milestones {
-		dataPointCount := 0
-		for j := range personIDs {
-			if response.Milestones[i].Values[j] > 0 {
-				dataPointCount++
-			}
+// migrateLegacyMeasurements copies every height and weight into the
+// measurements bucket, including the ones sitting in the trash.
+func migrateLegacyMeasurements(tx *vbolt.Tx) (records int) {
+	var heights []PersonHeight
+	var weights []PersonWeight
+	vbolt.IterateAll(tx, PersonHeightBucket, func(key int, value PersonHeight) bool {
+		heights = append(heights, value)
+		return true
+	})
+	vbolt.IterateAll(tx, PersonWeightsBucket, func(key int, value PersonWeight) bool {
+		weights = append(weights, value)
+		return true
+	})
+	for _, measurement := range legacyMeasurements(heights, weights) {
+		saveMeasurementTx(tx, &measurement)
+		records++
+	}
+
+	var entries []TrashEntry
+	vbolt.IterateAll(tx, TrashBucket, func(key int, value TrashEntry) bool {
+		if len(value.Heights) > 0 || len(value.Weights) > 0 {
+			entries = append(entries, value)
 		}
-		if dataPointCount > 0 {
-			response.Milestones[i].Average = response.Milestones[i].Average / float64(dataPointCount)
+		return true
+	})
+	for _, entry := range entries {
+		for _, measurement := range legacyMeasurements(entry.Heights, entry.Weights) {
+			measurement.Id = vbolt.NextIntId(tx, MeasurementBucket)
+			entry.Measurements = append(entry.Measurements, measurement)
 		}
+		entry.Heights, entry.Weights = nil, nil
+		if entry.Kind == trashLegacyWeight {
+			entry.Kind = TrashMeasurement
+		}
+		vbolt.Write(tx, TrashBucket, entry.Id, &entry)
+		records++
 	}
+	return
+}
 
-	context.w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(context.w).Encode(response)
-	if err != nil {
-		log.Printf("Error encoding response: %v", err)
-		http.Error(context.w, "Internal Server Error", http.StatusInternalServerError)
+// migrateMeasurementsToMetric converts values saved before units were
+// tracked, which were all imperial, to the metric unit they are now stored in.
+func migrateMeasurementsToMetric(tx *vbolt.Tx) (records int) {
+	convert := func(measurement *Measurement) bool {
+		if measurement.EntryUnit != "" {
+			return false
+		}
+		unit := measurement.Kind.Info().Imperial
+		measurement.Value = unit.ToCanonical(measurement.Value)
+		measurement.EntryUnit = unit.Symbol
+		return true
 	}
+	return convertMeasurementsTx(tx, convert)
 }
-func weightTableApi(context ResponseContext) {
-	milestones := []float64{0, 1.0 / 12, 2.0 / 12, 3.0 / 12,
-		4.0 / 12, 5.0 / 12, 6.0 / 12, 7.0 / 12, 8.0 / 12,
-		9.0 / 12, 10.0 / 12, 11.0 / 12, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13}
-	personIDs := context.r.URL.Query()["ids"]
-	var response MilestoneResponse
 
-	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
-		people := getAllPeopleMap(tx)
-		for _, personId := range personIDs {
-			id, _ := strconv.Atoi(personId)
-			response.People = append(response.People, people[id])
+// migrateDateOnlyTemperatures marks the temperatures saved before they were
+// recorded with a time, which sit at midnight UTC, as having only a day
+func migrateDateOnlyTemperatures(tx *vbolt.Tx) int {
+	return convertMeasurementsTx(tx, func(measurement *Measurement) bool {
+		if !measurement.HasTime() || !measurement.Date.Equal(measurement.Date.UTC().Truncate(24*time.Hour)) {
+			return false
 		}
+		measurement.DateOnly = true
+		return true
 	})
+}
 
-	response.Milestones = make([]MilestoneAges, 0, len(milestones))
-	for i := range milestones {
-		response.Milestones = append(response.Milestones, MilestoneAges{
-			MilestoneAge: milestones[i],
-			Values:       make([]float64, len(personIDs)),
-		})
-	}
-	for i := range personIDs {
-		personId, _ := strconv.Atoi(personIDs[i])
-		personWeights := QueryWeights(personId)
-		personMilestones := getWeightMilestones(personWeights, milestones)
-		for j := range personMilestones {
-			response.Milestones[j].Values[i] = personMilestones[j]
-			response.Milestones[j].Average += personMilestones[j]
+// convertMeasurementsTx rewrites every measurement, including the ones in
+// the trash, that convert changes
+func convertMeasurementsTx(tx *vbolt.Tx, convert func(measurement *Measurement) bool) (records int) {
+	var measurements []Measurement
+	vbolt.IterateAll(tx, MeasurementBucket, func(key int, value Measurement) bool {
+		measurements = append(measurements, value)
+		return true
+	})
+	for _, measurement := range measurements {
+		if convert(&measurement) {
+			vbolt.Write(tx, MeasurementBucket, measurement.Id, &measurement)
+			records++
 		}
 	}
 
-	for i := range milestones {
-		dataPointCount := 0
-		for j := range personIDs {
-			if response.Milestones[i].Values[j] > 0 {
-				dataPointCount++
+	var entries []TrashEntry
+	vbolt.IterateAll(tx, TrashBucket, func(key int, value TrashEntry) bool {
+		entries = append(entries, value)
+		return true
+	})
+	for _, entry := range entries {
+		changed := false
+		for i := range entry.Measurements {
+			if convert(&entry.Measurements[i]) {
+				changed = true
 			}
 		}
-		if dataPointCount > 0 {
-			response.Milestones[i].Average = response.Milestones[i].Average / float64(dataPointCount)
+		if changed {
+			vbolt.Write(tx, TrashBucket, entry.Id, &entry)
+			records++
 		}
 	}
-
-	context.w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(context.w).Encode(response)
-	if err != nil {
-		log.Printf("Error encoding response: %v", err)
-		http.Error(context.w, "Internal Server Error", http.StatusInternalServerError)
-	}
+	return
 }
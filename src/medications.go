@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+// MedicationConcentration is a liquid a medication is sold as, Mg per Ml
+type MedicationConcentration struct {
+	Label string
+	Mg    float64
+	Ml    float64
+}
+
+// MedicationInfo is a row of the dosing table. A single dose is MgPerKg of
+// body weight up to MaxDoseMg; doses are at least MinHours apart, at most
+// MaxDoses in any 24 hours, and add up to no more than MaxDailyMgPerKg (up to
+// MaxDailyMg) a day.
+type MedicationInfo struct {
+	Slug            string
+	Label           string
+	MgPerKg         float64
+	MaxDoseMg       float64
+	MinHours        float64
+	MaxDoses        int
+	MaxDailyMgPerKg float64
+	MaxDailyMg      float64
+	MinAgeMonths    int
+	Concentrations  []MedicationConcentration
+}
+
+// medicationTable holds the usual over-the-counter pediatric doses. Set
+// MEDICATION_TABLE to a JSON file of MedicationInfo rows to replace it, for
+// example with the doses a pediatrician gave.
+var medicationTable = []MedicationInfo{
+	{
+		Slug: "acetaminophen", Label: "Acetaminophen (Tylenol)",
+		MgPerKg: 15, MaxDoseMg: 1000, MinHours: 4, MaxDoses: 5, MaxDailyMgPerKg: 75, MaxDailyMg: 4000,
+		Concentrations: []MedicationConcentration{
+			{Label: "Infants' or children's liquid, 160 mg/5 mL", Mg: 160, Ml: 5},
+		},
+	},
+	{
+		Slug: "ibuprofen", Label: "Ibuprofen (Advil, Motrin)",
+		MgPerKg: 10, MaxDoseMg: 400, MinHours: 6, MaxDoses: 4, MaxDailyMgPerKg: 40, MaxDailyMg: 1200, MinAgeMonths: 6,
+		Concentrations: []MedicationConcentration{
+			{Label: "Infants' drops, 50 mg/1.25 mL", Mg: 50, Ml: 1.25},
+			{Label: "Children's liquid, 100 mg/5 mL", Mg: 100, Ml: 5},
+		},
+	},
+}
+
+// loadMedicationTable replaces the dosing table with the rows in a JSON file
+func loadMedicationTable(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var table []MedicationInfo
+	if err := json.Unmarshal(content, &table); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, info := range table {
+		if info.Slug == "" || info.MgPerKg <= 0 || info.MinHours <= 0 {
+			return fmt.Errorf("%s: %q needs a Slug, MgPerKg and MinHours", path, info.Label)
+		}
+	}
+	medicationTable = table
+	return nil
+}
+
+func findMedication(slug string) (MedicationInfo, bool) {
+	for _, info := range medicationTable {
+		if info.Slug == slug {
+			return info, true
+		}
+	}
+	return MedicationInfo{}, false
+}
+
+// MedicationDose is one dose given. Medication is a table slug, or the
+// name typed in for anything not in the table.
+type MedicationDose struct {
+	Id         int
+	PersonId   int
+	Medication string
+	Amount     float64
+	Unit       string
+	Time       time.Time
+	GivenBy    string
+	Notes      string
+}
+
+func (dose MedicationDose) Label() string {
+	if info, found := findMedication(dose.Medication); found {
+		return info.Label
+	}
+	return dose.Medication
+}
+
+func PackMedicationDose(self *MedicationDose, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.String(&self.Medication, buf)
+	vpack.Float64(&self.Amount, buf)
+	vpack.String(&self.Unit, buf)
+	vpack.Time(&self.Time, buf)
+	vpack.String(&self.GivenBy, buf)
+	vpack.String(&self.Notes, buf)
+}
+
+var MedicationDoseBucket = vbolt.Bucket(&Info, "medication_doses", vpack.FInt, PackMedicationDose)
+
+// MedicationDoseIndex term: person id, priority: time given, target: dose id
+var MedicationDoseIndex = vbolt.IndexExt(&Info, "medication_doses_by", vpack.FInt, vpack.UnixTimeKey, vpack.FInt)
+
+func saveMedicationDoseTx(tx *vbolt.Tx, dose *MedicationDose) {
+	if dose.Id == 0 {
+		dose.Id = vbolt.NextIntId(tx, MedicationDoseBucket)
+	}
+	vbolt.Write(tx, MedicationDoseBucket, dose.Id, dose)
+	vbolt.SetTargetSingleTermExt(tx, MedicationDoseIndex, dose.Id, dose.Time, dose.PersonId)
+}
+
+func deleteMedicationDoseTx(tx *vbolt.Tx, doseId int) {
+	vbolt.Delete(tx, MedicationDoseBucket, doseId)
+	vbolt.SetTargetTermsPlain(tx, MedicationDoseIndex, doseId, nil)
+}
+
+// getPersonMedicationDosesTx returns a person's doses, newest first
+func getPersonMedicationDosesTx(tx *vbolt.Tx, personId int) (doses []MedicationDose) {
+	var ids []int
+	vbolt.ReadTermTargets(tx, MedicationDoseIndex, personId, &ids, vbolt.Window{})
+	vbolt.ReadSlice(tx, MedicationDoseBucket, ids, &doses)
+	sort.SliceStable(doses, func(i, j int) bool { return doses[i].Time.After(doses[j].Time) })
+	return
+}
+
+func trashMedicationDoseTx(tx *vbolt.Tx, dose MedicationDose, familyId int, userId int) {
+	deleteMedicationDoseTx(tx, dose.Id)
+	saveTrashEntry(tx, &TrashEntry{
+		FamilyId:        familyId,
+		Kind:            TrashMedicationDose,
+		Label:           dose.Label() + ", " + dose.Time.Format("Jan 2, 2006 3:04 PM"),
+		DeletedBy:       userId,
+		MedicationDoses: []MedicationDose{dose},
+	})
+}
+
+// latestWeightTx is the most recent weight on record, in kg
+func latestWeightTx(tx *vbolt.Tx, personId int) (weight Measurement, found bool) {
+	weights := queryMeasurementsTx(tx, personId, WeightKind)
+	for _, measurement := range weights {
+		if !found || measurement.Date.After(weight.Date) {
+			weight, found = measurement, true
+		}
+	}
+	return
+}
+
+// a weight older than this isn't used to work out a dose, as children
+// outgrow their doses quickly
+const maxDosingWeightAge = 92 * 24 * time.Hour
+
+// dosingWeight is the weight in kg to work out a dose at a time from, or 0
+// when there is none or it is out of date
+func dosingWeight(weight Measurement, found bool, at time.Time) float64 {
+	if !found || at.Sub(weight.Date) > maxDosingWeightAge {
+		return 0
+	}
+	return weight.Value
+}
+
+// DoseVolume is a dose measured out in one of the liquids
+type DoseVolume struct {
+	Label string
+	Ml    float64
+}
+
+// DosingAdvice is what the helper shows for one medication
+type DosingAdvice struct {
+	Medication MedicationInfo
+	DoseMg     float64 // the weight-based single dose; 0 without a weight
+	MaxDailyMg float64
+	Volumes    []DoseVolume
+	Doses24h   int
+	Mg24h      float64
+	Last       MedicationDose
+	NextAt     time.Time // earliest time the next dose may be given
+	TooYoung   bool
+}
+
+func (advice DosingAdvice) CanGiveNow() bool {
+	return !advice.TooYoung && !advice.NextAt.After(time.Now())
+}
+
+func (info MedicationInfo) doseLimits(weightKg float64) (single float64, daily float64) {
+	single, daily = info.MaxDoseMg, info.MaxDailyMg
+	if weightKg > 0 {
+		single = math.Min(info.MgPerKg*weightKg, info.MaxDoseMg)
+		daily = math.Min(info.MaxDailyMgPerKg*weightKg, info.MaxDailyMg)
+	}
+	return
+}
+
+// recentDoses keeps the doses of one medication in the 24 hours up to at,
+// oldest first
+func recentDoses(info MedicationInfo, doses []MedicationDose, at time.Time) (recent []MedicationDose) {
+	for _, dose := range doses {
+		if dose.Medication == info.Slug && !dose.Time.After(at) && at.Sub(dose.Time) < 24*time.Hour {
+			recent = append(recent, dose)
+		}
+	}
+	sort.SliceStable(recent, func(i, j int) bool { return recent[i].Time.Before(recent[j].Time) })
+	return
+}
+
+// dosingAdvice works out the dose for a weight and when the next one is
+// allowed. A dose has to wait for the minimum interval, and for enough of
+// the last 24 hours' doses to drop out of the window to keep under the
+// daily count and amount.
+func dosingAdvice(info MedicationInfo, weightKg float64, ageMonths float64, doses []MedicationDose, now time.Time) (advice DosingAdvice) {
+	advice.Medication = info
+	advice.TooYoung = ageMonths < float64(info.MinAgeMonths)
+	single, daily := info.doseLimits(weightKg)
+	advice.MaxDailyMg = daily
+	if weightKg > 0 {
+		advice.DoseMg = single
+		for _, concentration := range info.Concentrations {
+			advice.Volumes = append(advice.Volumes, DoseVolume{
+				Label: concentration.Label,
+				Ml:    math.Floor(single/concentration.Mg*concentration.Ml*10) / 10,
+			})
+		}
+	}
+
+	window := recentDoses(info, doses, now)
+	advice.Doses24h = len(window)
+	for _, dose := range window {
+		advice.Mg24h += dose.Amount
+	}
+	if len(window) == 0 {
+		return
+	}
+	advice.Last = window[len(window)-1]
+	advice.NextAt = advice.Last.Time.Add(time.Duration(info.MinHours * float64(time.Hour)))
+
+	given := advice.Mg24h
+	for len(window) > 0 && (len(window) >= info.MaxDoses || (daily > 0 && given+single > daily)) {
+		if freed := window[0].Time.Add(24 * time.Hour); freed.After(advice.NextAt) {
+			advice.NextAt = freed
+		}
+		given -= window[0].Amount
+		window = window[1:]
+	}
+	return
+}
+
+// checkMedicationDose lists the limits a new dose would break
+func checkMedicationDose(info MedicationInfo, weightKg float64, ageMonths float64, doses []MedicationDose, dose MedicationDose) (warnings []string) {
+	if ageMonths < float64(info.MinAgeMonths) {
+		warnings = append(warnings, fmt.Sprintf("%s isn't recommended under %d months without a doctor's advice", info.Label, info.MinAgeMonths))
+	}
+	single, daily := info.doseLimits(weightKg)
+	if weightKg <= 0 {
+		warnings = append(warnings, "there is no weight from the last 3 months, so the dose can't be checked against it")
+	}
+	if dose.Amount > single {
+		warnings = append(warnings, fmt.Sprintf("%g mg is more than the %g mg single dose", dose.Amount, math.Floor(single)))
+	}
+
+	var earlier []MedicationDose
+	for _, other := range doses {
+		if other.Id != dose.Id {
+			earlier = append(earlier, other)
+		}
+	}
+	window := recentDoses(info, earlier, dose.Time)
+	if len(window) > 0 {
+		last := window[len(window)-1]
+		last.Time = last.Time.In(dose.Time.Location())
+		if next := last.Time.Add(time.Duration(info.MinHours * float64(time.Hour))); dose.Time.Before(next) {
+			warnings = append(warnings, fmt.Sprintf("the last dose was at %s; the next is not due until %s", last.Time.Format("3:04 PM"), next.Format("3:04 PM")))
+		}
+	}
+	if len(window)+1 > info.MaxDoses {
+		warnings = append(warnings, fmt.Sprintf("this would be dose %d in 24 hours; the limit is %d", len(window)+1, info.MaxDoses))
+	}
+	total := dose.Amount
+	for _, other := range window {
+		total += other.Amount
+	}
+	if daily > 0 && total > daily {
+		warnings = append(warnings, fmt.Sprintf("this would make %g mg in 24 hours; the limit is %g mg", total, math.Floor(daily)))
+	}
+	return
+}
+
+func ageInMonths(person Person, at time.Time) float64 {
+	return at.Sub(person.Birthday).Hours() / (365.25 * 24) * 12
+}
+
+func RegisterMedicationPages(mux *http.ServeMux) {
+	mux.Handle("GET /medications/{id}", AuthHandler(ContextFunc(medicationsPage)))
+	mux.Handle("POST /medications/{id}", AuthHandler(ContextFunc(saveMedicationDose)))
+	mux.Handle("POST /medications/delete/{id}", AuthHandler(ContextFunc(deleteMedicationDose)))
+}
+
+// renderMedications shows the dosing helper and log; dose is the entry in
+// the form and warnings the limits it breaks, when it needs confirming
+func renderMedications(context ResponseContext, dose MedicationDose, form *Form, warnings []string) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	loc := requestLocation(context.r)
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person := getPerson(tx, personId)
+		if person.Id == 0 || !isFamilyMemberTx(tx, context, person.FamilyId) {
+			http.Error(context.w, "not a family member", http.StatusUnauthorized)
+			return
+		}
+		context.familyId = person.FamilyId
+
+		now := time.Now()
+		weight, hasWeight := latestWeightTx(tx, person.Id)
+		weightKg := dosingWeight(weight, hasWeight, now)
+		doses := getPersonMedicationDosesTx(tx, person.Id)
+		var advice []DosingAdvice
+		for _, info := range medicationTable {
+			entry := dosingAdvice(info, weightKg, ageInMonths(person, now), doses, now)
+			entry.NextAt = entry.NextAt.In(loc)
+			entry.Last.Time = entry.Last.Time.In(loc)
+			advice = append(advice, entry)
+		}
+		for i := range doses {
+			doses[i].Time = doses[i].Time.In(loc)
+		}
+		shown := []Measurement{weight}
+		setDisplayUnits(shown, preferredUnits(tx, context.user))
+
+		if dose.Time.IsZero() {
+			dose.Time = now
+			dose.Unit = "mg"
+			dose.GivenBy = strings.TrimSpace(context.user.FirstName + " " + context.user.LastName)
+		}
+		dose.Time = dose.Time.In(loc)
+		var errors map[string]string
+		if form != nil {
+			errors = form.Errors
+		}
+		RenderTemplateWithData(context, "medications", map[string]any{
+			"Person":      person,
+			"Weight":      shown[0],
+			"HasWeight":   hasWeight,
+			"StaleWeight": hasWeight && weightKg == 0,
+			"Advice":      advice,
+			"Medications": medicationTable,
+			"Doses":       doses,
+			"Dose":        dose,
+			"Errors":      errors,
+			"Warnings":    warnings,
+		})
+	})
+}
+
+func medicationsPage(context ResponseContext) {
+	renderMedications(context, MedicationDose{}, nil, nil)
+}
+
+// saveMedicationDose logs a dose. A dose over a limit is shown back with
+// the reasons and only saved once confirmed.
+func saveMedicationDose(context ResponseContext) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	form := bindForm(context.r)
+	dose := MedicationDose{
+		PersonId:   personId,
+		Medication: form.String("medication", "Medication", true),
+		Amount:     form.Float("amount", "Amount", true),
+		Unit:       form.String("unit", "Unit", true),
+		Time:       form.DateTime("time", "Time given", true, requestLocation(context.r)),
+		GivenBy:    form.String("givenBy", "Given by", false),
+		Notes:      form.String("notes", "Notes", false),
+	}
+	if dose.Medication == "other" {
+		dose.Medication = form.String("otherName", "Medication name", true)
+	}
+	info, inTable := findMedication(dose.Medication)
+	if inTable && dose.Unit != "mg" {
+		form.Fail("unit", "Enter %s in mg; the helper lists how many mL that is", info.Label)
+	}
+	if form.Errors["amount"] == "" && dose.Amount <= 0 {
+		form.Fail("amount", "Amount must be more than 0")
+	}
+
+	var warnings []string
+	var isOwner bool
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		person := getPerson(tx, personId)
+		isOwner = person.Id > 0 && isFamilyOwner(tx, person.FamilyId, context.user.Id)
+		if !isOwner {
+			return
+		}
+		form.DuringLife("time", "Time given", dose.Time, person)
+		if !form.Valid() {
+			return
+		}
+		if inTable && !form.Checked("confirmLimits") {
+			weight, hasWeight := latestWeightTx(tx, person.Id)
+			warnings = checkMedicationDose(info, dosingWeight(weight, hasWeight, dose.Time), ageInMonths(person, dose.Time), getPersonMedicationDosesTx(tx, person.Id), dose)
+			if len(warnings) > 0 {
+				return
+			}
+		}
+		saveMedicationDoseTx(tx, &dose)
+		vbolt.TxCommit(tx)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+	if !form.Valid() || len(warnings) > 0 {
+		renderMedications(context, dose, form, warnings)
+		return
+	}
+
+	http.Redirect(context.w, context.r, fmt.Sprintf("/medications/%d", personId), http.StatusFound)
+}
+
+func deleteMedicationDose(context ResponseContext) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	var dose MedicationDose
+	var isOwner bool
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		vbolt.Read(tx, MedicationDoseBucket, id, &dose)
+		person := getPerson(tx, dose.PersonId)
+		isOwner = dose.Id > 0 && isFamilyOwner(tx, person.FamilyId, context.user.Id)
+		if !isOwner {
+			return
+		}
+		trashMedicationDoseTx(tx, dose, person.FamilyId, context.user.Id)
+		vbolt.TxCommit(tx)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+
+	http.Redirect(context.w, context.r, fmt.Sprintf("/medications/%d", dose.PersonId), http.StatusFound)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDosingAdvice(t *testing.T) {
+	ibuprofen, _ := findMedication("ibuprofen")
+	now := time.Date(2026, 2, 3, 20, 0, 0, 0, time.UTC)
+	doses := []MedicationDose{
+		{Id: 1, Medication: "ibuprofen", Amount: 120, Time: now.Add(-20 * time.Hour)},
+		{Id: 2, Medication: "ibuprofen", Amount: 120, Time: now.Add(-14 * time.Hour)},
+		{Id: 3, Medication: "ibuprofen", Amount: 120, Time: now.Add(-8 * time.Hour)},
+		{Id: 4, Medication: "ibuprofen", Amount: 120, Time: now.Add(-6 * time.Hour)},
+		{Id: 5, Medication: "acetaminophen", Amount: 180, Time: now.Add(-1 * time.Hour)},
+	}
+	advice := dosingAdvice(ibuprofen, 12, 30, doses, now)
+	if advice.DoseMg != 120 || advice.MaxDailyMg != 480 || advice.Doses24h != 4 {
+		t.Fatalf("unexpected advice: %+v", advice)
+	}
+	if len(advice.Volumes) != 2 || advice.Volumes[1].Ml != 6 {
+		t.Errorf("unexpected volumes: %+v", advice.Volumes)
+	}
+	// six hours have passed, but four doses in 24 hours is the limit, so the
+	// next waits for the first to drop out of the window
+	if want := now.Add(4 * time.Hour); !advice.NextAt.Equal(want) {
+		t.Errorf("expected the next dose at %v, got %v", want, advice.NextAt)
+	}
+
+	if young := dosingAdvice(ibuprofen, 6, 4, nil, now); !young.TooYoung || young.CanGiveNow() {
+		t.Errorf("expected ibuprofen to be flagged under 6 months: %+v", young)
+	}
+}
+
+func TestCheckMedicationDose(t *testing.T) {
+	acetaminophen, _ := findMedication("acetaminophen")
+	now := time.Date(2026, 2, 3, 20, 0, 0, 0, time.UTC)
+	doses := []MedicationDose{{Id: 1, Medication: "acetaminophen", Amount: 160, Time: now.Add(-2 * time.Hour)}}
+
+	dose := MedicationDose{Medication: "acetaminophen", Amount: 240, Time: now}
+	warnings := checkMedicationDose(acetaminophen, 12, 30, doses, dose)
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "single dose") || !strings.Contains(warnings[1], "not due until") {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+
+	dose = MedicationDose{Medication: "acetaminophen", Amount: 160, Time: now.Add(3 * time.Hour)}
+	if warnings := checkMedicationDose(acetaminophen, 12, 30, doses, dose); len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %q", warnings)
+	}
+}
+
+func TestDosingWeight(t *testing.T) {
+	now := time.Date(2026, 2, 3, 20, 0, 0, 0, time.UTC)
+	weight := Measurement{Kind: WeightKind, Value: 12, Date: now.AddDate(0, -2, 0)}
+	if kg := dosingWeight(weight, true, now); kg != 12 {
+		t.Errorf("expected a two month old weight to be used, got %g", kg)
+	}
+	weight.Date = now.AddDate(0, -4, 0)
+	if kg := dosingWeight(weight, true, now); kg != 0 {
+		t.Errorf("expected a four month old weight to be ignored, got %g", kg)
+	}
+	if kg := dosingWeight(Measurement{}, false, now); kg != 0 {
+		t.Errorf("expected no weight, got %g", kg)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+// Migration is one schema step. Steps run once, in order, at startup through
+// vbolt.ApplyDBProcess; Run must be safe to repeat because a dry run executes
+// it in a transaction that is then rolled back.
+type Migration struct {
+	Name        string
+	Description string
+	Run         func(tx *vbolt.Tx) (records int)
+}
+
+// append only; never rename or reorder an entry that has shipped
+var migrations = []Migration{
+	{
+		Name:        "2025-0307-reset-user",
+		Description: "wipe users, passwords and emails",
+		Run: func(tx *vbolt.Tx) int {
+			for _, name := range []string{UsersBucket.Name, PasswordBucket.Name, EmailBucket.Name} {
+				tx.DeleteBucket([]byte(name))
+				tx.CreateBucket([]byte(name))
+			}
+			return 0
+		},
+	},
+	{
+		Name:        "2026-1019-person-v2",
+		Description: "rewrite people as version 2 with pronouns",
+		Run: func(tx *vbolt.Tx) int {
+			return rewriteBucket(tx, PersonBucket)
+		},
+	},
+	{
+		Name:        "2026-1019-family-v2",
+		Description: "rewrite families as version 2 with relationship labels",
+		Run: func(tx *vbolt.Tx) int {
+			return rewriteBucket(tx, FamilyBucket)
+		},
+	},
+	{
+		Name:        "2026-1019-rebuild-indexes",
+		Description: "rebuild person, family, measurement and milestone indexes",
+		Run: func(tx *vbolt.Tx) (records int) {
+			records += rebuildIndex(tx, PersonBucket, updatePersonIndex)
+			records += rebuildIndex(tx, FamilyBucket, updateFamilyIndex)
+			records += rebuildIndex(tx, PersonHeightBucket, updateIndex)
+			records += rebuildIndex(tx, PersonWeightsBucket, updateWeightIndex)
+			records += rebuildIndex(tx, MilestoneBucket, updateMilestoneIndex)
+			return
+		},
+	},
+	{
+		Name:        "2026-1019-generic-measurements",
+		Description: "copy heights and weights, including trashed ones, into the measurements bucket",
+		Run:         migrateLegacyMeasurements,
+	},
+	{
+		Name:        "2026-1019-measurements-metric",
+		Description: "convert imperial measurement values to metric and record the entry unit",
+		Run:         migrateMeasurementsToMetric,
+	},
+	{
+		Name:        "2026-1019-milestone-catalog",
+		Description: "move height and weight milestones into measurements and store milestone ages",
+		Run:         migrateMilestoneCatalog,
+	},
+	{
+		Name:        "2026-1019-backfill-growth-alerts",
+		Description: "analyse everyone's existing measurements for growth alerts",
+		Run: func(tx *vbolt.Tx) (records int) {
+			var personIds []int
+			vbolt.IterateAll(tx, PersonBucket, func(key int, value Person) bool {
+				personIds = append(personIds, key)
+				return true
+			})
+			for _, personId := range personIds {
+				records += len(refreshGrowthAlertsTx(tx, personId))
+			}
+			return
+		},
+	},
+	{
+		Name:        "2026-1019-unify-users",
+		Description: "convert users written by backend/ and move their hashes from passwd to password",
+		Run:         migrateBackendUsers,
+	},
+	{
+		Name:        "2026-1019-date-only-temperatures",
+		Description: "mark temperatures saved with only a day so they are not shifted into the evening before",
+		Run:         migrateDateOnlyTemperatures,
+	},
+}
+
+type MigrationRecord struct {
+	Name      string
+	AppliedAt time.Time
+	Records   int
+}
+
+func PackMigrationRecord(self *MigrationRecord, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.String(&self.Name, buf)
+	vpack.Time(&self.AppliedAt, buf)
+	vpack.Int(&self.Records, buf)
+}
+
+// migration name => when it ran; a zero time means it ran before this was tracked
+var MigrationBucket = vbolt.Bucket(&Info, "migrations", vpack.StringZ, PackMigrationRecord)
+
+// rewriteBucket reads and writes back every record so it is stored with the
+// current version of its pack function.
+func rewriteBucket[K comparable, T any](tx *vbolt.Tx, bucket *vbolt.BucketInfo[K, T]) int {
+	var keys []K
+	var values []T
+	vbolt.IterateAll(tx, bucket, func(key K, value T) bool {
+		keys = append(keys, key)
+		values = append(values, value)
+		return true
+	})
+	for i := range keys {
+		vbolt.Write(tx, bucket, keys[i], &values[i])
+	}
+	return len(keys)
+}
+
+// rebuildIndex resets the index terms of every record in a bucket. Stale
+// entries for records that no longer exist are left to the integrity check.
+func rebuildIndex[K comparable, T any](tx *vbolt.Tx, bucket *vbolt.BucketInfo[K, T], update func(tx *vbolt.Tx, entry T)) int {
+	var values []T
+	vbolt.IterateAll(tx, bucket, func(key K, value T) bool {
+		values = append(values, value)
+		return true
+	})
+	for _, value := range values {
+		update(tx, value)
+	}
+	return len(values)
+}
+
+func runMigrations(db *vbolt.DB) {
+	for _, migration := range migrations {
+		vbolt.ApplyDBProcess(db, migration.Name, func() {
+			vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+				record := MigrationRecord{Name: migration.Name, AppliedAt: time.Now()}
+				record.Records = migration.Run(tx)
+				vbolt.Write(tx, MigrationBucket, record.Name, &record)
+				vbolt.TxCommit(tx)
+			})
+		})
+	}
+
+	// anything ApplyDBProcess skipped ran before the registry existed
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		for _, migration := range migrations {
+			if !vbolt.HasKey(tx, MigrationBucket, migration.Name) {
+				record := MigrationRecord{Name: migration.Name}
+				vbolt.Write(tx, MigrationBucket, record.Name, &record)
+			}
+		}
+		vbolt.TxCommit(tx)
+	})
+}
+
+// dryRunMigration runs a step and rolls it back, reporting how many records it
+// would touch.
+func dryRunMigration(db *vbolt.DB, migration Migration) (records int) {
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		records = migration.Run(tx)
+	})
+	return
+}
+
+func findMigration(name string) (Migration, bool) {
+	for _, migration := range migrations {
+		if migration.Name == name {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+type MigrationStatus struct {
+	Migration Migration
+	Record    MigrationRecord
+	DryRun    int
+	HasDryRun bool
+}
+
+func RegisterMigrationPages(mux *http.ServeMux) {
+	mux.Handle("GET /admin/migrations", AdminHandler(ContextFunc(migrationsAdminPage)))
+	mux.Handle("POST /admin/migrations/dry-run/{name}", AdminHandler(ContextFunc(dryRunMigrationPage)))
+}
+
+func migrationStatuses(tx *vbolt.Tx) (statuses []MigrationStatus) {
+	for _, migration := range migrations {
+		status := MigrationStatus{Migration: migration}
+		vbolt.Read(tx, MigrationBucket, migration.Name, &status.Record)
+		statuses = append(statuses, status)
+	}
+	return
+}
+
+func migrationsAdminPage(context ResponseContext) {
+	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
+		RenderAdminTemplateWithData(context, "migrations", map[string]any{
+			"Migrations": migrationStatuses(tx),
+		})
+	})
+}
+
+func dryRunMigrationPage(context ResponseContext) {
+	migration, found := findMigration(context.r.PathValue("name"))
+	if !found {
+		http.Error(context.w, "unknown migration", http.StatusNotFound)
+		return
+	}
+	records := dryRunMigration(db, migration)
+
+	var statuses []MigrationStatus
+	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
+		statuses = migrationStatuses(tx)
+	})
+	for i := range statuses {
+		if statuses[i].Migration.Name == migration.Name {
+			statuses[i].DryRun = records
+			statuses[i].HasDryRun = true
+		}
+	}
+	RenderAdminTemplateWithData(context, "migrations", map[string]any{
+		"Migrations": statuses,
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"go.hasen.dev/vbolt"
+)
+
+func TestMigrations(t *testing.T) {
+	testDBPath := "test_migrations.db"
+	testDb := vbolt.Open(testDBPath)
+	vbolt.InitBuckets(testDb, &Info)
+	defer os.Remove(testDBPath)
+	defer testDb.Close()
+
+	person := Person{Id: 1, FamilyId: 1, Name: "Maia", Birthday: time.Date(2019, 3, 12, 0, 0, 0, 0, time.UTC)}
+	height := PersonHeight{Id: 1, PersonId: 1, Inches: 20, Date: person.Birthday}
+	vbolt.WithWriteTx(testDb, func(tx *vbolt.Tx) {
+		vbolt.Write(tx, PersonBucket, person.Id, &person)
+		vbolt.Write(tx, PersonHeightBucket, height.Id, &height)
+		vbolt.TxCommit(tx)
+	})
+
+	runMigrations(testDb)
+	runMigrations(testDb)
+
+	vbolt.WithReadTx(testDb, func(tx *vbolt.Tx) {
+		for _, migration := range migrations {
+			var record MigrationRecord
+			vbolt.Read(tx, MigrationBucket, migration.Name, &record)
+			if record.Name != migration.Name || record.AppliedAt.IsZero() {
+				t.Fatalf("migration not recorded: %s", migration.Name)
+			}
+		}
+
+		var heightIds []int
+		vbolt.ReadTermTargets(tx, PersonHeightIdx, person.Id, &heightIds, vbolt.Window{})
+		if len(heightIds) != 1 || heightIds[0] != height.Id {
+			t.Fatalf("height index not rebuilt: %v", heightIds)
+		}
+
+		measurements := queryMeasurementsTx(tx, person.Id, HeightKind)
+		if len(measurements) != 1 || measurements[0].Value != UnitInches.ToCanonical(height.Inches) || measurements[0].EntryUnit != "in" {
+			t.Fatalf("height not copied into measurements: %+v", measurements)
+		}
+	})
+
+	migration, _ := findMigration("2026-1019-person-v2")
+	if records := dryRunMigration(testDb, migration); records != 1 {
+		t.Fatalf("expected dry run to touch 1 record, got %d", records)
+	}
+}
+
+func TestMigrateMilestoneCatalog(t *testing.T) {
+	testDBPath := "test_milestone_catalog.db"
+	testDb := vbolt.Open(testDBPath)
+	vbolt.InitBuckets(testDb, &Info)
+	defer os.Remove(testDBPath)
+	defer testDb.Close()
+
+	person := Person{Id: 1, FamilyId: 1, Name: "Maia", Birthday: time.Date(2019, 3, 12, 0, 0, 0, 0, time.UTC)}
+	date := person.Birthday.AddDate(1, 0, 0)
+	height := Milestone{PersonId: 1, Type: MilestoneHeight, NumericValue: 30, Unit: "inches", Date: date}
+	weight := Milestone{PersonId: 1, Type: MilestoneWeight, NumericValue: 22, Unit: "lb", Date: date}
+	unreadable := Milestone{PersonId: 1, Type: MilestoneHeight, NumericValue: 7, Unit: "hands", Date: date}
+	walking := Milestone{PersonId: 1, Type: MilestoneWalking, Date: date}
+	vbolt.WithWriteTx(testDb, func(tx *vbolt.Tx) {
+		vbolt.Write(tx, PersonBucket, person.Id, &person)
+		for _, milestone := range []*Milestone{&height, &weight, &unreadable, &walking} {
+			saveMilestoneTx(tx, milestone)
+		}
+		if records := migrateMilestoneCatalog(tx); records != 4 {
+			t.Errorf("expected 4 records, got %d", records)
+		}
+		vbolt.TxCommit(tx)
+	})
+
+	vbolt.WithReadTx(testDb, func(tx *vbolt.Tx) {
+		heights := queryMeasurementsTx(tx, person.Id, HeightKind)
+		if len(heights) != 1 || heights[0].EntryUnit != "in" || heights[0].Value != UnitInches.ToCanonical(30) {
+			t.Errorf("height milestone not moved into measurements: %+v", heights)
+		}
+		weights := queryMeasurementsTx(tx, person.Id, WeightKind)
+		if len(weights) != 1 || weights[0].EntryUnit != "lb" || weights[0].Value != UnitPounds.ToCanonical(22) {
+			t.Errorf("weight milestone not moved into measurements: %+v", weights)
+		}
+		if getMilestoneTx(tx, height.Id).Id != 0 || getMilestoneTx(tx, weight.Id).Id != 0 {
+			t.Error("expected the moved milestones to be deleted")
+		}
+		// a unit that can't be read stays a milestone; every one left has its age
+		if kept := getMilestoneTx(tx, unreadable.Id); kept.Id == 0 || kept.Age != person.AgeAt(date) {
+			t.Errorf("expected the unreadable height to stay with its age: %+v", kept)
+		}
+		if kept := getMilestoneTx(tx, walking.Id); kept.Age != person.AgeAt(date) {
+			t.Errorf("expected the walking milestone's age to be stored: %+v", kept)
+		}
+	})
+}
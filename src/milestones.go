@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -11,6 +13,46 @@ import (
 	"go.hasen.dev/vpack"
 )
 
+type MilestoneCategory int
+
+const (
+	CategoryMotor MilestoneCategory = iota
+	CategoryLanguage
+	CategorySocial
+	CategoryCognitive
+	CategoryOther
+)
+
+var milestoneCategories = []MilestoneCategory{CategoryMotor, CategoryLanguage, CategorySocial, CategoryCognitive, CategoryOther}
+
+func parseMilestoneCategoryLabel(category MilestoneCategory) string {
+	switch category {
+	case CategoryMotor:
+		return "motor"
+	case CategoryLanguage:
+		return "language"
+	case CategorySocial:
+		return "social"
+	case CategoryCognitive:
+		return "cognitive"
+	case CategoryOther:
+		return "other"
+	default:
+		return ""
+	}
+}
+
+func parseMilestoneCategory(s string) (MilestoneCategory, error) {
+	for _, category := range milestoneCategories {
+		if parseMilestoneCategoryLabel(category) == s {
+			return category, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown milestone category: %s", s)
+}
+
+// MilestoneType is a built-in type below customMilestoneTypeStart, and a
+// family's own type from there on
 type MilestoneType int
 
 const (
@@ -19,40 +61,119 @@ const (
 	MilestoneCrawling
 	MilestoneWalking
 	MilestoneFirstWord
+	MilestoneRollingOver
+	MilestoneSitting
+	MilestoneFirstSmile
+	MilestoneWaving
+	MilestoneBabbling
+	MilestonePhrases
+	MilestonePincerGrasp
+	MilestonePeekaboo
+	MilestoneChecklistItem
+
+	customMilestoneTypeStart MilestoneType = 1000
 )
 
-func parseMilestoneTypeLabel(t MilestoneType) string {
-	switch t {
-	case MilestoneHeight:
-		return "height"
-	case MilestoneWeight:
-		return "weight"
-	case MilestoneCrawling:
-		return "crawling"
-	case MilestoneWalking:
-		return "walking"
-	case MilestoneFirstWord:
-		return "first_word"
-	default:
-		return ""
+// MilestoneTypeInfo is an entry of the milestone catalog. Numeric types carry
+// a value and unit instead of a description; retired types are still shown on
+// old milestones but can't be picked for new ones.
+type MilestoneTypeInfo struct {
+	Id       MilestoneType
+	FamilyId int // 0 for built-in types
+	Label    string
+	Category MilestoneCategory
+	Numeric  bool
+	Retired  bool
+}
+
+// indexed by MilestoneType; append new types at the end. Heights and weights
+// are measurements now and were moved there by the milestone catalog migration.
+var builtinMilestoneTypes = []MilestoneTypeInfo{
+	{Id: MilestoneHeight, Label: "Height", Category: CategoryOther, Numeric: true, Retired: true},
+	{Id: MilestoneWeight, Label: "Weight", Category: CategoryOther, Numeric: true, Retired: true},
+	{Id: MilestoneCrawling, Label: "Crawling", Category: CategoryMotor},
+	{Id: MilestoneWalking, Label: "Walking", Category: CategoryMotor},
+	{Id: MilestoneFirstWord, Label: "First word", Category: CategoryLanguage},
+	{Id: MilestoneRollingOver, Label: "Rolling over", Category: CategoryMotor},
+	{Id: MilestoneSitting, Label: "Sitting without support", Category: CategoryMotor},
+	{Id: MilestoneFirstSmile, Label: "First social smile", Category: CategorySocial},
+	{Id: MilestoneWaving, Label: "Waving bye-bye", Category: CategorySocial},
+	{Id: MilestoneBabbling, Label: "Babbling", Category: CategoryLanguage},
+	{Id: MilestonePhrases, Label: "Two-word phrases", Category: CategoryLanguage},
+	{Id: MilestonePincerGrasp, Label: "Pincer grasp", Category: CategoryMotor},
+	{Id: MilestonePeekaboo, Label: "Playing peekaboo", Category: CategoryCognitive},
+	{Id: MilestoneChecklistItem, Label: "Checklist item", Category: CategoryOther, Retired: true},
+}
+
+func PackMilestoneTypeInfo(self *MilestoneTypeInfo, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.IntEnum(&self.Id, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.String(&self.Label, buf)
+	vpack.IntEnum(&self.Category, buf)
+}
+
+// MilestoneTypeBucket holds the families' own types
+var MilestoneTypeBucket = vbolt.Bucket(&Info, "milestone_types", vpack.FInt, PackMilestoneTypeInfo)
+
+// MilestoneTypeIndex term: family id, target: milestone type id
+var MilestoneTypeIndex = vbolt.Index(&Info, "milestone_types_by", vpack.FInt, vpack.FInt)
+
+func getMilestoneTypeTx(tx *vbolt.Tx, id MilestoneType) (info MilestoneTypeInfo, found bool) {
+	if id >= 0 && int(id) < len(builtinMilestoneTypes) {
+		return builtinMilestoneTypes[id], true
 	}
+	if id < customMilestoneTypeStart {
+		return
+	}
+	found = vbolt.Read(tx, MilestoneTypeBucket, int(id), &info)
+	return
 }
 
-func parseMilestoneType(s string) (MilestoneType, error) {
-	switch s {
-	case "height":
-		return MilestoneHeight, nil
-	case "weight":
-		return MilestoneWeight, nil
-	case "crawling":
-		return MilestoneCrawling, nil
-	case "walking":
-		return MilestoneWalking, nil
-	case "first_word":
-		return MilestoneFirstWord, nil
-	default:
-		return 0, fmt.Errorf("unknown milestone type: %s", s)
+func getFamilyMilestoneTypesTx(tx *vbolt.Tx, familyId int) (types []MilestoneTypeInfo) {
+	var ids []int
+	vbolt.ReadTermTargets(tx, MilestoneTypeIndex, familyId, &ids, vbolt.Window{})
+	vbolt.ReadSlice(tx, MilestoneTypeBucket, ids, &types)
+	sort.Slice(types, func(i, j int) bool { return types[i].Label < types[j].Label })
+	return
+}
+
+func saveMilestoneTypeTx(tx *vbolt.Tx, info *MilestoneTypeInfo) {
+	if info.Id == 0 {
+		info.Id = customMilestoneTypeStart + MilestoneType(vbolt.NextIntId(tx, MilestoneTypeBucket))
+	}
+	vbolt.Write(tx, MilestoneTypeBucket, int(info.Id), info)
+	vbolt.SetTargetTermsPlain(tx, MilestoneTypeIndex, int(info.Id), []int{info.FamilyId})
+}
+
+func deleteMilestoneTypeTx(tx *vbolt.Tx, id MilestoneType) {
+	vbolt.Delete(tx, MilestoneTypeBucket, int(id))
+	vbolt.SetTargetTermsPlain(tx, MilestoneTypeIndex, int(id), nil)
+}
+
+// MilestoneTypeGroup is one category of the catalog, as offered in a form
+type MilestoneTypeGroup struct {
+	Category MilestoneCategory
+	Types    []MilestoneTypeInfo
+}
+
+// milestoneTypeGroupsTx lists the types a family can pick, by category.
+// current is kept even when retired, so editing an old milestone keeps its type.
+func milestoneTypeGroupsTx(tx *vbolt.Tx, familyId int, current MilestoneType) (groups []MilestoneTypeGroup) {
+	types := append([]MilestoneTypeInfo{}, builtinMilestoneTypes...)
+	types = append(types, getFamilyMilestoneTypesTx(tx, familyId)...)
+	for _, category := range milestoneCategories {
+		group := MilestoneTypeGroup{Category: category}
+		for _, info := range types {
+			if info.Category == category && (!info.Retired || info.Id == current) {
+				group.Types = append(group.Types, info)
+			}
+		}
+		if len(group.Types) > 0 {
+			groups = append(groups, group)
+		}
 	}
+	return
 }
 
 type Milestone struct {
@@ -60,15 +181,20 @@ type Milestone struct {
 	PersonId     int
 	Type         MilestoneType
 	Date         time.Time
-	Age          float64
+	Age          float64 // years, corrected for prematurity
 	NumericValue float64
 	Unit         string
 	TextValue    string
 	Notes        string
+	Checklist    string // id of the checklist item it was checked from
+
+	PersonName string
+	TypeLabel  string
+	Category   MilestoneCategory
 }
 
 func PackMilestone(self *Milestone, buf *vpack.Buffer) {
-	vpack.Version(1, buf)
+	version := vpack.Version(2, buf)
 	vpack.Int(&self.Id, buf)
 	vpack.Int(&self.PersonId, buf)
 	vpack.IntEnum(&self.Type, buf)
@@ -78,6 +204,9 @@ func PackMilestone(self *Milestone, buf *vpack.Buffer) {
 	vpack.String(&self.Unit, buf)
 	vpack.String(&self.TextValue, buf)
 	vpack.String(&self.Notes, buf)
+	if version >= 2 {
+		vpack.String(&self.Checklist, buf)
+	}
 }
 
 var MilestoneBucket = vbolt.Bucket(&Info, "personMilestones", vpack.FInt, PackMilestone)
@@ -95,22 +224,123 @@ func updateMilestoneIndex(tx *vbolt.Tx, entry Milestone) {
 	)
 }
 
+func saveMilestoneTx(tx *vbolt.Tx, entry *Milestone) {
+	if entry.Id == 0 {
+		entry.Id = vbolt.NextIntId(tx, MilestoneBucket)
+	}
+	vbolt.Write(tx, MilestoneBucket, entry.Id, entry)
+	updateMilestoneIndex(tx, *entry)
+}
+
+func getMilestoneTx(tx *vbolt.Tx, id int) (milestone Milestone) {
+	vbolt.Read(tx, MilestoneBucket, id, &milestone)
+	return
+}
+
+// prepMilestone fills in the fields that are worked out rather than stored.
+// The age is recomputed so it follows a corrected birthday or due date.
+func prepMilestone(tx *vbolt.Tx, milestone *Milestone, person Person) {
+	milestone.Age = person.AgeAt(milestone.Date)
+	milestone.PersonName = person.Name
+	if item, found := findChecklistItem(milestone.Checklist); found {
+		milestone.TypeLabel = item.Label
+		milestone.Category = item.Category
+	} else if info, found := getMilestoneTypeTx(tx, milestone.Type); found {
+		milestone.TypeLabel = info.Label
+		milestone.Category = info.Category
+	} else {
+		milestone.TypeLabel = "Unknown"
+		milestone.Category = CategoryOther
+	}
+}
+
+func queryMilestonesTx(tx *vbolt.Tx, personId int) (milestones []Milestone) {
+	person := getPerson(tx, personId)
+	var milestoneIds []int
+	vbolt.ReadTermTargets(tx, MilestoneIndex, personId, &milestoneIds, vbolt.Window{})
+	vbolt.ReadSlice(tx, MilestoneBucket, milestoneIds, &milestones)
+	for i := range milestones {
+		prepMilestone(tx, &milestones[i], person)
+	}
+	return
+}
+
 func QueryMilestones(personId int) (milestones []Milestone) {
 	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
-		var window vbolt.Window
-		var milestoneIds []int
-		vbolt.ReadTermTargets(tx, MilestoneIndex, personId, &milestoneIds, window)
-		vbolt.ReadSlice(tx, MilestoneBucket, milestoneIds, &milestones)
+		milestones = queryMilestonesTx(tx, personId)
 	})
 	return
 }
 
+// migrateMilestoneCatalog moves height and weight milestones whose unit can be
+// read into the measurements bucket, and stores the age of the rest.
+func migrateMilestoneCatalog(tx *vbolt.Tx) (records int) {
+	var milestones []Milestone
+	vbolt.IterateAll(tx, MilestoneBucket, func(key int, value Milestone) bool {
+		milestones = append(milestones, value)
+		return true
+	})
+	for _, milestone := range milestones {
+		if milestone.Type == MilestoneHeight || milestone.Type == MilestoneWeight {
+			kind := HeightKind
+			if milestone.Type == MilestoneWeight {
+				kind = WeightKind
+			}
+			info := kind.Info()
+			if unit, found := parseCSVUnit(info, milestone.Unit); found {
+				measurement := Measurement{PersonId: milestone.PersonId, Kind: kind, Value: unit.ToCanonical(milestone.NumericValue), EntryUnit: unit.Symbol, Date: milestone.Date}
+				if info.Validate(measurement.Value, unit) == nil {
+					saveMeasurementTx(tx, &measurement)
+					deleteMilestoneTx(tx, milestone.Id)
+					records++
+					continue
+				}
+			}
+		}
+		milestone.Age = getPerson(tx, milestone.PersonId).AgeAt(milestone.Date)
+		vbolt.Write(tx, MilestoneBucket, milestone.Id, &milestone)
+		records++
+	}
+	return
+}
+
+func trashMilestoneTx(tx *vbolt.Tx, milestone Milestone, familyId int, userId int) {
+	deleteMilestoneTx(tx, milestone.Id)
+	saveTrashEntry(tx, &TrashEntry{
+		FamilyId:   familyId,
+		Kind:       TrashMilestone,
+		Label:      milestone.TypeLabel + ", " + milestone.Date.Format("Jan 2, 2006"),
+		DeletedBy:  userId,
+		Milestones: []Milestone{milestone},
+	})
+}
+
 func RegisterMilestonesPages(mux *http.ServeMux) {
+	mux.Handle("GET /milestones", AuthHandler(ContextFunc(familyMilestonesPage)))
 	mux.Handle("GET /milestones/{id}", PublicHandler(ContextFunc(milestonesPage)))
 	mux.Handle("GET /milestones/add", AuthHandler(ContextFunc(addMilestonesPage)))
 	mux.Handle("GET /milestones/edit/{id}", AuthHandler(ContextFunc(editMilestonesPage)))
-	mux.Handle("GET /milestones/delete/{id}", AuthHandler(ContextFunc(deleteMilestone)))
+	mux.Handle("GET /milestones/delete/{id}", AuthHandler(ContextFunc(deleteMilestonePage)))
+	mux.Handle("POST /milestones/delete/{id}", AuthHandler(ContextFunc(deleteMilestone)))
 	mux.Handle("POST /milestones/add", AuthHandler(ContextFunc(saveMilestone)))
+	mux.Handle("GET /milestones/types", AuthHandler(ContextFunc(milestoneTypesPage)))
+	mux.Handle("POST /milestones/types/add", AuthHandler(ContextFunc(addMilestoneType)))
+	mux.Handle("POST /milestones/types/delete/{id}", AuthHandler(ContextFunc(deleteMilestoneType)))
+}
+
+// familyMilestonesPage shows every milestone in the viewer's family, newest first
+func familyMilestonesPage(context ResponseContext) {
+	context.familyId = context.user.PrimaryFamilyId
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		var milestones []Milestone
+		for _, person := range getPeopleInFamily(tx, context.familyId) {
+			milestones = append(milestones, queryMilestonesTx(tx, person.Id)...)
+		}
+		sort.SliceStable(milestones, func(i, j int) bool { return milestones[i].Date.After(milestones[j].Date) })
+		RenderTemplateWithData(context, "milestones", map[string]any{
+			"Milestones": milestones,
+		})
+	})
 }
 
 func milestonesPage(context ResponseContext) {
@@ -119,38 +349,154 @@ func milestonesPage(context ResponseContext) {
 	if err != nil {
 		idVal = 1
 	}
-	RenderTemplateWithData(context, "milestones", map[string]interface{}{
-		"Milestones": QueryMilestones(idVal),
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person := getPerson(tx, idVal)
+		context.familyId = person.FamilyId
+		RenderTemplateWithData(context, "milestones", map[string]interface{}{
+			"Person":     person,
+			"Milestones": queryMilestonesTx(tx, idVal),
+		})
+	})
+}
+
+// renderMilestoneForm shows the add/edit form; newType is the type being
+// added along with the milestone, when there is one
+func renderMilestoneForm(context ResponseContext, tx *vbolt.Tx, familyId int, milestone Milestone, newType *MilestoneTypeInfo, errors map[string]string) {
+	RenderTemplateWithData(context, "milestones-add", map[string]interface{}{
+		"People":     getPeopleInFamily(tx, familyId),
+		"TypeGroups": milestoneTypeGroupsTx(tx, familyId, milestone.Type),
+		"Categories": milestoneCategories,
+		"Milestone":  milestone,
+		"NewType":    newType,
+		"Errors":     errors,
 	})
 }
 
 func addMilestonesPage(context ResponseContext) {
+	personId, _ := strconv.Atoi(context.r.URL.Query().Get("personId"))
 	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
-		RenderTemplateWithData(context, "milestones-add", map[string]interface{}{
-			"People": getPeopleInFamily(tx, context.user.PrimaryFamilyId),
-		})
+		renderMilestoneForm(context, tx, context.user.PrimaryFamilyId, Milestone{PersonId: personId, Type: MilestoneCrawling}, nil, nil)
 	})
 }
 
 func editMilestonesPage(context ResponseContext) {
-	RenderTemplate(context, "milestones-add")
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		milestone := getMilestoneTx(tx, id)
+		person := getPerson(tx, milestone.PersonId)
+		if milestone.Id == 0 || !isFamilyOwner(tx, person.FamilyId, context.user.Id) {
+			http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+			return
+		}
+		renderMilestoneForm(context, tx, person.FamilyId, milestone, nil, nil)
+	})
+}
+
+func deleteMilestonePage(context ResponseContext) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		milestone := getMilestoneTx(tx, id)
+		person := getPerson(tx, milestone.PersonId)
+		if milestone.Id == 0 || !isFamilyOwner(tx, person.FamilyId, context.user.Id) {
+			http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+			return
+		}
+		context.familyId = person.FamilyId
+		prepMilestone(tx, &milestone, person)
+		RenderTemplateWithData(context, "milestone-delete", map[string]any{
+			"Person":    person,
+			"Milestone": milestone,
+		})
+	})
 }
 
 func deleteMilestone(context ResponseContext) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	var milestone Milestone
+	var isOwner bool
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		milestone = getMilestoneTx(tx, id)
+		person := getPerson(tx, milestone.PersonId)
+		isOwner = milestone.Id > 0 && isFamilyOwner(tx, person.FamilyId, context.user.Id)
+		if !isOwner {
+			return
+		}
+		prepMilestone(tx, &milestone, person)
+		trashMilestoneTx(tx, milestone, person.FamilyId, context.user.Id)
+		vbolt.TxCommit(tx)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+
+	http.Redirect(context.w, context.r, fmt.Sprintf("/milestones/%d", milestone.PersonId), http.StatusFound)
 }
 
 func saveMilestone(context ResponseContext) {
-	context.r.ParseForm()
-	measureDate := context.r.FormValue("measureDate")
-	numericValue, _ := strconv.ParseFloat(context.r.FormValue("numericValue"), 64)
-	personId, _ := strconv.Atoi(context.r.FormValue("personId"))
-	id, _ := strconv.Atoi(context.r.FormValue("id"))
-	milestoneType, _ := parseMilestoneType(context.r.FormValue("milestoneType"))
-	unit := context.r.FormValue("unit")
-	textValue := context.r.FormValue("textValue")
+	form := bindForm(context.r)
+	measureDateTime := form.Date("measureDate", "Milestone date", true)
+	personId := form.Int("personId", "Person", true)
+	id := form.Int("id", "Id", false)
 	notes := context.r.FormValue("notes")
 
-	measureDateTime, _ := time.Parse("2006-01-02", measureDate)
+	// "new" adds a type to the family's catalog along with the milestone
+	isNewType := form.Value("milestoneType") == "new"
+	var newType MilestoneTypeInfo
+	var milestoneType MilestoneType
+	if isNewType {
+		newType.Label = form.String("newTypeLabel", "New milestone name", true)
+		category, err := parseMilestoneCategory(form.Value("newTypeCategory"))
+		if err != nil {
+			form.Fail("newTypeCategory", "%s", err.Error())
+		}
+		newType.Category = category
+	} else {
+		milestoneType = MilestoneType(form.Int("milestoneType", "Milestone type", true))
+	}
+
+	var person Person
+	var previous Milestone
+	var typeInfo MilestoneTypeInfo
+	var typeFound, isOwner bool
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person = getPerson(tx, personId)
+		isOwner = isFamilyOwner(tx, person.FamilyId, context.user.Id)
+		if id > 0 {
+			previous = getMilestoneTx(tx, id)
+			isOwner = isOwner && isFamilyOwner(tx, getPerson(tx, previous.PersonId).FamilyId, context.user.Id)
+		}
+		typeInfo, typeFound = getMilestoneTypeTx(tx, milestoneType)
+	})
+	if person.Id == 0 {
+		form.Fail("personId", "Person is required")
+	} else if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+	form.DuringLife("measureDate", "Milestone date", measureDateTime, person)
+
+	if !isNewType {
+		switch {
+		case !typeFound || (typeInfo.FamilyId != 0 && typeInfo.FamilyId != person.FamilyId):
+			form.Fail("milestoneType", "unknown milestone type")
+		case typeInfo.Retired && previous.Type != milestoneType:
+			form.Fail("milestoneType", "%s is recorded as a measurement now", typeInfo.Label)
+		}
+	}
+
+	// numeric types carry a number, the others a description
+	var numericValue float64
+	var unit, textValue string
+	if typeInfo.Numeric && !isNewType {
+		numericValue = form.Float("numericValue", "Value", true)
+		unit = form.String("unit", "Unit", true)
+		if numericValue < 0 {
+			form.Fail("numericValue", "Value can't be negative")
+		}
+	} else {
+		textValue = form.String("textValue", "Description", true)
+	}
 
 	entry := Milestone{
 		Id:           id,
@@ -161,16 +507,108 @@ func saveMilestone(context ResponseContext) {
 		Unit:         unit,
 		TextValue:    textValue,
 		Notes:        notes,
-		Age:          0,
+		Age:          person.AgeAt(measureDateTime),
+	}
+	// a milestone checked off a checklist stays linked while its type is kept
+	if previous.Type == milestoneType {
+		entry.Checklist = previous.Checklist
+	}
+	if !form.Valid() {
+		familyId := person.FamilyId
+		if familyId == 0 {
+			familyId = context.user.PrimaryFamilyId
+		}
+		var chosen *MilestoneTypeInfo
+		if isNewType {
+			chosen = &newType
+			entry.Type = -1
+		}
+		vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+			renderMilestoneForm(context, tx, familyId, entry, chosen, form.Errors)
+		})
+		return
+	}
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		if isNewType {
+			newType.FamilyId = person.FamilyId
+			saveMilestoneTypeTx(tx, &newType)
+			entry.Type = newType.Id
+		}
+		saveMilestoneTx(tx, &entry)
+		vbolt.TxCommit(tx)
+	})
+
+	http.Redirect(context.w, context.r, fmt.Sprintf("/milestones/%d", entry.PersonId), http.StatusFound)
+}
+
+// milestoneTypeUsesTx counts the milestones of each type
+func milestoneTypeUsesTx(tx *vbolt.Tx) map[MilestoneType]int {
+	uses := make(map[MilestoneType]int)
+	vbolt.IterateAll(tx, MilestoneBucket, func(key int, value Milestone) bool {
+		uses[value.Type]++
+		return true
+	})
+	return uses
+}
+
+func milestoneTypesPage(context ResponseContext) {
+	context.familyId = context.user.PrimaryFamilyId
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		RenderTemplateWithData(context, "milestones-types", map[string]any{
+			"TypeGroups":  milestoneTypeGroupsTx(tx, 0, 0),
+			"CustomTypes": getFamilyMilestoneTypesTx(tx, context.familyId),
+			"Uses":        milestoneTypeUsesTx(tx),
+			"Categories":  milestoneCategories,
+		})
+	})
+}
+
+func addMilestoneType(context ResponseContext) {
+	label := strings.TrimSpace(context.r.FormValue("label"))
+	category, err := parseMilestoneCategory(context.r.FormValue("category"))
+	if label == "" || err != nil {
+		http.Error(context.w, "a milestone type needs a name and a category", http.StatusBadRequest)
+		return
 	}
+	familyId := context.user.PrimaryFamilyId
+	var isOwner bool
 	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
-		if entry.Id == 0 {
-			entry.Id = vbolt.NextIntId(tx, MilestoneBucket)
+		isOwner = isFamilyOwner(tx, familyId, context.user.Id)
+		if !isOwner {
+			return
 		}
-		vbolt.Write(tx, MilestoneBucket, entry.Id, &entry)
-		updateMilestoneIndex(tx, entry)
+		saveMilestoneTypeTx(tx, &MilestoneTypeInfo{FamilyId: familyId, Label: label, Category: category})
 		vbolt.TxCommit(tx)
 	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+
+	http.Redirect(context.w, context.r, "/milestones/types", http.StatusFound)
+}
+
+// deleteMilestoneType removes a family's own type once no milestone uses it
+func deleteMilestoneType(context ResponseContext) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	var status int
+	var message string
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		info, found := getMilestoneTypeTx(tx, MilestoneType(id))
+		switch {
+		case !found || info.FamilyId == 0 || !isFamilyOwner(tx, info.FamilyId, context.user.Id):
+			status, message = http.StatusUnauthorized, "not a family owner"
+		case milestoneTypeUsesTx(tx)[info.Id] > 0:
+			status, message = http.StatusBadRequest, "milestones of this type still exist; change or delete them first"
+		default:
+			deleteMilestoneTypeTx(tx, info.Id)
+			vbolt.TxCommit(tx)
+		}
+	})
+	if status != 0 {
+		http.Error(context.w, message, status)
+		return
+	}
 
-	http.Redirect(context.w, context.r, "/milestones", http.StatusFound)
+	http.Redirect(context.w, context.r, "/milestones/types", http.StatusFound)
 }
@@ -87,7 +87,10 @@ func deletePost(context ResponseContext) {
 	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
 		id := context.r.PathValue("id")
 		idVal, _ := strconv.Atoi(id)
-		vbolt.Delete(tx, PostBucket, idVal)
+		post := getPost(tx, idVal)
+		if post.Id > 0 {
+			trashPostTx(tx, post, context.user.Id)
+		}
 		vbolt.TxCommit(tx)
 	})
 
@@ -95,18 +98,20 @@ func deletePost(context ResponseContext) {
 }
 
 func savePost(context ResponseContext) {
-	context.r.ParseForm()
-	entryDate := context.r.FormValue("entryDate")
-	personId, _ := strconv.Atoi(context.r.FormValue("personId"))
+	form := bindForm(context.r)
+	entryDateTime := form.Date("entryDate", "Entry date", true)
+	form.NotFuture("entryDate", "Entry date", entryDateTime)
+	personId := form.Int("personId", "Person", true)
 	content := context.r.FormValue("quill-content")
-	id, _ := strconv.Atoi(context.r.FormValue("id"))
-
-	entryDateTime, _ := time.Parse("2006-01-02", entryDate)
+	id := form.Int("id", "Id", false)
 
 	var person Person
 	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
 		person = getPerson(tx, personId)
 	})
+	if person.Id == 0 {
+		form.Fail("personId", "Person is required")
+	}
 
 	entry := Post{
 		Id:        id,
@@ -115,6 +120,16 @@ func savePost(context ResponseContext) {
 		EntryDate: entryDateTime,
 		Content:   content,
 	}
+	if !form.Valid() {
+		vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+			RenderTemplateWithData(context, "posts-add", map[string]interface{}{
+				"People": getPeopleInFamily(tx, context.user.PrimaryFamilyId),
+				"Post":   entry,
+				"Errors": form.Errors,
+			})
+		})
+		return
+	}
 
 	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
 		if entry.Id == 0 {
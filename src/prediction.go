@@ -0,0 +1,243 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+type PredictionMethod int
+
+const (
+	MidParentalHeight PredictionMethod = iota
+	PercentileProjection
+)
+
+func (method PredictionMethod) Label() string {
+	switch method {
+	case MidParentalHeight:
+		return "Mid-parental target"
+	case PercentileProjection:
+		return "Percentile projection"
+	default:
+		return ""
+	}
+}
+
+const (
+	// adult height is read off the growth references at 20 years
+	adultAgeMonths = 240
+	// mid-parental height: half the sex difference between adults, and the
+	// usual range around the target
+	midParentalSexOffsetCm = 6.5
+	midParentalRangeCm     = 8.5
+	// a child's percentile drifts; the projection range is this many z-scores either side
+	projectionRangeZ = 0.5
+)
+
+// HeightPrediction is an adult height estimate, in cm, as it stood on Date
+type HeightPrediction struct {
+	Id       int
+	PersonId int
+	Method   PredictionMethod
+	Date     time.Time
+	Value    float64
+	Low      float64
+	High     float64
+}
+
+func PackHeightPrediction(self *HeightPrediction, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.IntEnum(&self.Method, buf)
+	vpack.Time(&self.Date, buf)
+	vpack.Float64(&self.Value, buf)
+	vpack.Float64(&self.Low, buf)
+	vpack.Float64(&self.High, buf)
+}
+
+var HeightPredictionBucket = vbolt.Bucket(&Info, "height_predictions", vpack.FInt, PackHeightPrediction)
+
+// HeightPredictionIndex term: person id, priority: date, target: prediction id
+var HeightPredictionIndex = vbolt.IndexExt(&Info, "height_predictions_by", vpack.FInt, vpack.UnixTimeKey, vpack.FInt)
+
+// latestHeight is the most recent height of a person in cm, or 0
+func latestHeight(heights []Measurement) float64 {
+	if len(heights) == 0 {
+		return 0
+	}
+	return heights[len(heights)-1].Value
+}
+
+// midParentalPrediction averages the parents' heights and shifts it by half
+// the difference between adult men and women. It needs a mother, a father and
+// a child of known sex.
+func midParentalPrediction(child Person, motherCm float64, fatherCm float64) (prediction HeightPrediction, found bool) {
+	if motherCm <= 0 || fatherCm <= 0 {
+		return
+	}
+	target := (motherCm + fatherCm) / 2
+	switch child.Gender {
+	case Male:
+		target += midParentalSexOffsetCm
+	case Female:
+		target -= midParentalSexOffsetCm
+	default:
+		return
+	}
+	return HeightPrediction{
+		PersonId: child.Id,
+		Method:   MidParentalHeight,
+		Value:    target,
+		Low:      target - midParentalRangeCm,
+		High:     target + midParentalRangeCm,
+	}, true
+}
+
+// percentileProjection carries the z-score of the latest height with a
+// percentile forward to adulthood.
+func percentileProjection(child Person, heights []Measurement) (prediction HeightPrediction, found bool) {
+	var latest Measurement
+	for _, height := range heights {
+		if height.HasPercentile {
+			latest = height
+		}
+	}
+	if !latest.HasPercentile {
+		return
+	}
+	lms, found := ageLMS(growthReferences[HeightKind], child.Gender, adultAgeMonths)
+	if !found {
+		return
+	}
+	return HeightPrediction{
+		PersonId: child.Id,
+		Method:   PercentileProjection,
+		Value:    lms.ValueAt(latest.ZScore),
+		Low:      lms.ValueAt(latest.ZScore - projectionRangeZ),
+		High:     lms.ValueAt(latest.ZScore + projectionRangeZ),
+	}, true
+}
+
+// predictAdultHeightTx works out the current predictions for a child from
+// their heights and the latest heights of the parents in their family.
+func predictAdultHeightTx(tx *vbolt.Tx, child Person) (predictions []HeightPrediction) {
+	if child.Type != Child {
+		return
+	}
+	var motherCm, fatherCm float64
+	for _, person := range getPeopleInFamily(tx, child.FamilyId) {
+		if person.Type != Parent {
+			continue
+		}
+		height := latestHeight(queryMeasurementsTx(tx, person.Id, HeightKind))
+		switch person.Gender {
+		case Female:
+			motherCm = height
+		case Male:
+			fatherCm = height
+		}
+	}
+	if prediction, found := midParentalPrediction(child, motherCm, fatherCm); found {
+		predictions = append(predictions, prediction)
+	}
+	if prediction, found := percentileProjection(child, queryMeasurementsTx(tx, child.Id, HeightKind)); found {
+		predictions = append(predictions, prediction)
+	}
+	return
+}
+
+func getHeightPredictionsTx(tx *vbolt.Tx, personId int) (predictions []HeightPrediction) {
+	var ids []int
+	vbolt.ReadTermTargets(tx, HeightPredictionIndex, personId, &ids, vbolt.Window{})
+	vbolt.ReadSlice(tx, HeightPredictionBucket, ids, &predictions)
+	return
+}
+
+func saveHeightPredictionTx(tx *vbolt.Tx, prediction *HeightPrediction) {
+	if prediction.Id == 0 {
+		prediction.Id = vbolt.NextIntId(tx, HeightPredictionBucket)
+	}
+	vbolt.Write(tx, HeightPredictionBucket, prediction.Id, prediction)
+	vbolt.SetTargetSingleTermExt(tx, HeightPredictionIndex, prediction.Id, prediction.Date, prediction.PersonId)
+}
+
+func deleteHeightPredictionsTx(tx *vbolt.Tx, personId int) {
+	for _, prediction := range getHeightPredictionsTx(tx, personId) {
+		vbolt.Delete(tx, HeightPredictionBucket, prediction.Id)
+		vbolt.SetTargetTermsPlain(tx, HeightPredictionIndex, prediction.Id, nil)
+	}
+}
+
+// recordHeightPredictionsTx stores the current predictions of every child in a
+// family when they changed. A parent's height moves their children's targets,
+// so the whole family is looked at; a second change on the same day replaces
+// the first.
+func recordHeightPredictionsTx(tx *vbolt.Tx, familyId int) {
+	today := time.Now().Truncate(24 * time.Hour)
+	for _, person := range getPeopleInFamily(tx, familyId) {
+		latest := make(map[PredictionMethod]HeightPrediction)
+		for _, stored := range getHeightPredictionsTx(tx, person.Id) {
+			latest[stored.Method] = stored
+		}
+		for _, prediction := range predictAdultHeightTx(tx, person) {
+			previous, found := latest[prediction.Method]
+			if found && math.Abs(previous.Value-prediction.Value) < 0.05 && math.Abs(previous.High-prediction.High) < 0.05 {
+				continue
+			}
+			if found && previous.Date.Equal(today) {
+				prediction.Id = previous.Id
+			}
+			prediction.Date = today
+			saveHeightPredictionTx(tx, &prediction)
+		}
+	}
+}
+
+// PredictionRange is a prediction converted for display
+type PredictionRange struct {
+	Method string
+	Date   time.Time
+	Age    float64 // where it sits on the chart, in years
+	Value  float64
+	Low    float64
+	High   float64
+}
+
+func predictionRanges(predictions []HeightPrediction, unit Unit) (ranges []PredictionRange) {
+	for _, prediction := range predictions {
+		ranges = append(ranges, PredictionRange{
+			Method: prediction.Method.Label(),
+			Date:   prediction.Date,
+			Age:    adultAgeMonths / 12,
+			Value:  unit.FromCanonical(prediction.Value),
+			Low:    unit.FromCanonical(prediction.Low),
+			High:   unit.FromCanonical(prediction.High),
+		})
+	}
+	return
+}
+
+func RegisterHeightPredictionPages(mux *http.ServeMux) {
+	mux.Handle("GET /predictions/{id}", PublicHandler(ContextFunc(heightPredictionPage)))
+}
+
+func heightPredictionPage(context ResponseContext) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	unit := HeightKind.Info().Unit(requestUnits(context))
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person := getPerson(tx, personId)
+		RenderTemplateWithData(context, "predictions", map[string]any{
+			"Person":  person,
+			"Unit":    unit,
+			"Current": predictionRanges(predictAdultHeightTx(tx, person), unit),
+			"History": predictionRanges(getHeightPredictionsTx(tx, personId), unit),
+		})
+	})
+}
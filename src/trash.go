@@ -0,0 +1,407 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+const TrashRetention = 30 * 24 * time.Hour
+
+type TrashKind int
+
+const (
+	TrashPost TrashKind = iota
+	TrashPerson
+	TrashMeasurement
+	trashLegacyWeight // folded into TrashMeasurement by the generic measurements migration
+	TrashImage
+	TrashMilestone
+	TrashVaccination
+	TrashVisit
+	TrashMedicationDose
+	TrashIllnessEpisode
+)
+
+func parseTrashKindLabel(kind TrashKind) string {
+	switch kind {
+	case TrashPost:
+		return "post"
+	case TrashPerson:
+		return "person"
+	case TrashMeasurement:
+		return "measurement"
+	case TrashImage:
+		return "picture"
+	case TrashMilestone:
+		return "milestone"
+	case TrashVaccination:
+		return "vaccination"
+	case TrashVisit:
+		return "visit"
+	case TrashMedicationDose:
+		return "medication dose"
+	case TrashIllnessEpisode:
+		return "illness"
+	default:
+		return ""
+	}
+}
+
+// TrashEntry is a tombstone holding every record removed by one delete, so a
+// restore can put them back exactly as they were. Deleting a person moves the
+// person and all their dependents into a single entry.
+type TrashEntry struct {
+	Id        int
+	FamilyId  int
+	Kind      TrashKind
+	Label     string
+	DeletedBy int
+	DeletedAt time.Time
+
+	Person       Person
+	Measurements []Measurement
+	Milestones   []Milestone
+	Posts        []Post
+	Vaccinations []Vaccination
+	Visits       []Visit
+	Image        Image
+	Attachments  []Image // documents attached to the visits
+
+	MedicationDoses []MedicationDose
+	IllnessEpisodes []IllnessEpisode
+
+	HeightPredictions []HeightPrediction // the person's prediction history
+
+	// profile picture owners, so restoring a picture puts it back in place
+	ImagePersonId int
+	ImageFamilyId int
+
+	// version 1 only; moved into Measurements by the generic measurements migration
+	Heights []PersonHeight
+	Weights []PersonWeight
+}
+
+func (entry TrashEntry) ExpiresAt() time.Time {
+	return entry.DeletedAt.Add(TrashRetention)
+}
+
+func PackTrashEntry(self *TrashEntry, buf *vpack.Buffer) {
+	version := vpack.Version(7, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.FamilyId, buf)
+	vpack.IntEnum(&self.Kind, buf)
+	vpack.String(&self.Label, buf)
+	vpack.Int(&self.DeletedBy, buf)
+	vpack.Time(&self.DeletedAt, buf)
+	PackPerson(&self.Person, buf)
+	if version < 2 {
+		vpack.Slice(&self.Heights, PackPersonHeight, buf)
+		vpack.Slice(&self.Weights, PackPersonWeight, buf)
+	}
+	vpack.Slice(&self.Milestones, PackMilestone, buf)
+	vpack.Slice(&self.Posts, PackPost, buf)
+	PackImage(&self.Image, buf)
+	vpack.Int(&self.ImagePersonId, buf)
+	vpack.Int(&self.ImageFamilyId, buf)
+	if version >= 2 {
+		vpack.Slice(&self.Measurements, PackMeasurement, buf)
+	}
+	if version >= 3 {
+		vpack.Slice(&self.Vaccinations, PackVaccination, buf)
+	}
+	if version >= 4 {
+		vpack.Slice(&self.Visits, PackVisit, buf)
+		vpack.Slice(&self.Attachments, PackImage, buf)
+	}
+	if version >= 5 {
+		vpack.Slice(&self.MedicationDoses, PackMedicationDose, buf)
+	}
+	if version >= 6 {
+		vpack.Slice(&self.IllnessEpisodes, PackIllnessEpisode, buf)
+	}
+	if version >= 7 {
+		vpack.Slice(&self.HeightPredictions, PackHeightPrediction, buf)
+	}
+}
+
+var TrashBucket = vbolt.Bucket(&Info, "trash", vpack.FInt, PackTrashEntry)
+
+// TrashIndex term: family id, priority: deleted at, target: trash entry id
+var TrashIndex = vbolt.IndexExt(&Info, "trash_by", vpack.FInt, vpack.UnixTimeKey, vpack.FInt)
+
+func saveTrashEntry(tx *vbolt.Tx, entry *TrashEntry) {
+	entry.Id = vbolt.NextIntId(tx, TrashBucket)
+	entry.DeletedAt = time.Now()
+	vbolt.Write(tx, TrashBucket, entry.Id, entry)
+	vbolt.SetTargetSingleTermExt(tx, TrashIndex, entry.Id, entry.DeletedAt, entry.FamilyId)
+}
+
+func deleteTrashEntry(tx *vbolt.Tx, entryId int) {
+	vbolt.Delete(tx, TrashBucket, entryId)
+	vbolt.SetTargetTermsPlain(tx, TrashIndex, entryId, nil)
+}
+
+func getTrashForFamily(tx *vbolt.Tx, familyId int) (entries []TrashEntry) {
+	var entryIds []int
+	vbolt.ReadTermTargets(tx, TrashIndex, familyId, &entryIds, vbolt.Window{})
+	vbolt.ReadSlice(tx, TrashBucket, entryIds, &entries)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+	return
+}
+
+func trashPostTx(tx *vbolt.Tx, post Post, userId int) {
+	vbolt.Delete(tx, PostBucket, post.Id)
+	saveTrashEntry(tx, &TrashEntry{
+		FamilyId:  post.FamilyId,
+		Kind:      TrashPost,
+		Label:     post.EntryDate.Format("Jan 2, 2006"),
+		DeletedBy: userId,
+		Posts:     []Post{post},
+	})
+}
+
+func trashMeasurementTx(tx *vbolt.Tx, measurement Measurement, familyId int, userId int) {
+	deleteMeasurementTx(tx, measurement.Id)
+	refreshGrowthAlertsTx(tx, measurement.PersonId)
+	saveTrashEntry(tx, &TrashEntry{
+		FamilyId:     familyId,
+		Kind:         TrashMeasurement,
+		Label:        measurement.Kind.Info().Label + ", " + measurement.Date.Format("Jan 2, 2006"),
+		DeletedBy:    userId,
+		Measurements: []Measurement{measurement},
+	})
+}
+
+// trashImageTx removes a picture record; the files stay in uploads/ until the
+// entry is purged. personId or familyId is the profile the picture belonged to.
+func trashImageTx(tx *vbolt.Tx, imageId int, personId int, familyId int, userId int) {
+	var image Image
+	vbolt.Read(tx, ImageBucket, imageId, &image)
+	if image.Id == 0 {
+		return
+	}
+	vbolt.Delete(tx, ImageBucket, image.Id)
+	saveTrashEntry(tx, &TrashEntry{
+		FamilyId:      image.FamilyId,
+		Kind:          TrashImage,
+		Label:         image.Filename,
+		DeletedBy:     userId,
+		Image:         image,
+		ImagePersonId: personId,
+		ImageFamilyId: familyId,
+	})
+}
+
+var ErrRestoreMissingPerson = errors.New("the person this belongs to is gone; restore them first")
+var ErrRestoreExpired = errors.New("this was deleted too long ago to restore")
+
+// restoreTrashEntryTx writes every record in the entry back, along with their
+// index entries, and removes the tombstone. Entries past their expiry are
+// waiting for the purge and can't come back.
+func restoreTrashEntryTx(tx *vbolt.Tx, entry TrashEntry, userId int) error {
+	if time.Now().After(entry.ExpiresAt()) {
+		return ErrRestoreExpired
+	}
+	personExists := func(personId int) bool {
+		return personId == entry.Person.Id || vbolt.HasKey(tx, PersonBucket, personId)
+	}
+	for _, measurement := range entry.Measurements {
+		if !personExists(measurement.PersonId) {
+			return ErrRestoreMissingPerson
+		}
+	}
+	for _, milestone := range entry.Milestones {
+		if !personExists(milestone.PersonId) {
+			return ErrRestoreMissingPerson
+		}
+	}
+	for _, vaccination := range entry.Vaccinations {
+		if !personExists(vaccination.PersonId) {
+			return ErrRestoreMissingPerson
+		}
+	}
+	for _, visit := range entry.Visits {
+		if !personExists(visit.PersonId) {
+			return ErrRestoreMissingPerson
+		}
+	}
+	for _, dose := range entry.MedicationDoses {
+		if !personExists(dose.PersonId) {
+			return ErrRestoreMissingPerson
+		}
+	}
+	for _, episode := range entry.IllnessEpisodes {
+		if !personExists(episode.PersonId) {
+			return ErrRestoreMissingPerson
+		}
+	}
+	if entry.ImagePersonId > 0 && !personExists(entry.ImagePersonId) {
+		return ErrRestoreMissingPerson
+	}
+
+	if entry.Person.Id > 0 {
+		vbolt.Write(tx, PersonBucket, entry.Person.Id, &entry.Person)
+		updatePersonIndex(tx, entry.Person)
+	}
+	for _, measurement := range entry.Measurements {
+		saveMeasurementTx(tx, &measurement)
+	}
+	for _, milestone := range entry.Milestones {
+		saveMilestoneTx(tx, &milestone)
+	}
+	for _, post := range entry.Posts {
+		SavePost(tx, &post)
+	}
+	for _, vaccination := range entry.Vaccinations {
+		saveVaccinationTx(tx, &vaccination)
+	}
+	for _, visit := range entry.Visits {
+		saveVisitTx(tx, &visit)
+	}
+	for _, attachment := range entry.Attachments {
+		SaveImage(tx, &attachment)
+	}
+	for _, dose := range entry.MedicationDoses {
+		saveMedicationDoseTx(tx, &dose)
+	}
+	for _, episode := range entry.IllnessEpisodes {
+		saveIllnessEpisodeTx(tx, &episode)
+	}
+	for _, prediction := range entry.HeightPredictions {
+		saveHeightPredictionTx(tx, &prediction)
+	}
+	if entry.Image.Id > 0 {
+		SaveImage(tx, &entry.Image)
+	}
+
+	// a restored profile picture replaces the current one, which goes to the trash
+	if entry.ImagePersonId > 0 {
+		person := getPerson(tx, entry.ImagePersonId)
+		if person.ImageId > 0 && person.ImageId != entry.Image.Id {
+			trashImageTx(tx, person.ImageId, person.Id, 0, userId)
+		}
+		person.ImageId = entry.Image.Id
+		vbolt.Write(tx, PersonBucket, person.Id, &person)
+	}
+	if entry.ImageFamilyId > 0 {
+		family := getFamily(tx, entry.ImageFamilyId)
+		if family.ImageId > 0 && family.ImageId != entry.Image.Id {
+			trashImageTx(tx, family.ImageId, 0, family.Id, userId)
+		}
+		family.ImageId = entry.Image.Id
+		vbolt.Write(tx, FamilyBucket, family.Id, &family)
+	}
+
+	// restored measurements can raise or settle growth alerts
+	personIds := make(map[int]bool)
+	for _, measurement := range entry.Measurements {
+		personIds[measurement.PersonId] = true
+	}
+	for personId := range personIds {
+		refreshGrowthAlertsTx(tx, personId)
+	}
+	// a parent's height is part of their children's predicted heights
+	if entry.Person.Id > 0 && entry.Person.Type == Parent {
+		recordHeightPredictionsTx(tx, entry.Person.FamilyId)
+	}
+
+	deleteTrashEntry(tx, entry.Id)
+	return nil
+}
+
+// purgeTrash permanently removes expired entries and their upload files
+func purgeTrash(now time.Time) (purged int) {
+	var files []string
+	vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+		var expired []TrashEntry
+		vbolt.IterateAll(tx, TrashBucket, func(key int, value TrashEntry) bool {
+			if now.After(value.ExpiresAt()) {
+				expired = append(expired, value)
+			}
+			return true
+		})
+		for _, entry := range expired {
+			files = append(files, imageFiles(entry.Image)...)
+			for _, attachment := range entry.Attachments {
+				files = append(files, imageFiles(attachment)...)
+			}
+			deleteTrashEntry(tx, entry.Id)
+		}
+		purged = len(expired)
+		vbolt.TxCommit(tx)
+	})
+	removeFiles(files)
+	return
+}
+
+func runTrashPurge(interval time.Duration) {
+	for {
+		if purged := purgeTrash(time.Now()); purged > 0 {
+			log.Printf("purged %d trash entries", purged)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func RegisterTrashPages(mux *http.ServeMux) {
+	mux.Handle("GET /family/trash/{id}", OwnerHandler(ContextFunc(trashPage)))
+	mux.Handle("POST /family/trash/restore/{id}", AuthHandler(ContextFunc(restoreTrash)))
+}
+
+func trashPage(context ResponseContext) {
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		id := context.r.PathValue("id")
+		idVal, _ := strconv.Atoi(id)
+		context.familyId = idVal
+
+		now := time.Now()
+		var entries []TrashEntry
+		deletedBy := make(map[int]string)
+		for _, entry := range getTrashForFamily(tx, idVal) {
+			if now.After(entry.ExpiresAt()) {
+				continue
+			}
+			entries = append(entries, entry)
+			deletedBy[entry.DeletedBy] = GetUser(tx, entry.DeletedBy).Email
+		}
+		RenderTemplateWithData(context, "trash", map[string]any{
+			"Family":    getFamily(tx, idVal),
+			"Entries":   entries,
+			"DeletedBy": deletedBy,
+		})
+	})
+}
+
+func restoreTrash(context ResponseContext) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+
+	var entry TrashEntry
+	var err error
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		vbolt.Read(tx, TrashBucket, id, &entry)
+		if entry.Id == 0 || !isFamilyOwner(tx, entry.FamilyId, context.user.Id) {
+			err = errors.New("not a family owner")
+			return
+		}
+		err = restoreTrashEntryTx(tx, entry, context.user.Id)
+		if err == nil {
+			vbolt.TxCommit(tx)
+		}
+	})
+	if err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(context.w, context.r, "/family/trash/"+strconv.Itoa(entry.FamilyId), http.StatusFound)
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestoreExpiredTrash(t *testing.T) {
+	entry := TrashEntry{Id: 1, FamilyId: 2, Kind: TrashMeasurement, DeletedAt: time.Now().Add(-TrashRetention - time.Minute)}
+	// rejected before the transaction is touched
+	if err := restoreTrashEntryTx(nil, entry, 1); err != ErrRestoreExpired {
+		t.Errorf("expected an expired entry to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+
+	"go.hasen.dev/vbolt"
+)
+
+type UnitSystem int
+
+const (
+	DefaultUnits UnitSystem = iota // no preference; users fall back to their family, families to imperial
+	Imperial
+	Metric
+)
+
+var unitSystems = []UnitSystem{Imperial, Metric}
+
+func parseUnitSystemLabel(system UnitSystem) string {
+	switch system {
+	case Imperial:
+		return "imperial"
+	case Metric:
+		return "metric"
+	default:
+		return ""
+	}
+}
+
+func parseUnitSystem(s string) (UnitSystem, error) {
+	switch s {
+	case "":
+		return DefaultUnits, nil
+	case "imperial":
+		return Imperial, nil
+	case "metric":
+		return Metric, nil
+	default:
+		return 0, fmt.Errorf("unknown unit system: %s", s)
+	}
+}
+
+// Unit converts to and from the metric unit a measurement kind is stored in:
+// stored = value*Scale + Offset.
+type Unit struct {
+	Symbol string
+	Scale  float64
+	Offset float64
+}
+
+var (
+	UnitCentimeters = Unit{Symbol: "cm", Scale: 1}
+	UnitInches      = Unit{Symbol: "in", Scale: 2.54}
+	UnitKilograms   = Unit{Symbol: "kg", Scale: 1}
+	UnitPounds      = Unit{Symbol: "lb", Scale: 0.45359237}
+	UnitCelsius     = Unit{Symbol: "°C", Scale: 1}
+	UnitFahrenheit  = Unit{Symbol: "°F", Scale: 5.0 / 9, Offset: -160.0 / 9}
+	UnitShoeSize    = Unit{Symbol: "US", Scale: 1}
+)
+
+func (unit Unit) ToCanonical(value float64) float64 {
+	return value*unit.Scale + unit.Offset
+}
+
+func (unit Unit) FromCanonical(value float64) float64 {
+	return (value - unit.Offset) / unit.Scale
+}
+
+// preferredUnits resolves a user's unit system: their own choice, then their
+// primary family's, then imperial.
+func preferredUnits(tx *vbolt.Tx, user User) UnitSystem {
+	if user.Units != DefaultUnits {
+		return user.Units
+	}
+	if user.PrimaryFamilyId > 0 {
+		if family := getFamily(tx, user.PrimaryFamilyId); family.Units != DefaultUnits {
+			return family.Units
+		}
+	}
+	return Imperial
+}
+
+// requestUnits is the unit system for a request; a units query parameter
+// overrides the viewer's preference.
+func requestUnits(context ResponseContext) (system UnitSystem) {
+	if system, err := parseUnitSystem(context.r.URL.Query().Get("units")); err == nil && system != DefaultUnits {
+		return system
+	}
+	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
+		system = preferredUnits(tx, context.user)
+	})
+	return
+}
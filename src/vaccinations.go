@@ -0,0 +1,572 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+type VaccineInfo struct {
+	Code  string
+	Label string
+}
+
+var vaccines = []VaccineInfo{
+	{"hepb", "Hepatitis B (HepB)"},
+	{"rv", "Rotavirus (RV)"},
+	{"dtap", "Diphtheria, tetanus & pertussis (DTaP)"},
+	{"hib", "Haemophilus influenzae type b (Hib)"},
+	{"pcv", "Pneumococcal conjugate (PCV)"},
+	{"ipv", "Polio (IPV)"},
+	{"mmr", "Measles, mumps & rubella (MMR)"},
+	{"var", "Varicella (VAR)"},
+	{"hepa", "Hepatitis A (HepA)"},
+	{"tdap", "Tetanus, diphtheria & pertussis (Tdap)"},
+	{"hpv", "Human papillomavirus (HPV)"},
+	{"menacwy", "Meningococcal ACWY (MenACWY)"},
+	{"menb", "Meningococcal B (MenB)"},
+	{"6in1", "6-in-1 (DTaP/IPV/Hib/HepB)"},
+	{"hibmenc", "Hib/MenC"},
+	{"4in1", "4-in-1 pre-school booster (DTaP/IPV)"},
+	{"3in1", "3-in-1 teenage booster (Td/IPV)"},
+	{"flu", "Influenza"},
+	{"covid", "COVID-19"},
+	{"other", "Other"},
+}
+
+func findVaccine(code string) (VaccineInfo, bool) {
+	for _, vaccine := range vaccines {
+		if vaccine.Code == code {
+			return vaccine, true
+		}
+	}
+	return VaccineInfo{}, false
+}
+
+func vaccineLabel(code string) string {
+	if vaccine, found := findVaccine(code); found {
+		return vaccine.Label
+	}
+	return code
+}
+
+// ScheduledDose is due from DueMonths of age and overdue after OverdueMonths
+type ScheduledDose struct {
+	Vaccine       string
+	Dose          int
+	DueMonths     int
+	OverdueMonths int
+}
+
+type VaccineSchedule struct {
+	Slug  string
+	Label string
+	Doses []ScheduledDose
+}
+
+// doses numbers a vaccine's doses; each window is {due, overdue} in months
+func doses(vaccine string, windows ...[2]int) (result []ScheduledDose) {
+	for i, window := range windows {
+		result = append(result, ScheduledDose{Vaccine: vaccine, Dose: i + 1, DueMonths: window[0], OverdueMonths: window[1]})
+	}
+	return
+}
+
+func scheduleDoses(groups ...[]ScheduledDose) (result []ScheduledDose) {
+	for _, group := range groups {
+		result = append(result, group...)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].DueMonths < result[j].DueMonths })
+	return
+}
+
+// vaccineSchedules are the routine childhood schedules a family can follow.
+// Windows follow the recommended age ranges, so a dose is overdue once the
+// range has passed without it.
+var vaccineSchedules = []VaccineSchedule{
+	{
+		Slug:  "cdc",
+		Label: "CDC childhood schedule (US)",
+		Doses: scheduleDoses(
+			doses("hepb", [2]int{0, 1}, [2]int{1, 3}, [2]int{6, 19}),
+			doses("rv", [2]int{2, 3}, [2]int{4, 5}, [2]int{6, 8}),
+			doses("dtap", [2]int{2, 3}, [2]int{4, 5}, [2]int{6, 7}, [2]int{15, 19}, [2]int{48, 84}),
+			doses("hib", [2]int{2, 3}, [2]int{4, 5}, [2]int{6, 7}, [2]int{12, 16}),
+			doses("pcv", [2]int{2, 3}, [2]int{4, 5}, [2]int{6, 7}, [2]int{12, 16}),
+			doses("ipv", [2]int{2, 3}, [2]int{4, 5}, [2]int{6, 19}, [2]int{48, 84}),
+			doses("mmr", [2]int{12, 16}, [2]int{48, 84}),
+			doses("var", [2]int{12, 16}, [2]int{48, 84}),
+			doses("hepa", [2]int{12, 24}, [2]int{18, 30}),
+			doses("tdap", [2]int{132, 156}),
+			doses("hpv", [2]int{132, 156}, [2]int{138, 162}),
+			doses("menacwy", [2]int{132, 156}, [2]int{192, 204}),
+		),
+	},
+	{
+		Slug:  "nhs",
+		Label: "NHS routine schedule (UK)",
+		Doses: scheduleDoses(
+			doses("6in1", [2]int{2, 3}, [2]int{3, 4}, [2]int{4, 5}, [2]int{18, 19}),
+			doses("rv", [2]int{2, 3}, [2]int{3, 4}),
+			doses("menb", [2]int{2, 3}, [2]int{4, 5}, [2]int{12, 13}),
+			doses("pcv", [2]int{4, 5}, [2]int{12, 13}),
+			doses("hibmenc", [2]int{12, 13}),
+			doses("mmr", [2]int{12, 13}, [2]int{18, 19}),
+			doses("4in1", [2]int{40, 48}),
+			doses("hpv", [2]int{144, 168}),
+			doses("3in1", [2]int{168, 180}),
+			doses("menacwy", [2]int{168, 180}),
+		),
+	},
+}
+
+func findVaccineSchedule(slug string) VaccineSchedule {
+	for _, schedule := range vaccineSchedules {
+		if schedule.Slug == slug {
+			return schedule
+		}
+	}
+	return vaccineSchedules[0]
+}
+
+// Vaccination is one dose given
+type Vaccination struct {
+	Id       int
+	PersonId int
+	Vaccine  string
+	Date     time.Time
+	Lot      string
+	Clinic   string
+	Notes    string
+}
+
+func (vaccination Vaccination) Label() string {
+	return vaccineLabel(vaccination.Vaccine)
+}
+
+func PackVaccination(self *Vaccination, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.String(&self.Vaccine, buf)
+	vpack.Time(&self.Date, buf)
+	vpack.String(&self.Lot, buf)
+	vpack.String(&self.Clinic, buf)
+	vpack.String(&self.Notes, buf)
+}
+
+var VaccinationBucket = vbolt.Bucket(&Info, "vaccinations", vpack.FInt, PackVaccination)
+
+// VaccinationIndex term: person id, priority: date, target: vaccination id
+var VaccinationIndex = vbolt.IndexExt(&Info, "vaccinations_by", vpack.FInt, vpack.UnixTimeKey, vpack.FInt)
+
+// VaccineReminderBucket records when each reminder was sent, keyed by
+// vaccineReminderKey, so every reminder goes out once
+var VaccineReminderBucket = vbolt.Bucket(&Info, "vaccine_reminders", vpack.StringZ, vpack.Time)
+
+func saveVaccinationTx(tx *vbolt.Tx, vaccination *Vaccination) {
+	if vaccination.Id == 0 {
+		vaccination.Id = vbolt.NextIntId(tx, VaccinationBucket)
+	}
+	vbolt.Write(tx, VaccinationBucket, vaccination.Id, vaccination)
+	vbolt.SetTargetSingleTermExt(tx, VaccinationIndex, vaccination.Id, vaccination.Date, vaccination.PersonId)
+}
+
+func deleteVaccinationTx(tx *vbolt.Tx, vaccinationId int) {
+	vbolt.Delete(tx, VaccinationBucket, vaccinationId)
+	vbolt.SetTargetTermsPlain(tx, VaccinationIndex, vaccinationId, nil)
+}
+
+func getVaccinationTx(tx *vbolt.Tx, id int) (vaccination Vaccination) {
+	vbolt.Read(tx, VaccinationBucket, id, &vaccination)
+	return
+}
+
+// getPersonVaccinationsTx returns a person's doses, oldest first
+func getPersonVaccinationsTx(tx *vbolt.Tx, personId int) (vaccinations []Vaccination) {
+	var ids []int
+	vbolt.ReadTermTargets(tx, VaccinationIndex, personId, &ids, vbolt.Window{})
+	vbolt.ReadSlice(tx, VaccinationBucket, ids, &vaccinations)
+	sort.SliceStable(vaccinations, func(i, j int) bool { return vaccinations[i].Date.Before(vaccinations[j].Date) })
+	return
+}
+
+func trashVaccinationTx(tx *vbolt.Tx, vaccination Vaccination, familyId int, userId int) {
+	deleteVaccinationTx(tx, vaccination.Id)
+	saveTrashEntry(tx, &TrashEntry{
+		FamilyId:     familyId,
+		Kind:         TrashVaccination,
+		Label:        vaccination.Label() + ", " + vaccination.Date.Format("Jan 2, 2006"),
+		DeletedBy:    userId,
+		Vaccinations: []Vaccination{vaccination},
+	})
+}
+
+type DoseStatus int
+
+const (
+	DoseLater DoseStatus = iota
+	DoseUpcoming
+	DoseDue
+	DoseOverdue
+	DoseGiven
+)
+
+// vaccineUpcomingDays is how far ahead a dose shows as coming up
+const vaccineUpcomingDays = 30
+
+func (status DoseStatus) String() string {
+	switch status {
+	case DoseUpcoming:
+		return "coming up"
+	case DoseDue:
+		return "due"
+	case DoseOverdue:
+		return "overdue"
+	case DoseGiven:
+		return "given"
+	default:
+		return "later"
+	}
+}
+
+type DoseEntry struct {
+	ScheduledDose
+	Label       string
+	Status      DoseStatus
+	DueDate     time.Time
+	OverdueDate time.Time
+	Given       Vaccination
+}
+
+func (entry DoseEntry) IsGiven() bool    { return entry.Status == DoseGiven }
+func (entry DoseEntry) IsDue() bool      { return entry.Status == DoseDue }
+func (entry DoseEntry) IsOverdue() bool  { return entry.Status == DoseOverdue }
+func (entry DoseEntry) IsUpcoming() bool { return entry.Status == DoseUpcoming }
+
+// vaccinationStatus lines the doses given up against the schedule: the nth
+// dose of a vaccine given fills its nth scheduled dose. Dates are counted
+// from the birthday, never the corrected age.
+func vaccinationStatus(schedule VaccineSchedule, birthday time.Time, given []Vaccination, now time.Time) (entries []DoseEntry) {
+	byVaccine := make(map[string][]Vaccination)
+	for _, vaccination := range given {
+		byVaccine[vaccination.Vaccine] = append(byVaccine[vaccination.Vaccine], vaccination)
+	}
+	for _, list := range byVaccine {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Date.Before(list[j].Date) })
+	}
+
+	for _, dose := range schedule.Doses {
+		entry := DoseEntry{
+			ScheduledDose: dose,
+			Label:         vaccineLabel(dose.Vaccine),
+			DueDate:       birthday.AddDate(0, dose.DueMonths, 0),
+			OverdueDate:   birthday.AddDate(0, dose.OverdueMonths, 0),
+		}
+		switch {
+		case len(byVaccine[dose.Vaccine]) >= dose.Dose:
+			entry.Status = DoseGiven
+			entry.Given = byVaccine[dose.Vaccine][dose.Dose-1]
+		case !now.Before(entry.OverdueDate):
+			entry.Status = DoseOverdue
+		case !now.Before(entry.DueDate):
+			entry.Status = DoseDue
+		case !now.Before(entry.DueDate.AddDate(0, 0, -vaccineUpcomingDays)):
+			entry.Status = DoseUpcoming
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+// dosesNeedingAttention keeps the due, overdue and upcoming doses
+func dosesNeedingAttention(entries []DoseEntry) (result []DoseEntry) {
+	for _, entry := range entries {
+		if entry.IsDue() || entry.IsOverdue() || entry.IsUpcoming() {
+			result = append(result, entry)
+		}
+	}
+	return
+}
+
+func hasVaccineSchedule(person Person) bool {
+	return person.Type == Child && !person.Birthday.IsZero()
+}
+
+func personVaccinationStatusTx(tx *vbolt.Tx, person Person, now time.Time) []DoseEntry {
+	if !hasVaccineSchedule(person) {
+		return nil
+	}
+	schedule := findVaccineSchedule(getFamily(tx, person.FamilyId).VaccineSchedule)
+	return vaccinationStatus(schedule, person.Birthday, getPersonVaccinationsTx(tx, person.Id), now)
+}
+
+// vaccineReminderWindow limits reminders to doses that became due or overdue
+// recently, so turning reminders on doesn't mail a child's whole history
+const vaccineReminderWindow = 30 * 24 * time.Hour
+
+type VaccineReminder struct {
+	Key      string
+	PersonId int
+	Message  string
+}
+
+func vaccineReminderKey(personId int, entry DoseEntry) string {
+	return fmt.Sprintf("%d:%s:%d:%d", personId, entry.Vaccine, entry.Dose, entry.Status)
+}
+
+// pendingVaccineReminders lists a child's doses that turned due or overdue
+// within the reminder window
+func pendingVaccineReminders(person Person, entries []DoseEntry, now time.Time) (reminders []VaccineReminder) {
+	for _, entry := range entries {
+		var since time.Time
+		switch entry.Status {
+		case DoseDue:
+			since = entry.DueDate
+		case DoseOverdue:
+			since = entry.OverdueDate
+		default:
+			continue
+		}
+		if now.Sub(since) > vaccineReminderWindow {
+			continue
+		}
+		reminders = append(reminders, VaccineReminder{
+			Key:      vaccineReminderKey(person.Id, entry),
+			PersonId: person.Id,
+			Message:  fmt.Sprintf("%s: %s dose %d is %s", person.Name, entry.Label, entry.Dose, entry.Status),
+		})
+	}
+	return
+}
+
+// sendVaccineReminders emails each opted-in family's owners about doses that
+// have become due or overdue since the last run
+func sendVaccineReminders(now time.Time) (sent int) {
+	type familyReminders struct {
+		recipients []string
+		reminders  []VaccineReminder
+	}
+	var pending []familyReminders
+	vbolt.WithReadTx(db, func(tx *vbolt.Tx) {
+		vbolt.IterateAll(tx, FamilyBucket, func(key int, family Family) bool {
+			if !family.VaccineReminders {
+				return true
+			}
+			var batch familyReminders
+			for _, person := range getPeopleInFamily(tx, family.Id) {
+				for _, reminder := range pendingVaccineReminders(person, personVaccinationStatusTx(tx, person, now), now) {
+					if !vbolt.HasKey(tx, VaccineReminderBucket, reminder.Key) {
+						batch.reminders = append(batch.reminders, reminder)
+					}
+				}
+			}
+			for _, userId := range family.OwningUsers {
+				if email := GetUser(tx, userId).Email; email != "" {
+					batch.recipients = append(batch.recipients, email)
+				}
+			}
+			if len(batch.reminders) > 0 && len(batch.recipients) > 0 {
+				pending = append(pending, batch)
+			}
+			return true
+		})
+	})
+
+	for _, batch := range pending {
+		var body strings.Builder
+		body.WriteString("Hello,\r\n\r\nVaccinations needing attention on the family site:\r\n\r\n")
+		for _, reminder := range batch.reminders {
+			body.WriteString("- " + reminder.Message + "\r\n")
+			body.WriteString("  " + os.Getenv("SITE_ROOT") + "/vaccinations/" + strconv.Itoa(reminder.PersonId) + "\r\n")
+		}
+		if err := sendEmail(batch.recipients, "Vaccination reminder", body.String()); err != nil {
+			log.Printf("Failed to send vaccination reminder email: %v", err)
+			continue
+		}
+		vbolt.WithWriteTx(db, func(tx *vbolt.Tx) {
+			for _, reminder := range batch.reminders {
+				vbolt.Write(tx, VaccineReminderBucket, reminder.Key, &now)
+			}
+			vbolt.TxCommit(tx)
+		})
+		sent += len(batch.reminders)
+	}
+	return
+}
+
+func runVaccineReminders(interval time.Duration) {
+	for {
+		if sent := sendVaccineReminders(time.Now()); sent > 0 {
+			log.Printf("sent %d vaccination reminders", sent)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func RegisterVaccinationPages(mux *http.ServeMux) {
+	mux.Handle("GET /vaccinations/{id}", AuthHandler(ContextFunc(vaccinationsPage)))
+	mux.Handle("GET /vaccinations/{id}/print", AuthHandler(ContextFunc(vaccinationsPrintPage)))
+	mux.Handle("GET /vaccinations/family/{id}", AuthHandler(ContextFunc(familyVaccinationsPage)))
+	mux.Handle("POST /vaccinations/{id}", AuthHandler(ContextFunc(saveVaccination)))
+	mux.Handle("POST /vaccinations/delete/{id}", AuthHandler(ContextFunc(deleteVaccination)))
+}
+
+// renderVaccinations shows a person's record to the family; edit is the
+// dose being edited, if any
+func renderVaccinations(context ResponseContext, templateName string, edit Vaccination, errors map[string]string) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person := getPerson(tx, personId)
+		if person.Id == 0 || !isFamilyMemberTx(tx, context, person.FamilyId) {
+			http.Error(context.w, "not a family member", http.StatusUnauthorized)
+			return
+		}
+		context.familyId = person.FamilyId
+
+		if editId, _ := strconv.Atoi(context.r.URL.Query().Get("edit")); editId > 0 && edit.Id == 0 {
+			edit = getVaccinationTx(tx, editId)
+			if edit.PersonId != person.Id {
+				edit = Vaccination{}
+			}
+		}
+		if edit.Date.IsZero() {
+			edit.Date = time.Now()
+		}
+		entries := personVaccinationStatusTx(tx, person, time.Now())
+		RenderTemplateWithData(context, templateName, map[string]any{
+			"Person":       person,
+			"Schedule":     findVaccineSchedule(getFamily(tx, person.FamilyId).VaccineSchedule),
+			"Entries":      entries,
+			"Attention":    dosesNeedingAttention(entries),
+			"Vaccinations": getPersonVaccinationsTx(tx, person.Id),
+			"Vaccines":     vaccines,
+			"Edit":         edit,
+			"Errors":       errors,
+			"Today":        time.Now(),
+		})
+	})
+}
+
+func vaccinationsPage(context ResponseContext) {
+	renderVaccinations(context, "vaccinations", Vaccination{}, nil)
+}
+
+func vaccinationsPrintPage(context ResponseContext) {
+	renderVaccinations(context, "vaccinations-print", Vaccination{}, nil)
+}
+
+type FamilyVaccinations struct {
+	Person    Person
+	Given     int
+	Attention []DoseEntry
+}
+
+// familyVaccinationsPage lists what each child in the family needs next
+func familyVaccinationsPage(context ResponseContext) {
+	familyId, _ := strconv.Atoi(context.r.PathValue("id"))
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		if !isFamilyMemberTx(tx, context, familyId) {
+			http.Error(context.w, "not a family member", http.StatusUnauthorized)
+			return
+		}
+		context.familyId = familyId
+
+		var children []FamilyVaccinations
+		for _, person := range getPeopleInFamily(tx, familyId) {
+			if !hasVaccineSchedule(person) {
+				continue
+			}
+			entries := personVaccinationStatusTx(tx, person, time.Now())
+			child := FamilyVaccinations{Person: person, Attention: dosesNeedingAttention(entries)}
+			for _, entry := range entries {
+				if entry.IsGiven() {
+					child.Given++
+				}
+			}
+			children = append(children, child)
+		}
+		family := getFamily(tx, familyId)
+		RenderTemplateWithData(context, "vaccinations-family", map[string]any{
+			"Family":   family,
+			"Schedule": findVaccineSchedule(family.VaccineSchedule),
+			"Children": children,
+		})
+	})
+}
+
+func saveVaccination(context ResponseContext) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	form := bindForm(context.r)
+	vaccination := Vaccination{
+		Id:       form.Int("id", "Id", false),
+		PersonId: personId,
+		Vaccine:  form.String("vaccine", "Vaccine", true),
+		Date:     form.Date("date", "Date given", true),
+		Lot:      strings.TrimSpace(form.Value("lot")),
+		Clinic:   strings.TrimSpace(form.Value("clinic")),
+		Notes:    strings.TrimSpace(form.Value("notes")),
+	}
+	if _, found := findVaccine(vaccination.Vaccine); vaccination.Vaccine != "" && !found {
+		form.Fail("vaccine", "Unknown vaccine")
+	}
+
+	var isOwner bool
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		person := getPerson(tx, personId)
+		isOwner = person.Id > 0 && isFamilyOwner(tx, person.FamilyId, context.user.Id)
+		if vaccination.Id > 0 {
+			isOwner = isOwner && getVaccinationTx(tx, vaccination.Id).PersonId == person.Id
+		}
+		if !isOwner {
+			return
+		}
+		form.DuringLife("date", "Date given", vaccination.Date, person)
+		if !form.Valid() {
+			return
+		}
+		saveVaccinationTx(tx, &vaccination)
+		vbolt.TxCommit(tx)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+	if !form.Valid() {
+		renderVaccinations(context, "vaccinations", vaccination, form.Errors)
+		return
+	}
+
+	http.Redirect(context.w, context.r, fmt.Sprintf("/vaccinations/%d", personId), http.StatusFound)
+}
+
+func deleteVaccination(context ResponseContext) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	var vaccination Vaccination
+	var isOwner bool
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		vaccination = getVaccinationTx(tx, id)
+		person := getPerson(tx, vaccination.PersonId)
+		isOwner = vaccination.Id > 0 && isFamilyOwner(tx, person.FamilyId, context.user.Id)
+		if !isOwner {
+			return
+		}
+		trashVaccinationTx(tx, vaccination, person.FamilyId, context.user.Id)
+		vbolt.TxCommit(tx)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+
+	http.Redirect(context.w, context.r, fmt.Sprintf("/vaccinations/%d", vaccination.PersonId), http.StatusFound)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVaccinationStatus(t *testing.T) {
+	birthday := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	given := []Vaccination{
+		{Id: 2, Vaccine: "hepb", Date: birthday.AddDate(0, 1, 2)},
+		{Id: 1, Vaccine: "hepb", Date: birthday},
+	}
+	now := birthday.AddDate(0, 3, 5)
+	entries := vaccinationStatus(findVaccineSchedule("cdc"), birthday, given, now)
+	find := func(vaccine string, dose int) (found DoseEntry) {
+		for _, entry := range entries {
+			if entry.Vaccine == vaccine && entry.Dose == dose {
+				found = entry
+			}
+		}
+		return
+	}
+	check := func(vaccine string, dose int, want DoseStatus) {
+		t.Helper()
+		if entry := find(vaccine, dose); entry.Status != want {
+			t.Errorf("%s dose %d: expected %s, got %s", vaccine, dose, want, entry.Status)
+		}
+	}
+	check("hepb", 1, DoseGiven)
+	check("hepb", 2, DoseGiven)
+	check("hepb", 3, DoseLater)
+	check("dtap", 1, DoseOverdue)
+	check("dtap", 2, DoseUpcoming)
+	check("hepa", 1, DoseLater)
+
+	if find("hepb", 1).Given.Id != 1 {
+		t.Errorf("expected the first dose given to fill dose 1")
+	}
+	if findVaccineSchedule("unknown").Slug != "cdc" {
+		t.Errorf("expected the CDC schedule by default")
+	}
+}
+
+func TestPendingVaccineReminders(t *testing.T) {
+	person := Person{Id: 7, Name: "Maia", Type: Child, Birthday: time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)}
+	now := person.Birthday.AddDate(0, 2, 3)
+	entries := vaccinationStatus(findVaccineSchedule("cdc"), person.Birthday, nil, now)
+	reminders := pendingVaccineReminders(person, entries, now)
+	// the 2-month doses just came due; hepatitis B dose 1 went overdue too long ago
+	if len(reminders) != 5 {
+		t.Fatalf("expected 5 reminders, got %+v", reminders)
+	}
+	for _, reminder := range reminders {
+		if reminder.PersonId != 7 {
+			t.Errorf("unexpected reminder: %+v", reminder)
+		}
+	}
+}
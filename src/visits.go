@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.hasen.dev/vbolt"
+	"go.hasen.dev/vpack"
+)
+
+// Visit is one trip to the doctor. Measurements taken there are saved as
+// ordinary measurements and linked by id; attachments are family-only uploads.
+type Visit struct {
+	Id             int
+	PersonId       int
+	Date           time.Time
+	Provider       string
+	Reason         string
+	Notes          string
+	MeasurementIds []int
+	AttachmentIds  []int
+
+	Measurements []Measurement
+	Attachments  []Image
+}
+
+func PackVisit(self *Visit, buf *vpack.Buffer) {
+	vpack.Version(1, buf)
+	vpack.Int(&self.Id, buf)
+	vpack.Int(&self.PersonId, buf)
+	vpack.Time(&self.Date, buf)
+	vpack.String(&self.Provider, buf)
+	vpack.String(&self.Reason, buf)
+	vpack.String(&self.Notes, buf)
+	vpack.Slice(&self.MeasurementIds, vpack.Int, buf)
+	vpack.Slice(&self.AttachmentIds, vpack.Int, buf)
+}
+
+var VisitBucket = vbolt.Bucket(&Info, "visits", vpack.FInt, PackVisit)
+
+// VisitIndex term: person id, priority: date, target: visit id
+var VisitIndex = vbolt.IndexExt(&Info, "visits_by", vpack.FInt, vpack.UnixTimeKey, vpack.FInt)
+
+// personPageVisits is how many recent visits the person page lists
+const personPageVisits = 3
+
+// visitKinds are the measurements a visit form offers
+var visitKinds = []MeasurementKind{HeightKind, WeightKind, HeadCircumferenceKind}
+
+func saveVisitTx(tx *vbolt.Tx, visit *Visit) {
+	if visit.Id == 0 {
+		visit.Id = vbolt.NextIntId(tx, VisitBucket)
+	}
+	vbolt.Write(tx, VisitBucket, visit.Id, visit)
+	vbolt.SetTargetSingleTermExt(tx, VisitIndex, visit.Id, visit.Date, visit.PersonId)
+}
+
+func deleteVisitTx(tx *vbolt.Tx, visitId int) {
+	vbolt.Delete(tx, VisitBucket, visitId)
+	vbolt.SetTargetTermsPlain(tx, VisitIndex, visitId, nil)
+}
+
+func getVisitTx(tx *vbolt.Tx, id int) (visit Visit) {
+	vbolt.Read(tx, VisitBucket, id, &visit)
+	return
+}
+
+// prepVisitTx loads the linked measurements and attachments, skipping any
+// that have since been deleted
+func prepVisitTx(tx *vbolt.Tx, visit *Visit, system UnitSystem) {
+	visit.Measurements = nil
+	for _, id := range visit.MeasurementIds {
+		var measurement Measurement
+		if vbolt.Read(tx, MeasurementBucket, id, &measurement) {
+			visit.Measurements = append(visit.Measurements, measurement)
+		}
+	}
+	setDisplayUnits(visit.Measurements, system)
+	visit.Attachments = visitAttachmentsTx(tx, *visit)
+}
+
+func visitAttachmentsTx(tx *vbolt.Tx, visit Visit) (attachments []Image) {
+	for _, id := range visit.AttachmentIds {
+		var attachment Image
+		if vbolt.Read(tx, ImageBucket, id, &attachment) {
+			attachments = append(attachments, attachment)
+		}
+	}
+	return
+}
+
+// getPersonVisitsTx returns a person's visits, newest first
+func getPersonVisitsTx(tx *vbolt.Tx, personId int, system UnitSystem) (visits []Visit) {
+	var ids []int
+	vbolt.ReadTermTargets(tx, VisitIndex, personId, &ids, vbolt.Window{})
+	vbolt.ReadSlice(tx, VisitBucket, ids, &visits)
+	for i := range visits {
+		prepVisitTx(tx, &visits[i], system)
+	}
+	sort.SliceStable(visits, func(i, j int) bool { return visits[i].Date.After(visits[j].Date) })
+	return
+}
+
+// trashVisitTx moves a visit and its attachments to the trash. Measurements
+// taken at the visit stay, as they are part of the growth record.
+func trashVisitTx(tx *vbolt.Tx, visit Visit, familyId int, userId int) {
+	attachments := visitAttachmentsTx(tx, visit)
+	for _, attachment := range attachments {
+		vbolt.Delete(tx, ImageBucket, attachment.Id)
+	}
+	deleteVisitTx(tx, visit.Id)
+	visit.Measurements = nil
+	visit.Attachments = nil
+	saveTrashEntry(tx, &TrashEntry{
+		FamilyId:    familyId,
+		Kind:        TrashVisit,
+		Label:       "Visit, " + visit.Date.Format("Jan 2, 2006"),
+		DeletedBy:   userId,
+		Visits:      []Visit{visit},
+		Attachments: attachments,
+	})
+}
+
+func RegisterVisitPages(mux *http.ServeMux) {
+	mux.Handle("GET /visits/{id}", AuthHandler(ContextFunc(visitsPage)))
+	mux.Handle("GET /visits/add/{id}", AuthHandler(ContextFunc(addVisitPage)))
+	mux.Handle("GET /visits/edit/{id}", AuthHandler(ContextFunc(editVisitPage)))
+	mux.Handle("POST /visits/save", AuthHandler(ContextFunc(saveVisit)))
+	mux.Handle("POST /visits/delete/{id}", AuthHandler(ContextFunc(deleteVisit)))
+}
+
+// visitsPage lists a person's visits to the family
+func visitsPage(context ResponseContext) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person := getPerson(tx, personId)
+		if person.Id == 0 || !isFamilyMemberTx(tx, context, person.FamilyId) {
+			http.Error(context.w, "not a family member", http.StatusUnauthorized)
+			return
+		}
+		context.familyId = person.FamilyId
+		RenderTemplateWithData(context, "visits", map[string]any{
+			"Person": person,
+			"Visits": getPersonVisitsTx(tx, person.Id, preferredUnits(tx, context.user)),
+		})
+	})
+}
+
+// VisitMeasurementField is one optional measurement on the visit form
+type VisitMeasurementField struct {
+	Info  MeasurementKindInfo
+	Unit  Unit
+	Value string
+}
+
+func visitMeasurementFields(system UnitSystem, form *Form) (fields []VisitMeasurementField) {
+	for _, kind := range visitKinds {
+		info := kind.Info()
+		field := VisitMeasurementField{Info: info, Unit: info.Unit(system)}
+		if form != nil {
+			field.Value = form.Value("value-" + info.Slug)
+			if unit, found := info.findUnit(form.Value("unit-" + info.Slug)); found {
+				field.Unit = unit
+			}
+		}
+		fields = append(fields, field)
+	}
+	return
+}
+
+// renderVisitForm shows the add/edit form; measurements are only offered
+// when adding, as editing them belongs to the measurement pages
+func renderVisitForm(context ResponseContext, tx *vbolt.Tx, person Person, visit Visit, form *Form) {
+	context.familyId = person.FamilyId
+	system := preferredUnits(tx, context.user)
+	var errors map[string]string
+	if form != nil {
+		errors = form.Errors
+	}
+	data := map[string]any{
+		"Person": person,
+		"Visit":  visit,
+		"Errors": errors,
+	}
+	if visit.Id == 0 {
+		data["Fields"] = visitMeasurementFields(system, form)
+	} else {
+		prepVisitTx(tx, &visit, system)
+		data["Visit"] = visit
+	}
+	RenderTemplateWithData(context, "visits-add", data)
+}
+
+func addVisitPage(context ResponseContext) {
+	personId, _ := strconv.Atoi(context.r.PathValue("id"))
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person := getPerson(tx, personId)
+		if person.Id == 0 || !isFamilyOwner(tx, person.FamilyId, context.user.Id) {
+			http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+			return
+		}
+		renderVisitForm(context, tx, person, Visit{PersonId: person.Id, Date: time.Now()}, nil)
+	})
+}
+
+func editVisitPage(context ResponseContext) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		visit := getVisitTx(tx, id)
+		person := getPerson(tx, visit.PersonId)
+		if visit.Id == 0 || !isFamilyOwner(tx, person.FamilyId, context.user.Id) {
+			http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+			return
+		}
+		renderVisitForm(context, tx, person, visit, nil)
+	})
+}
+
+// saveVisit records a visit along with any measurements taken and files
+// attached, all in one step. Editing updates the details and adds files.
+func saveVisit(context ResponseContext) {
+	context.r.Body = http.MaxBytesReader(context.w, context.r.Body, 10<<23)
+	if err := context.r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(context.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	form := bindForm(context.r)
+	visit := Visit{
+		Id:       form.Int("id", "Id", false),
+		PersonId: form.Int("personId", "Person", true),
+		Date:     form.Date("date", "Visit date", true),
+		Provider: form.String("provider", "Provider", false),
+		Reason:   form.String("reason", "Reason", false),
+		Notes:    form.String("notes", "Notes", false),
+	}
+
+	var person Person
+	var previous Visit
+	var system UnitSystem
+	var isOwner bool
+	vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+		person = getPerson(tx, visit.PersonId)
+		isOwner = person.Id > 0 && isFamilyOwner(tx, person.FamilyId, context.user.Id)
+		if visit.Id > 0 {
+			previous = getVisitTx(tx, visit.Id)
+			isOwner = isOwner && previous.PersonId == person.Id
+		}
+		system = preferredUnits(tx, context.user)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+	form.DuringLife("date", "Visit date", visit.Date, person)
+
+	var measurements []Measurement
+	if visit.Id == 0 {
+		for _, field := range visitMeasurementFields(system, form) {
+			if field.Value == "" {
+				continue
+			}
+			name := "value-" + field.Info.Slug
+			entered := form.Float(name, field.Info.Label, false)
+			value := field.Unit.ToCanonical(entered)
+			if form.Errors[name] == "" {
+				if err := field.Info.Validate(value, field.Unit); err != nil {
+					form.Fail(name, "%s", err.Error())
+				}
+			}
+			measurements = append(measurements, Measurement{PersonId: person.Id, Kind: field.Info.Kind, Value: value, EntryUnit: field.Unit.Symbol, Date: visit.Date})
+		}
+	} else {
+		visit.MeasurementIds = previous.MeasurementIds
+		visit.AttachmentIds = previous.AttachmentIds
+	}
+
+	var attachments []Image
+	if form.Valid() {
+		var err error
+		if attachments, err = saveAttachmentFiles(context, "attachments", person.FamilyId); err != nil {
+			form.Fail("attachments", "%s", err.Error())
+		}
+	}
+	if !form.Valid() {
+		vbolt.WithReadTx(db, func(tx *bolt.Tx) {
+			renderVisitForm(context, tx, person, visit, form)
+		})
+		return
+	}
+
+	var alerts []GrowthAlert
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		for i := range measurements {
+			saveMeasurementTx(tx, &measurements[i])
+			visit.MeasurementIds = append(visit.MeasurementIds, measurements[i].Id)
+		}
+		for i := range attachments {
+			attachments[i].Id = vbolt.NextIntId(tx, ImageBucket)
+			SaveImage(tx, &attachments[i])
+			visit.AttachmentIds = append(visit.AttachmentIds, attachments[i].Id)
+		}
+		// measurements taken at the visit move with it
+		if visit.Id > 0 && !visit.Date.Equal(previous.Date) {
+			for _, id := range visit.MeasurementIds {
+				var measurement Measurement
+				if vbolt.Read(tx, MeasurementBucket, id, &measurement) {
+					measurement.Date = visit.Date
+					saveMeasurementTx(tx, &measurement)
+					measurements = append(measurements, measurement)
+				}
+			}
+		}
+		saveVisitTx(tx, &visit)
+		if len(measurements) > 0 {
+			alerts = refreshGrowthAlertsTx(tx, person.Id)
+		}
+		for _, measurement := range measurements {
+			if measurement.Kind == HeightKind {
+				recordHeightPredictionsTx(tx, person.FamilyId)
+				break
+			}
+		}
+		vbolt.TxCommit(tx)
+	})
+	go emailGrowthAlerts(person.FamilyId, alerts)
+
+	http.Redirect(context.w, context.r, fmt.Sprintf("/visits/%d", person.Id), http.StatusFound)
+}
+
+func deleteVisit(context ResponseContext) {
+	id, _ := strconv.Atoi(context.r.PathValue("id"))
+	var visit Visit
+	var isOwner bool
+	vbolt.WithWriteTx(db, func(tx *bolt.Tx) {
+		visit = getVisitTx(tx, id)
+		person := getPerson(tx, visit.PersonId)
+		isOwner = visit.Id > 0 && isFamilyOwner(tx, person.FamilyId, context.user.Id)
+		if !isOwner {
+			return
+		}
+		trashVisitTx(tx, visit, person.FamilyId, context.user.Id)
+		vbolt.TxCommit(tx)
+	})
+	if !isOwner {
+		http.Error(context.w, "not a family owner", http.StatusUnauthorized)
+		return
+	}
+
+	http.Redirect(context.w, context.r, fmt.Sprintf("/visits/%d", visit.PersonId), http.StatusFound)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAttachmentName(t *testing.T) {
+	if name := (Image{Filename: "1760870400123456789-checkup-notes.pdf"}).Name(); name != "checkup-notes.pdf" {
+		t.Errorf("unexpected name %q", name)
+	}
+}
+
+func uploadedFile(t *testing.T, name string, content string) *multipart.FileHeader {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("attachments", name)
+	part.Write([]byte(content))
+	writer.Close()
+
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return form.File["attachments"][0]
+}
+
+func TestAttachmentTypes(t *testing.T) {
+	_, err := saveAttachmentFile(uploadedFile(t, "notes.html", "<html><body>not a document</body></html>"), 1, 1)
+	if err == nil || !strings.Contains(err.Error(), "not a PDF or picture") {
+		t.Errorf("expected the HTML file to be refused, got %v", err)
+	}
+
+	// a PDF by content is stored as one, whatever it was called
+	dir, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(dir)
+	attachment, err := saveAttachmentFile(uploadedFile(t, "x.html", "%PDF-1.4\n<script>alert(1)</script>"), 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(attachment.Filename, "-x.pdf") {
+		t.Errorf("expected the file to be stored as a PDF, got %q", attachment.Filename)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A minimal XLSX writer: one worksheet per table, numbers as numbers and
+// everything else as inline strings. It covers what a spreadsheet needs to
+// open an export and nothing more (no styles, formulas or shared strings).
+
+// xlsxColumn turns a zero based column index into its letters: 0 is A, 26 is AA
+func xlsxColumn(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// xlsxSheetName drops the characters spreadsheets refuse in sheet names and
+// keeps it within 31 characters
+func xlsxSheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	if len(name) > 31 {
+		name = name[:31]
+	}
+	if name == "" {
+		name = "Sheet"
+	}
+	return name
+}
+
+func xlsxEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func writeXLSXSheet(w io.Writer, table ExportTable) error {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	rows := append([][]any{stringCells(table.Header)}, table.Rows...)
+	for i, row := range rows {
+		fmt.Fprintf(&b, `<row r="%d">`, i+1)
+		for j, cell := range row {
+			ref := xlsxColumn(j) + strconv.Itoa(i+1)
+			switch value := cell.(type) {
+			case nil:
+			case float64:
+				fmt.Fprintf(&b, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(value, 'f', -1, 64))
+			case int:
+				fmt.Fprintf(&b, `<c r="%s"><v>%d</v></c>`, ref, value)
+			default:
+				fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, xlsxEscape(fmt.Sprint(value)))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeXLSX writes the tables as a workbook, one sheet each
+func writeXLSX(w io.Writer, tables []ExportTable) error {
+	archive := zip.NewWriter(w)
+	part := func(name string, content string) error {
+		file, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(file, content)
+		return err
+	}
+
+	var overrides, sheets, relationships strings.Builder
+	for i, table := range tables {
+		n := i + 1
+		fmt.Fprintf(&overrides, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xlsxEscape(xlsxSheetName(table.Name)), n, n)
+		fmt.Fprintf(&relationships, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+	}
+
+	const header = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+	err := part("[Content_Types].xml", header+
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`+
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`+
+		`<Default Extension="xml" ContentType="application/xml"/>`+
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`+
+		overrides.String()+`</Types>`)
+	if err == nil {
+		err = part("_rels/.rels", header+
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>`+
+			`</Relationships>`)
+	}
+	if err == nil {
+		err = part("xl/workbook.xml", header+
+			`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">`+
+			`<sheets>`+sheets.String()+`</sheets></workbook>`)
+	}
+	if err == nil {
+		err = part("xl/_rels/workbook.xml.rels", header+
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+
+			relationships.String()+`</Relationships>`)
+	}
+	for i, table := range tables {
+		if err != nil {
+			break
+		}
+		var file io.Writer
+		file, err = archive.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1))
+		if err == nil {
+			err = writeXLSXSheet(file, table)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
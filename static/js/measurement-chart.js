@@ -28,10 +28,19 @@ const LineChart = (function() {
                     intersect: true,
                 },
                 plugins: {
+                    legend: {
+                        labels: {
+                            filter: (item, data) => !data.datasets[item.datasetIndex].isBand,
+                        }
+                    },
                     tooltip: {
                         callbacks: {
                             title: xTooltipCallback,
-                            label: yTooltipCallback,
+                            label: (tooltipItem) => tooltipItem.raw.band
+                                ? `${tooltipItem.raw.band} percentile: ${tooltipItem.parsed.y.toFixed(1)}`
+                                : tooltipItem.raw.prediction
+                                ? `${tooltipItem.raw.prediction}: ${tooltipItem.parsed.y.toFixed(1)}`
+                                : yTooltipCallback(tooltipItem),
                         }
                     },
                     zoom: {
@@ -148,19 +157,45 @@ const LineChart = (function() {
                 return;
             }
             const data = await response.json();
-            if (data == null) {
-                alert(`Error fetching data for ID ${personId}`);
+            if (data == null || !data.Measurements) {
+                alert(`No data found for ID ${personId}`);
                 return;
             }
+            // reference bands only for the first person, so the chart stays readable
+            if (chartData.datasets.length == 0 && data.Bands) {
+                data.Bands.forEach(band => chartData.datasets.push({
+                    label: `${band.Percentile} (ID:${personId})`,
+                    data: band.Points.map(p => ({ x: p.Age, y: p.Value, band: `${band.Percentile}th` })),
+                    borderColor: 'rgba(150, 150, 150, 0.5)',
+                    borderDash: [4, 4],
+                    pointRadius: 0,
+                    fill: false,
+                    isBand: true,
+                }));
+            }
             const dataset = {
-                label: `${data[0].PersonName} (ID:${personId})`,
-                data: dataFormatter(data),
-                borderColor: getIndexedColor(chartData.datasets.length),
+                label: `${data.PersonName} (ID:${personId})`,
+                data: dataFormatter(data.Measurements),
+                borderColor: getIndexedColor(chartData.datasets.filter(ds => !ds.isBand && !ds.isPrediction).length),
                 backgroundColor: 'rgba(0, 0, 0, 0)',
                 fill: false,
                 tension: 0.1,
             };
             chartData.datasets.push(dataset);
+            // predicted adult ranges, drawn as a line from low to high with the estimate in between
+            (data.Predictions || []).forEach((prediction, i) => chartData.datasets.push({
+                label: `${prediction.Method} (ID:${personId})`,
+                data: [
+                    { x: prediction.Age + i * 0.3, y: prediction.Low, prediction: `${prediction.Method} low` },
+                    { x: prediction.Age + i * 0.3, y: prediction.Value, prediction: prediction.Method },
+                    { x: prediction.Age + i * 0.3, y: prediction.High, prediction: `${prediction.Method} high` },
+                ],
+                borderColor: dataset.borderColor,
+                borderWidth: 4,
+                pointRadius: [2, 5, 2],
+                fill: false,
+                isPrediction: true,
+            }));
             lineChart.update();
             updateZoomLimits();
         } catch (error) {
@@ -172,12 +207,12 @@ const LineChart = (function() {
     // Remove a person's data
     const removePerson = (personId) => {
         if (!personId) return;
-        const datasetIndex = chartData.datasets.findIndex(ds => ds.label.includes(`ID:${personId}`));
-        if (datasetIndex === -1) {
+        const remaining = chartData.datasets.filter(ds => !ds.label.includes(`ID:${personId}`));
+        if (remaining.length === chartData.datasets.length) {
             alert(`No data found for ID ${personId}`);
             return;
         }
-        chartData.datasets.splice(datasetIndex, 1);
+        chartData.datasets.splice(0, chartData.datasets.length, ...remaining);
         lineChart.update();
         updateZoomLimits();
     };
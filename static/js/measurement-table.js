@@ -3,6 +3,8 @@ const MeasurementTable = (function() {
     let apiEndpoint = ''
     let tableId = ''
     let headerId = ''
+    let unit = ''
+    let options = {}
 
     function getHeatmapClass(deviation) {
         const intensity = Math.abs(deviation);
@@ -34,8 +36,13 @@ const MeasurementTable = (function() {
     async function updateTable() {
         if (!currentPeople) return;
 
-        const query = currentPeople.map(id => `ids=${id}`).join('&');
+        const query = new URLSearchParams(options);
+        currentPeople.forEach(id => query.append('ids', id));
         const response = await fetch(`${apiEndpoint}?${query}`);
+        if (!response.ok) {
+            alert(await response.text());
+            return;
+        }
         const data = await response.json();
 
         // Update the table
@@ -44,8 +51,8 @@ const MeasurementTable = (function() {
         tbody.innerHTML = ''; // Clear existing rows
 
         // Add headers
-        headerRow.innerHTML = '<th>Age (years)</th><th>Average (inches)</th>';
-        Object.entries(data.People).forEach(([index, person]) => {
+        headerRow.innerHTML = `<th>Age</th><th>Average (${unit})</th>`;
+        Object.entries(data.People || []).forEach(([index, person]) => {
             const th = document.createElement('th');
             th.textContent = person.Name;
             headerRow.appendChild(th);
@@ -56,29 +63,24 @@ const MeasurementTable = (function() {
             const row = document.createElement('tr');
             const ageCell = document.createElement('td');
 
-            if (milestone.MilestoneAge === 0) {
-                ageCell.textContent = "birth";
-            } else if (milestone.MilestoneAge < 1) {
-                ageCell.textContent = milestone.MilestoneAge * 12 + " months";
-            } else {
-                ageCell.textContent = milestone.MilestoneAge + " year";
-            }
+            ageCell.textContent = milestone.Label;
             row.appendChild(ageCell);
 
             const averageCell = document.createElement('td');
-            averageCell.textContent = milestone.Average.toFixed(2)
+            averageCell.textContent = milestone.Average === null ? "-" : milestone.Average.toFixed(2)
             row.appendChild(averageCell);
 
             Object.entries(milestone.Values).forEach(([index, milestoneValue]) => {
                 const heightCell = document.createElement('td');
                 heightCell.classList.add('heatmap');
-                if (parseFloat(milestoneValue) === 0) {
+                // null means no measurements on both sides of this age
+                if (milestoneValue === null) {
                     heightCell.textContent = "-"
                 } else {
                     const deviation = (parseFloat(milestoneValue) - milestone.Average).toFixed(2)
                     heightCell.textContent = deviation > 0 ? `+${deviation}` : deviation
                     heightCell.classList.add(getHeatmapClass(deviation));
-                    heightCell.title = parseFloat(milestoneValue).toFixed(2) + '"'
+                    heightCell.title = `${parseFloat(milestoneValue).toFixed(2)} ${unit}`
                 }
 
                 row.appendChild(heightCell);
@@ -92,6 +94,8 @@ const MeasurementTable = (function() {
         setApiEndpoint: (endpoint) => apiEndpoint = endpoint,
         setTableId: (idValue) => tableId = idValue,
         setHeaderId: (idValue) => headerId = idValue,
+        setUnit: (unitValue) => unit = unitValue,
+        setOptions: (optionValues) => options = optionValues,
 
         // usage
         updateTable: updateTable,